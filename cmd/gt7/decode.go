@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// decodedFrame is a line of gt7 decode output
+type decodedFrame struct {
+	Driver string    `json:"driver"`
+	Time   time.Time `json:"time"`
+	packet.TelemetryFrame
+}
+
+func runDecode(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	all := fs.Bool("all", false, "also output duplicated and reordered packets")
+	unitSystem := fs.String("units", "metric", "units of the values: metric or imperial")
+	src := addSourceFlags(fs, addPortFlags(fs))
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gt7 decode [flags] <session file or pcap/pcapng capture>\n\nWrites a JSON object per packet.\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	units, err := packet.ParseUnitSystem(*unitSystem)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	enc := json.NewEncoder(w)
+
+	decoders := map[string]*packet.Decoder{}
+	failures := 0
+	err = src.datagrams(fs.Arg(0), func(driver string, t time.Time, data []byte) error {
+		decoder, ok := decoders[driver]
+		if !ok {
+			decoder = packet.NewDecoder()
+			decoders[driver] = decoder
+		}
+
+		frame, err := decoder.ReadPacket(data)
+		if err != nil {
+			failures++
+			return nil
+		}
+		if frame.Stale() && !*all {
+			return nil
+		}
+		return enc.Encode(decodedFrame{Driver: driver, Time: t.UTC(), TelemetryFrame: units.Convert(*frame)})
+	})
+	if failures > 0 {
+		fmt.Fprintf(os.Stderr, "%d datagrams could not be decoded\n", failures)
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// errLimit stops reading once enough datagrams were handled
+var errLimit = errors.New("limit reached")
+
+func runDump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	raw := fs.Bool("raw", false, "dump the datagrams as received, without decrypting them")
+	count := fs.Int("n", 0, "number of datagrams to dump, 0 for all")
+	ports := addPortFlags(fs)
+	server := addServerFlags(fs, ports)
+	src := addSourceFlags(fs, ports)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gt7 dump [flags] [session file or pcap/pcapng capture]\n\nListens for telemetry when no file is given.\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	dumped := 0
+	dump := func(driver string, t time.Time, data []byte) error {
+		dumpDatagram(os.Stdout, driver, t, data, *raw)
+		dumped++
+		if *count > 0 && dumped >= *count {
+			return errLimit
+		}
+		return nil
+	}
+
+	if fs.NArg() == 1 {
+		err := src.datagrams(fs.Arg(0), dump)
+		if err == errLimit {
+			return nil
+		}
+		return err
+	}
+
+	cfg, err := server.config()
+	if err != nil {
+		return err
+	}
+	runServer(cfg, func(s gt7.Sample) bool {
+		return dump(s.Driver, s.Received, s.Raw) == nil
+	}, nil)
+	return nil
+}
+
+// dumpDatagram prints a datagram in hex, followed by the value of every known field
+func dumpDatagram(w io.Writer, driver string, t time.Time, data []byte, raw bool) {
+	fmt.Fprintf(w, "%s %s %d bytes\n", t.Format(time.RFC3339Nano), driver, len(data))
+
+	if raw {
+		hexDump(w, data)
+		if len(data) >= 0x44 {
+			fmt.Fprintf(w, "  IV 0x%08X at 0x40\n\n", binary.LittleEndian.Uint32(data[0x40:0x44]))
+		}
+		return
+	}
+
+	decrypted, err := packet.Decrypt(data)
+	if err != nil {
+		fmt.Fprintf(w, "  %v\n", err)
+		hexDump(w, data)
+		fmt.Fprintln(w)
+		return
+	}
+	// The IV is sent in the clear, its decrypted bytes are meaningless
+	copy(decrypted[0x40:0x44], data[0x40:0x44])
+	hexDump(w, decrypted)
+
+	layout := packet.LayoutFor(len(decrypted))
+	fmt.Fprintf(w, "  format %s\n", layout.Format)
+	for _, f := range layout.Fields {
+		decoded := ""
+		if f.Scale != 0 || f.Bias != 0 || f.Width > 0 {
+			decoded = fmt.Sprintf(" -> %g", f.Float(decrypted))
+		}
+		fmt.Fprintf(w, "  0x%03X %-8s %-20s %v%s\n", f.Offset, f.Type, f.Name, f.Value(decrypted), decoded)
+	}
+	fmt.Fprintln(w)
+}
+
+// hexDump prints 16 bytes per row with their offset and printable characters
+func hexDump(w io.Writer, data []byte) {
+	for row := 0; row < len(data); row += 16 {
+		end := row + 16
+		if end > len(data) {
+			end = len(data)
+		}
+
+		var hex, text strings.Builder
+		for i := row; i < row+16; i++ {
+			if i == row+8 {
+				hex.WriteByte(' ')
+			}
+			if i >= end {
+				hex.WriteString("   ")
+				continue
+			}
+			fmt.Fprintf(&hex, "%02x ", data[i])
+			if data[i] >= 0x20 && data[i] < 0x7F {
+				text.WriteByte(data[i])
+			} else {
+				text.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(w, "  %04x  %s |%s|\n", row, hex.String(), text.String())
+	}
+}
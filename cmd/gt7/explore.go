@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7/explore"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+func runExplore(args []string) error {
+	fs := flag.NewFlagSet("explore", flag.ExitOnError)
+	all := fs.Bool("all", false, "also show the words fully covered by known fields")
+	correlations := fs.Int("correlations", 3, "known fields correlated with each word to show")
+	minR := fs.Float64("min-r", 0.5, "smallest absolute correlation to show")
+	src := addSourceFlags(fs, addPortFlags(fs))
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gt7 explore [flags] <session file or pcap/pcapng capture>\n\nPrints statistics of every 4 bytes of the decrypted packets, to help mapping unknown offsets.\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var datagrams [][]byte
+	err := src.datagrams(fs.Arg(0), func(driver string, t time.Time, data []byte) error {
+		if decrypted, err := packet.Decrypt(data); err == nil {
+			datagrams = append(datagrams, decrypted)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(datagrams) == 0 {
+		return fmt.Errorf("no datagram could be decrypted")
+	}
+	fmt.Printf("%d datagrams\n\n", len(datagrams))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "offset\tfield\tguess\tentropy\tbyte entropy\tchanges\trange\tcorrelations\n")
+	for _, word := range explore.Analyze(datagrams, *correlations) {
+		if word.Unmapped == 0 && !*all {
+			continue
+		}
+		field := word.Field
+		if word.Unmapped > 0 {
+			field = strings.TrimPrefix(fmt.Sprintf("%s, %d unmapped bytes", field, word.Unmapped), ", ")
+		}
+
+		var related []string
+		for _, c := range word.Correlations {
+			if c.R >= *minR || c.R <= -*minR {
+				related = append(related, fmt.Sprintf("%s %+.2f", c.Field, c.R))
+			}
+		}
+
+		fmt.Fprintf(w, "0x%03X\t%s\t%s\t%.2f\t%.1f %.1f %.1f %.1f\t%.0f%%\t%s\t%s\n",
+			word.Offset, field, word.Guess, word.Entropy,
+			word.ByteEntropy[0], word.ByteEntropy[1], word.ByteEntropy[2], word.ByteEntropy[3],
+			word.Changes*100, wordRange(word), strings.Join(related, ", "))
+	}
+	return w.Flush()
+}
+
+// wordRange describes the values of a word with its guessed interpretation
+func wordRange(w explore.Word) string {
+	switch w.Guess {
+	case explore.GuessConstant:
+		return fmt.Sprintf("0x%08X", w.First)
+	case explore.GuessFloat:
+		return fmt.Sprintf("%.4g .. %.4g", w.FloatMin, w.FloatMax)
+	case explore.GuessFlags:
+		return fmt.Sprintf("set 0x%08X toggled 0x%08X", w.SetBits, w.ToggledBits)
+	case explore.GuessInt16:
+		return fmt.Sprintf("%d .. %d, %d .. %d", w.LowMin, w.LowMax, w.HighMin, w.HighMax)
+	}
+	return fmt.Sprintf("%d .. %d", w.IntMin, w.IntMax)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/export"
+)
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "csv", "output format: csv, parquet or motec")
+	output := fs.String("o", "", "output file, standard output when empty. MoTeC exports write <name>.ld and <name>.ldx")
+	fromLap := fs.Int("from-lap", 0, "first lap to export")
+	toLap := fs.Int("to-lap", 0, "last lap to export, 0 for every lap")
+	units := fs.Bool("units", false, "add units to the CSV header")
+	src := addSourceFlags(fs, addPortFlags(fs))
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gt7 export [flags] <session file or pcap/pcapng capture>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := export.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	samples, err := src.load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	samples = export.FilterLaps(samples, *fromLap, *toLap)
+
+	if f == export.FormatMoTeC {
+		if *output == "" {
+			return errors.New("MoTeC exports need an output file")
+		}
+		return exportMoTeC(strings.TrimSuffix(*output, filepath.Ext(*output)), samples)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if f == export.FormatParquet {
+		return export.WriteParquet(w, samples)
+	}
+	return export.WriteCSV(w, samples, *units)
+}
+
+func exportMoTeC(name string, samples []gt7.Sample) error {
+	ld, err := os.Create(name + ".ld")
+	if err != nil {
+		return err
+	}
+	defer ld.Close()
+
+	ldx, err := os.Create(name + ".ldx")
+	if err != nil {
+		return err
+	}
+	defer ldx.Close()
+
+	return export.WriteMoTeC(ld, ldx, samples)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7/importer"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/session"
+)
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dir := fs.String("dir", session.DefaultDir(), "session directory, the datasource's sessions directory to see the sessions in Grafana")
+	format := fs.String("format", "", fmt.Sprintf("format of the files, guessed from their extension when empty: %v", importer.Formats))
+	driver := fs.String("driver", "", "driver of the imported sessions")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gt7 import [flags] <file>...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	store := session.Store{Dir: *dir}
+	for _, path := range fs.Args() {
+		f, err := importer.ParseFormat(*format)
+		if *format == "" {
+			f, err = importer.DetectFormat(path)
+		}
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		ids, err := importer.Import(store, file, f, importer.Options{Driver: *driver})
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		for _, id := range ids {
+			fmt.Printf("%s: imported session %s\n", path, id)
+		}
+	}
+
+	return nil
+}
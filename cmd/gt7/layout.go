@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+func runLayout(args []string) error {
+	fs := flag.NewFlagSet("layout", flag.ExitOnError)
+	docs := fs.Bool("docs", false, "write the Markdown documentation of the packet formats")
+	options := fs.Bool("options", false, "write the gt7Options list of the query editor")
+	output := fs.String("o", "", "output file, standard output when empty")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gt7 layout -docs|-options [-o file]\n\nGenerates files from the packet layouts, see go generate ./pkg/gt7/packet.\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *docs == *options || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var b bytes.Buffer
+	if *docs {
+		writeLayoutDocs(&b)
+	} else {
+		writeFieldOptions(&b)
+	}
+
+	if *output == "" {
+		_, err := os.Stdout.Write(b.Bytes())
+		return err
+	}
+	return os.WriteFile(*output, b.Bytes(), 0644)
+}
+
+// frameFields returns the documentation of every TelemetryFrame field, in declaration order
+func frameFields() []packet.Field {
+	known := map[string]packet.Field{}
+	for _, f := range packet.LayoutTilde.Fields {
+		known[f.Name] = f
+	}
+	for _, f := range packet.DerivedFields {
+		known[f.Name] = f
+	}
+
+	t := reflect.TypeOf(packet.TelemetryFrame{})
+	fields := make([]packet.Field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f, ok := known[t.Field(i).Name]
+		if !ok {
+			f = packet.Field{Name: t.Field(i).Name}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func writeFieldOptions(w io.Writer) {
+	fmt.Fprintf(w, "// Code generated by gt7 layout -options. DO NOT EDIT.\n\n")
+	fmt.Fprintf(w, "export const gt7Options = [\n")
+	fmt.Fprintf(w, "  { label: 'status', value: 'status' },\n")
+	for _, f := range frameFields() {
+		label := f.Label
+		if label == "" {
+			label = f.Name
+		}
+		fmt.Fprintf(w, "  { label: '%s', value: '%s' },\n", label, f.Name)
+	}
+	fmt.Fprintf(w, "\n  { label: 'All', value: '*' },\n];\n")
+}
+
+func writeLayoutDocs(w io.Writer) {
+	fmt.Fprintf(w, "<!-- Code generated by gt7 layout -docs. DO NOT EDIT. -->\n\n")
+	fmt.Fprintf(w, "# GT7 packet layout\n\n")
+	fmt.Fprintf(w, "The heartbeat sent to the PlayStation selects the packet format. Each format extends the previous one.\n")
+	fmt.Fprintf(w, "Packets are encrypted with Salsa20, keyed with the first 32 bytes of `Simulator Interface Packet GT7 ver 0.0`.\n")
+	fmt.Fprintf(w, "The nonce is the IV at 0x40, sent in the clear, XORed with the mask of the format, followed by the IV itself.\n\n")
+
+	fmt.Fprintf(w, "| Heartbeat | Size | IV mask |\n|---|---|---|\n")
+	for _, l := range packet.Layouts {
+		fmt.Fprintf(w, "| `%s` | 0x%X | 0x%08X |\n", l.Format, l.Size, l.IVMask)
+	}
+
+	previous := 0
+	for _, l := range packet.Layouts {
+		fmt.Fprintf(w, "\n## Format %s\n\n", l.Format)
+		if previous > 0 {
+			fmt.Fprintf(w, "Adds to the previous format:\n\n")
+		}
+		fmt.Fprintf(w, "| Offset | Type | Field | Decoding | Unit | Description |\n|---|---|---|---|---|---|\n")
+		for _, f := range l.Fields {
+			if f.Offset < previous {
+				continue
+			}
+			fmt.Fprintf(w, "| 0x%03X | %s | %s | %s | %s | %s |\n", f.Offset, f.Type, fieldName(f), decoding(f), packet.FieldUnits[f.Name], f.Doc)
+		}
+		previous = l.Size
+	}
+
+	fmt.Fprintf(w, "\n## Derived fields\n\nComputed by the decoder rather than read from the packet.\n\n")
+	fmt.Fprintf(w, "| Field | Unit | Description |\n|---|---|---|\n")
+	for _, f := range packet.DerivedFields {
+		fmt.Fprintf(w, "| %s | %s | %s |\n", f.Name, packet.FieldUnits[f.Name], f.Doc)
+	}
+}
+
+func fieldName(f packet.Field) string {
+	if f.Name == packet.UnknownField {
+		return "?"
+	}
+	if !f.Decoded() {
+		return f.Name + " (not decoded)"
+	}
+	return f.Name
+}
+
+// decoding describes how the raw value becomes the TelemetryFrame value
+func decoding(f packet.Field) string {
+	var steps []string
+	if f.Width > 0 {
+		steps = append(steps, fmt.Sprintf("bits %d-%d", f.Shift, f.Shift+f.Width-1))
+	}
+	if f.Scale != 0 {
+		steps = append(steps, fmt.Sprintf("× %.6g", f.Scale))
+	}
+	if f.Bias != 0 {
+		steps = append(steps, fmt.Sprintf("%+g", f.Bias))
+	}
+	return strings.Join(steps, ", ")
+}
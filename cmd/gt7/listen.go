@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+func runListen(args []string) error {
+	fs := flag.NewFlagSet("listen", flag.ExitOnError)
+	server := addServerFlags(fs, addPortFlags(fs))
+	rate := fs.Float64("rate", 4, "lines printed per second and driver, 0 for every packet")
+	unitSystem := fs.String("units", "metric", "units of the values: metric or imperial")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gt7 listen [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cfg, err := server.config()
+	if err != nil {
+		return err
+	}
+	units, err := packet.ParseUnitSystem(*unitSystem)
+	if err != nil {
+		return err
+	}
+
+	var interval time.Duration
+	if *rate > 0 {
+		interval = time.Duration(float64(time.Second) / *rate)
+	}
+	printed := map[string]time.Time{}
+
+	runServer(cfg, func(s gt7.Sample) bool {
+		if s.Received.Sub(printed[s.Driver]) < interval {
+			return true
+		}
+		printed[s.Driver] = s.Received
+		fmt.Println(formatSample(s, units))
+		return true
+	}, func(st gt7.Status) {
+		driver := st.Driver
+		if driver == "" {
+			driver = "server"
+		}
+		if st.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s %s: %v\n", st.Time.Format("15:04:05"), driver, st.State, st.Err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%s %s %s\n", st.Time.Format("15:04:05"), driver, st.State)
+	})
+	return nil
+}
+
+// formatSample is a one line summary of a frame
+func formatSample(s gt7.Sample, units packet.UnitSystem) string {
+	f := units.Convert(s.Frame)
+	return fmt.Sprintf("%s %-12s lap %2d/%-2d gear %d (%d) %6.1f %s %5.0f rpm throttle %3.0f%% brake %3.0f%% fuel %5.1f/%-3.0f %s",
+		s.Received.Format("15:04:05.000"), s.Driver,
+		f.CurrentLap, f.TotalLaps, f.CurrentGear, f.SuggestedGear,
+		f.CarSpeed, units.Unit("CarSpeed"), f.RPM, f.Throttle, f.Brake,
+		f.CurrentFuel, f.FuelCapacity, strings.Join(flagNames(f.Flags), ","))
+}
+
+// flagNames returns the names of the simulator flags set, in bit order
+func flagNames(flags uint16) []string {
+	var names []string
+	for bit := uint16(1); bit != 0; bit <<= 1 {
+		if flags&bit == 0 {
+			continue
+		}
+		if name, ok := packet.SimFlagNames[bit]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("0x%04X", bit))
+		}
+	}
+	return names
+}
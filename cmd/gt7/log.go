@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// stderrLogger replaces the plugin SDK's JSON logger with readable lines, keeping the
+// telemetry server's debug and info messages for -v
+type stderrLogger struct {
+	verbose bool
+}
+
+func (l stderrLogger) log(level, msg string, args []interface{}) {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteString(": ")
+	b.WriteString(msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+	fmt.Fprintln(os.Stderr, b.String())
+}
+
+func (l stderrLogger) Debug(msg string, args ...interface{}) {
+	if l.verbose {
+		l.log("debug", msg, args)
+	}
+}
+
+func (l stderrLogger) Info(msg string, args ...interface{}) {
+	if l.verbose {
+		l.log("info", msg, args)
+	}
+}
+
+func (l stderrLogger) Warn(msg string, args ...interface{}) {
+	l.log("warn", msg, args)
+}
+
+func (l stderrLogger) Error(msg string, args ...interface{}) {
+	l.log("error", msg, args)
+}
@@ -0,0 +1,65 @@
+// Command gt7 works with Gran Turismo 7 telemetry outside of Grafana.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"decode":  {"decode a session file or capture to JSON lines", runDecode},
+	"dump":    {"hex dump datagrams with their field offsets annotated", runDump},
+	"explore": {"print per-offset statistics to map unknown packet bytes", runExplore},
+	"export":  {"export a session file as CSV, Parquet or MoTeC", runExport},
+	"import":  {"import third-party captures as sessions", runImport},
+	"layout":  {"generate the packet layout docs and query editor field list", runLayout},
+	"listen":  {"send heartbeats and print the live telemetry", runListen},
+	"report":  {"print driving analyses of a session file or capture", runReport},
+	"stats":   {"print packet counters of a session file, capture or live server", runStats},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: gt7 [-v] <command> [flags]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun gt7 <command> -h for the flags of a command, and gt7 -v <command> to log\nwhat the telemetry server does.\n")
+}
+
+func main() {
+	args := os.Args[1:]
+	verbose := len(args) > 0 && args[0] == "-v"
+	if verbose {
+		args = args[1:]
+	}
+	log.DefaultLogger = stderrLogger{verbose: verbose}
+
+	if len(args) < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "gt7 %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+}
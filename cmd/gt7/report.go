@@ -0,0 +1,188 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/analysis"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// reports print an analysis of the samples as tab separated columns
+var reports = map[string]func(w io.Writer, samples []gt7.Sample, units packet.UnitSystem){
+	"balance": balanceReport,
+	"braking": brakingReport,
+	"corners": cornersReport,
+	"gears":   gearsReport,
+	"shifts":  shiftsReport,
+	"slip":    slipReport,
+}
+
+func reportNames() string {
+	names := make([]string, 0, len(reports))
+	for name := range reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|")
+}
+
+func runReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	unitSystem := fs.String("units", "metric", "units of the values: metric or imperial")
+	src := addSourceFlags(fs, addPortFlags(fs))
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gt7 report [flags] <%s> <session file or pcap/pcapng capture>\n", reportNames())
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	report, ok := reports[fs.Arg(0)]
+	if !ok {
+		fs.Usage()
+		os.Exit(2)
+	}
+	units, err := packet.ParseUnitSystem(*unitSystem)
+	if err != nil {
+		return err
+	}
+
+	samples, err := src.load(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	report(w, samples, units)
+	return w.Flush()
+}
+
+func slipReport(w io.Writer, samples []gt7.Sample, units packet.UnitSystem) {
+	events := analysis.SlipEvents(samples, analysis.DefaultSlipConfig)
+
+	fmt.Fprintf(w, "time\tlap\twheel\tevent\tduration\tspeed\tpeak slip\tposition\n")
+	for _, e := range events {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d ms\t%.0f %s\t%.0f%%\t%.0f, %.0f %s\n",
+			e.Start.Format("15:04:05.000"), e.Lap, e.Wheel, e.Kind, e.Duration.Milliseconds(),
+			units.Value("CarSpeed", e.Speed), units.Unit("CarSpeed"), e.PeakSlip*100,
+			units.Value("PositionX", e.PositionX), units.Value("PositionZ", e.PositionZ), units.Unit("PositionX"))
+	}
+
+	fmt.Fprintf(w, "\nlap\twheel\tlockups\tlockup time\twheelspins\twheelspin time\tpeak slip\n")
+	for _, l := range analysis.SummarizeSlip(events) {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d ms\t%d\t%d ms\t%.0f%%\n",
+			l.Lap, l.Wheel, l.Lockups, l.LockupTime.Milliseconds(), l.Wheelspins, l.WheelspinTime.Milliseconds(), l.PeakSlip*100)
+	}
+}
+
+func balanceReport(w io.Writer, samples []gt7.Sample, units packet.UnitSystem) {
+	fmt.Fprintf(w, "time\tlap\tcorner\tduration\tentry\tmid\texit\tmean\toversteer\tundersteer\n")
+	for _, b := range analysis.CornerBalances(samples, analysis.DefaultCornerConfig) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d ms\t%+.3f\t%+.3f\t%+.3f\t%+.3f\t%+.3f\t%+.3f\n",
+			b.Start.Format("15:04:05.000"), b.Lap, b.Corner, b.Duration.Milliseconds(),
+			b.Entry, b.Mid, b.Exit, b.Mean, b.PeakOversteer, b.PeakUndersteer)
+	}
+}
+
+func cornersReport(w io.Writer, samples []gt7.Sample, units packet.UnitSystem) {
+	reports := analysis.CornerReports(samples, analysis.DefaultCornerConfig)
+	if len(reports) == 0 {
+		fmt.Fprintf(w, "no complete lap to take the corners from\n")
+		return
+	}
+
+	speed := func(v float32) string {
+		return fmt.Sprintf("%.0f %s", units.Value("CarSpeed", v), units.Unit("CarSpeed"))
+	}
+	length := func(m float64) string {
+		return fmt.Sprintf("%.0f %s", units.Length(m), units.Unit("PositionX"))
+	}
+	fmt.Fprintf(w, "lap\tsegment\ttime\tlost\tentry\tapex\texit\ttop\tbrake point\n")
+	for _, r := range reports {
+		segment := fmt.Sprintf("T%d", r.Number)
+		if r.Kind == analysis.SegmentStraight {
+			segment = fmt.Sprintf("straight to T%d", r.Number)
+			if r.Number == 0 {
+				segment = "straight to finish"
+			}
+		}
+		brake := ""
+		if r.Braked {
+			brake = fmt.Sprintf("%s (%s before)", length(r.BrakeDistance), length(r.BrakeBeforeEntry))
+		}
+		fmt.Fprintf(w, "%d\t%s\t%.3f s\t%+.3f s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Lap, segment, r.Time.Seconds(), r.TimeLost.Seconds(),
+			speed(r.EntrySpeed), speed(r.ApexSpeed), speed(r.ExitSpeed), speed(r.TopSpeed), brake)
+	}
+}
+
+func brakingReport(w io.Writer, samples []gt7.Sample, units packet.UnitSystem) {
+	speed := func(v float32) string {
+		return fmt.Sprintf("%.0f %s", units.Value("CarSpeed", v), units.Unit("CarSpeed"))
+	}
+	length := func(m float64) string {
+		return fmt.Sprintf("%.0f %s", units.Length(m), units.Unit("PositionX"))
+	}
+	fmt.Fprintf(w, "time\tlap\tzone\tbrake point\tlength\tinitial\tmin\trelease\tpeak\tdecel\trelease profile\ttrail\tlockups\tvs reference\tlost\n")
+	for _, z := range analysis.BrakingReport(samples, analysis.DefaultBrakingConfig) {
+		zone := "-"
+		if z.Number > 0 {
+			zone = fmt.Sprintf("B%d", z.Number)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%.0f%%\t%.2f g\t%.0f/%.0f/%.0f%% in %d ms\t%d ms\t%d\t%+.0f %s %+.0f/%+.0f %s\t%+.3f s\n",
+			z.Start.Format("15:04:05.000"), z.Lap, zone, length(z.BrakeDistance), length(z.Length),
+			speed(z.InitialSpeed), speed(z.MinSpeed), speed(z.ReleaseSpeed), z.PeakBrake, z.PeakDeceleration,
+			z.ReleaseProfile[0], z.ReleaseProfile[1], z.ReleaseProfile[2], z.ReleaseTime.Milliseconds(),
+			z.TrailTime.Milliseconds(), z.Lockups,
+			units.Length(z.BrakePointDelta), units.Unit("PositionX"), units.Value("CarSpeed", z.InitialSpeedDelta), units.Value("CarSpeed", z.MinSpeedDelta), units.Unit("CarSpeed"),
+			z.TimeLost.Seconds())
+	}
+}
+
+func rpmOrUnknown(rpm float64) string {
+	if rpm <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f", rpm)
+}
+
+func shiftsReport(w io.Writer, samples []gt7.Sample, units packet.UnitSystem) {
+	report := analysis.ShiftAnalysis(samples, analysis.DefaultShiftConfig)
+	fmt.Fprintf(w, "car\tgear\toptimal rpm\tshifts\tmean rpm\n")
+	for _, p := range report.Points {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%s\n", p.CarID, p.Gear, rpmOrUnknown(p.OptimalRPM), p.Shifts, rpmOrUnknown(p.MeanRPM))
+	}
+
+	fmt.Fprintf(w, "\ntime\tlap\tshift\trpm\tspeed\toptimal rpm\tvs optimal\n")
+	for _, s := range report.Shifts {
+		delta := "-"
+		if s.OptimalRPM > 0 {
+			delta = fmt.Sprintf("%+.0f", s.RPMDelta)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d-%d\t%.0f\t%.0f %s\t%s\t%s\n",
+			s.Time.Format("15:04:05.000"), s.Lap, s.From, s.To, s.RPM,
+			units.Value("CarSpeed", s.Speed), units.Unit("CarSpeed"), rpmOrUnknown(s.OptimalRPM), delta)
+	}
+}
+
+func gearsReport(w io.Writer, samples []gt7.Sample, units packet.UnitSystem) {
+	fmt.Fprintf(w, "lap\tR\t1\t2\t3\t4\t5\t6\t7\t8\tlimiter\tshift lights\toff suggested\tup\tdown\tvs optimal\n")
+	for _, l := range analysis.ShiftAnalysis(samples, analysis.DefaultShiftConfig).Laps {
+		fmt.Fprintf(w, "%d\t", l.Lap)
+		for _, t := range l.GearTime {
+			fmt.Fprintf(w, "%.1f s\t", t.Seconds())
+		}
+		fmt.Fprintf(w, "%.1f s\t%.1f s\t%.1f s\t%d\t%d\t%+.0f rpm\n",
+			l.LimiterTime.Seconds(), l.ShiftLightsTime.Seconds(), l.OffSuggestedTime.Seconds(), l.Upshifts, l.Downshifts, l.MeanRPMDelta)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/capture"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/session"
+)
+
+// sampleSource is a session file or a pcap/pcapng capture given on the command line
+type sampleSource struct {
+	*portFlags
+	console string
+}
+
+func addSourceFlags(fs *flag.FlagSet, ports *portFlags) *sampleSource {
+	src := &sampleSource{portFlags: ports}
+	fs.StringVar(&src.console, "console", "", "console IP to decode from captures of several consoles")
+	return src
+}
+
+func (src *sampleSource) filter() capture.Filter {
+	return capture.Filter{HeartbeatPort: src.heartbeatPort, ServerPort: src.serverPort}
+}
+
+func isCapture(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pcap", ".pcapng", ".cap":
+		return true
+	}
+	return false
+}
+
+// load decodes the samples of a session file or, for captures, of a single console
+func (src *sampleSource) load(path string) ([]gt7.Sample, error) {
+	if !isCapture(path) {
+		_, samples, err := session.ReadSamples(path)
+		return samples, err
+	}
+
+	samples, err := capture.ReadSamples(path, src.filter())
+	if err != nil {
+		return nil, err
+	}
+
+	consoles := map[string]bool{}
+	for _, s := range samples {
+		consoles[s.Driver] = true
+	}
+	if src.console == "" {
+		if len(consoles) <= 1 {
+			return samples, nil
+		}
+		names := make([]string, 0, len(consoles))
+		for name := range consoles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("the capture has telemetry from several consoles, pick one with -console: %s", strings.Join(names, ", "))
+	}
+
+	var filtered []gt7.Sample
+	for _, s := range samples {
+		if s.Driver == src.console {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered, nil
+}
+
+// datagrams calls fn with every raw datagram of a session file, or with the telemetry
+// datagrams of a capture, in file order. Capture datagrams are named after their console IP.
+func (src *sampleSource) datagrams(path string, fn func(driver string, t time.Time, data []byte) error) error {
+	if !isCapture(path) {
+		sr, err := session.Open(path)
+		if err != nil {
+			return err
+		}
+		defer sr.Close()
+
+		for {
+			rec, err := sr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := fn(sr.Metadata.Driver, rec.Time, rec.Data); err != nil {
+				return err
+			}
+		}
+	}
+
+	r, err := capture.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	filter := src.filter()
+	for {
+		dg, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !filter.Telemetry(dg) {
+			continue
+		}
+		console := dg.Src.IP.String()
+		if src.console != "" && console != src.console {
+			continue
+		}
+		if err := fn(console, dg.Time, dg.Data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// portFlags are the GT7 ports, used to listen and to filter captures
+type portFlags struct {
+	heartbeatPort int
+	serverPort    int
+}
+
+func addPortFlags(fs *flag.FlagSet) *portFlags {
+	f := &portFlags{}
+	fs.IntVar(&f.heartbeatPort, "heartbeat-port", gt7.DefaultHeartbeatPort, "port the consoles listen for heartbeats on")
+	fs.IntVar(&f.serverPort, "server-port", gt7.DefaultServerPort, "port telemetry is received on")
+	return f
+}
+
+// serverFlags configure the telemetry server of the live commands
+type serverFlags struct {
+	*portFlags
+	consoles    string
+	bindAddress string
+	network     string
+	format      string
+}
+
+func addServerFlags(fs *flag.FlagSet, ports *portFlags) *serverFlags {
+	f := &serverFlags{portFlags: ports}
+	fs.StringVar(&f.consoles, "ip", "", "comma separated PlayStation IPs, or name=IP pairs. Discovered on the local network when empty")
+	fs.StringVar(&f.bindAddress, "bind", "", "IP address or interface to listen on")
+	fs.StringVar(&f.network, "network", gt7.DefaultNetwork, "udp4, udp6 or udp")
+	fs.StringVar(&f.format, "packet-format", string(packet.FormatA), "packet format requested from the consoles: A, B or ~")
+	return f
+}
+
+func (f *serverFlags) config() (gt7.ServerConfig, error) {
+	var consoles []gt7.Console
+	for _, c := range strings.Split(f.consoles, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		name, ip := c, c
+		if i := strings.Index(c, "="); i >= 0 {
+			name, ip = c[:i], c[i+1:]
+		}
+		consoles = append(consoles, gt7.Console{Name: name, IP: ip})
+	}
+
+	cfg := gt7.ServerConfig{
+		Consoles:      consoles,
+		HeartbeatPort: f.heartbeatPort,
+		ServerPort:    f.serverPort,
+		BindAddress:   f.bindAddress,
+		Network:       f.network,
+		Format:        packet.Format(f.format),
+	}.WithDefaults()
+	return cfg, cfg.Validate()
+}
+
+// runServer runs the telemetry server until interrupted, calling onSample and onStatus from
+// a single goroutine. onSample returns false to stop.
+func runServer(cfg gt7.ServerConfig, onSample func(gt7.Sample) bool, onStatus func(gt7.Status)) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	samples := make(chan gt7.Sample, 60)
+	statuses := make(chan gt7.Status, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		gt7.SuperviseTelemetryServer(ctx, cfg, samples, statuses)
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case s := <-samples:
+			if !onSample(s) {
+				stop()
+			}
+		case st := <-statuses:
+			if onStatus != nil {
+				onStatus(st)
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// fileStats are the counters of a driver in a session file or capture
+type fileStats struct {
+	decoder *packet.Decoder
+
+	datagrams       int
+	bytes           int
+	decodeFailures  int
+	magicMismatches int
+	packetsLost     int
+	duplicates      int
+	reordered       int
+	first, last     time.Time
+	laps            map[int16]bool
+	bestLap         int32
+	cars            map[int32]bool
+}
+
+func (s *fileStats) add(t time.Time, data []byte) {
+	s.datagrams++
+	s.bytes += len(data)
+	if s.first.IsZero() {
+		s.first = t
+	}
+	s.last = t
+
+	frame, err := s.decoder.ReadPacket(data)
+	if err != nil {
+		if errors.Is(err, packet.ErrMagicMismatch) {
+			s.magicMismatches++
+		} else {
+			s.decodeFailures++
+		}
+		return
+	}
+
+	s.packetsLost += int(frame.PacketsLost)
+	if frame.PacketFlags&packet.FlagDuplicate != 0 {
+		s.duplicates++
+	}
+	if frame.PacketFlags&packet.FlagReordered != 0 {
+		s.reordered++
+	}
+	if frame.Stale() {
+		return
+	}
+
+	if frame.CurrentLap > 0 {
+		s.laps[frame.CurrentLap] = true
+	}
+	if frame.BestLap > 0 {
+		s.bestLap = frame.BestLap
+	}
+	if frame.CarID != 0 {
+		s.cars[frame.CarID] = true
+	}
+}
+
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	ports := addPortFlags(fs)
+	server := addServerFlags(fs, ports)
+	src := addSourceFlags(fs, ports)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gt7 stats [flags] [session file or pcap/pcapng capture]\n\nPrints the live counters of the telemetry server every second when no file is given.\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if fs.NArg() == 1 {
+		return fileReport(src, fs.Arg(0))
+	}
+
+	cfg, err := server.config()
+	if err != nil {
+		return err
+	}
+	cfg.Stats = gt7.NewStats()
+
+	printed := time.Now()
+	runServer(cfg, func(s gt7.Sample) bool {
+		if time.Since(printed) >= time.Second {
+			printed = time.Now()
+			liveReport(os.Stdout, cfg.Stats)
+		}
+		return true
+	}, nil)
+	return nil
+}
+
+func fileReport(src *sampleSource, path string) error {
+	drivers := map[string]*fileStats{}
+	err := src.datagrams(path, func(driver string, t time.Time, data []byte) error {
+		s, ok := drivers[driver]
+		if !ok {
+			s = &fileStats{decoder: packet.NewDecoder(), laps: map[int16]bool{}, cars: map[int32]bool{}}
+			drivers[driver] = s
+		}
+		s.add(t, data)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := drivers[name]
+		duration := s.last.Sub(s.first)
+		rate := 0.0
+		if duration > 0 {
+			rate = float64(s.datagrams-1) / duration.Seconds()
+		}
+		cars := make([]int, 0, len(s.cars))
+		for car := range s.cars {
+			cars = append(cars, int(car))
+		}
+		sort.Ints(cars)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\n", name)
+		fmt.Fprintf(w, "  start\t%s\n", s.first.Format(time.RFC3339))
+		fmt.Fprintf(w, "  duration\t%s\n", duration.Round(time.Millisecond))
+		fmt.Fprintf(w, "  datagrams\t%d (%d bytes, %.1f/s)\n", s.datagrams, s.bytes, rate)
+		fmt.Fprintf(w, "  decode failures\t%d\n", s.decodeFailures)
+		fmt.Fprintf(w, "  magic mismatches\t%d\n", s.magicMismatches)
+		fmt.Fprintf(w, "  packets lost\t%d\n", s.packetsLost)
+		fmt.Fprintf(w, "  duplicates\t%d\n", s.duplicates)
+		fmt.Fprintf(w, "  reordered\t%d\n", s.reordered)
+		fmt.Fprintf(w, "  laps\t%d\n", len(s.laps))
+		if s.bestLap > 0 {
+			fmt.Fprintf(w, "  best lap\t%s\n", time.Duration(s.bestLap)*time.Millisecond)
+		}
+		fmt.Fprintf(w, "  cars\t%v\n", cars)
+		w.Flush()
+	}
+	return nil
+}
+
+func liveReport(w io.Writer, stats *gt7.Stats) {
+	snapshot := stats.Snapshot()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "%s\tdatagrams\tbytes\tfailures\tmismatches\tlost\tduplicates\treordered\theartbeat errors\t\n", time.Now().Format("15:04:05"))
+	for _, name := range stats.Drivers() {
+		s := snapshot[name]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t\n", name,
+			s.Datagrams, s.Bytes, s.DecodeFailures, s.MagicMismatches,
+			s.PacketsLost, s.Duplicates, s.Reordered, s.HeartbeatErrors)
+	}
+	tw.Flush()
+}
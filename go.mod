@@ -5,6 +5,7 @@ go 1.17
 require (
 	github.com/grafana/grafana-plugin-sdk-go v0.105.0
 	github.com/magefile/mage v1.11.0
+	github.com/prometheus/client_golang v1.10.0
 	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 )
 
@@ -27,10 +28,9 @@ require (
 	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
 	github.com/mitchellh/go-testing-interface v1.0.0 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
-	github.com/modern-go/reflect2 v1.0.1 // indirect
+	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/oklog/run v1.0.0 // indirect
 	github.com/olekukonko/tablewriter v0.0.5 // indirect
-	github.com/prometheus/client_golang v1.10.0 // indirect
 	github.com/prometheus/client_model v0.2.0 // indirect
 	github.com/prometheus/common v0.23.0 // indirect
 	github.com/prometheus/procfs v0.6.0 // indirect
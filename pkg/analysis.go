@@ -0,0 +1,464 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/analysis"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// Query types reading the recorded sessions of the query time range, the default query type
+// streams live telemetry
+const (
+	queryTypeSlipEvents    = "slipEvents"
+	queryTypeSlipSummary   = "slipSummary"
+	queryTypeCornerBalance = "cornerBalance"
+	queryTypeCorners       = "corners"
+	queryTypeBraking       = "braking"
+	queryTypeShiftPoints   = "shiftPoints"
+	queryTypeShifts        = "shifts"
+	queryTypeGears         = "gears"
+)
+
+// analysisQuery runs a query on the sessions recorded during the time range of the query
+func (d *GT7TelemetryDatasource) analysisQuery(query backend.DataQuery, qm queryModel) backend.DataResponse {
+	response := backend.DataResponse{}
+
+	samples, err := d.sessions.SamplesBetween(query.TimeRange.From, query.TimeRange.To, qm.Driver)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	var frame *data.Frame
+	switch query.QueryType {
+	case queryTypeSlipEvents:
+		frame = slipEventsFrame(analysis.SlipEvents(samples, analysis.DefaultSlipConfig), d.units)
+	case queryTypeSlipSummary:
+		frame = slipSummaryFrame(analysis.SummarizeSlip(analysis.SlipEvents(samples, analysis.DefaultSlipConfig)))
+	case queryTypeCornerBalance:
+		frame = cornerBalanceFrame(analysis.CornerBalances(samples, analysis.DefaultCornerConfig))
+	case queryTypeCorners:
+		frame = cornersFrame(analysis.CornerReports(samples, analysis.DefaultCornerConfig), d.units)
+	case queryTypeBraking:
+		frame = brakingFrame(analysis.BrakingReport(samples, analysis.DefaultBrakingConfig), d.units)
+	case queryTypeShiftPoints:
+		frame = shiftPointsFrame(analysis.ShiftAnalysis(samples, analysis.DefaultShiftConfig).Points)
+	case queryTypeShifts:
+		frame = shiftsFrame(analysis.ShiftAnalysis(samples, analysis.DefaultShiftConfig).Shifts, d.units)
+	case queryTypeGears:
+		frame = gearsFrame(analysis.ShiftAnalysis(samples, analysis.DefaultShiftConfig).Laps)
+	default:
+		response.Error = fmt.Errorf("unknown query type %q", query.QueryType)
+		return response
+	}
+
+	if qm.Lap > 0 {
+		frame, err = filterLap(frame, int16(qm.Lap))
+		if err != nil {
+			response.Error = err
+			return response
+		}
+	}
+
+	response.Frames = append(response.Frames, frame)
+	return response
+}
+
+// filterLap keeps the rows of a lap. Frames without a "lap" field, e.g. shift points that sum
+// up every lap, can't be filtered.
+func filterLap(frame *data.Frame, lap int16) (*data.Frame, error) {
+	for i, field := range frame.Fields {
+		if field.Name == "lap" {
+			return frame.FilterRowsByField(i, func(v interface{}) (bool, error) {
+				return v.(int16) == lap, nil
+			})
+		}
+	}
+	return nil, fmt.Errorf("%s can't be limited to a lap, leave the lap empty", frame.Name)
+}
+
+func withUnit(f *data.Field, unit string) *data.Field {
+	f.Config = &data.FieldConfig{Unit: packet.GrafanaUnit(unit)}
+	return f
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// slipEventsFrame lists lockups and wheelspin with the fields Grafana reads annotations from:
+// time, timeEnd, title, text and tags
+func slipEventsFrame(events []analysis.SlipEvent, units packet.UnitSystem) *data.Frame {
+	var (
+		start, end           []time.Time
+		titles, texts, tags  []string
+		drivers, wheels      []string
+		laps                 []int16
+		durations, peakSlips []float64
+		speeds, xs, zs       []float32
+	)
+	speedUnit := units.Unit("CarSpeed")
+	for _, e := range events {
+		speed := units.Value("CarSpeed", e.Speed)
+		start = append(start, e.Start)
+		end = append(end, e.End)
+		titles = append(titles, fmt.Sprintf("%s %s", e.Wheel, e.Kind))
+		texts = append(texts, fmt.Sprintf("%s, lap %d: %s %s for %d ms at %.0f %s, peak slip %.0f%%",
+			e.Driver, e.Lap, e.Wheel, e.Kind, e.Duration.Milliseconds(), speed, speedUnit, e.PeakSlip*100))
+		tags = append(tags, string(e.Kind)+","+e.Wheel)
+		drivers = append(drivers, e.Driver)
+		wheels = append(wheels, e.Wheel)
+		laps = append(laps, e.Lap)
+		durations = append(durations, milliseconds(e.Duration))
+		peakSlips = append(peakSlips, e.PeakSlip*100)
+		speeds = append(speeds, speed)
+		xs = append(xs, units.Value("PositionX", e.PositionX))
+		zs = append(zs, units.Value("PositionZ", e.PositionZ))
+	}
+
+	return data.NewFrame("slip events",
+		data.NewField("time", nil, start),
+		data.NewField("timeEnd", nil, end),
+		data.NewField("title", nil, titles),
+		data.NewField("text", nil, texts),
+		data.NewField("tags", nil, tags),
+		data.NewField("driver", nil, drivers),
+		data.NewField("lap", nil, laps),
+		data.NewField("wheel", nil, wheels),
+		withUnit(data.NewField("duration", nil, durations), "ms"),
+		withUnit(data.NewField("speed", nil, speeds), speedUnit),
+		withUnit(data.NewField("peakSlip", nil, peakSlips), "%"),
+		withUnit(data.NewField("positionX", nil, xs), units.Unit("PositionX")),
+		withUnit(data.NewField("positionZ", nil, zs), units.Unit("PositionZ")),
+	)
+}
+
+// slipSummaryFrame is a table of the lockups and wheelspin of every lap and wheel
+func slipSummaryFrame(laps []analysis.LapSlip) *data.Frame {
+	var (
+		drivers, wheels           []string
+		lapNumbers                []int16
+		lockups, wheelspins       []int32
+		lockupTime, wheelspinTime []float64
+		peakSlips                 []float64
+	)
+	for _, l := range laps {
+		drivers = append(drivers, l.Driver)
+		lapNumbers = append(lapNumbers, l.Lap)
+		wheels = append(wheels, l.Wheel)
+		lockups = append(lockups, int32(l.Lockups))
+		lockupTime = append(lockupTime, milliseconds(l.LockupTime))
+		wheelspins = append(wheelspins, int32(l.Wheelspins))
+		wheelspinTime = append(wheelspinTime, milliseconds(l.WheelspinTime))
+		peakSlips = append(peakSlips, l.PeakSlip*100)
+	}
+
+	return data.NewFrame("slip summary",
+		data.NewField("driver", nil, drivers),
+		data.NewField("lap", nil, lapNumbers),
+		data.NewField("wheel", nil, wheels),
+		data.NewField("lockups", nil, lockups),
+		withUnit(data.NewField("lockupTime", nil, lockupTime), "ms"),
+		data.NewField("wheelspins", nil, wheelspins),
+		withUnit(data.NewField("wheelspinTime", nil, wheelspinTime), "ms"),
+		withUnit(data.NewField("peakSlip", nil, peakSlips), "%"),
+	)
+}
+
+// cornerBalanceFrame is a table of the handling balance of every corner
+func cornerBalanceFrame(balances []analysis.CornerBalance) *data.Frame {
+	var (
+		start                         []time.Time
+		drivers                       []string
+		laps                          []int16
+		corners                       []int32
+		durations                     []float64
+		entry, mid, exit, mean        []float64
+		peakOversteer, peakUndersteer []float64
+	)
+	for _, b := range balances {
+		start = append(start, b.Start)
+		drivers = append(drivers, b.Driver)
+		laps = append(laps, b.Lap)
+		corners = append(corners, int32(b.Corner))
+		durations = append(durations, milliseconds(b.Duration))
+		entry = append(entry, b.Entry)
+		mid = append(mid, b.Mid)
+		exit = append(exit, b.Exit)
+		mean = append(mean, b.Mean)
+		peakOversteer = append(peakOversteer, b.PeakOversteer)
+		peakUndersteer = append(peakUndersteer, b.PeakUndersteer)
+	}
+
+	return data.NewFrame("corner balance",
+		data.NewField("time", nil, start),
+		data.NewField("driver", nil, drivers),
+		data.NewField("lap", nil, laps),
+		data.NewField("corner", nil, corners),
+		withUnit(data.NewField("duration", nil, durations), "ms"),
+		withUnit(data.NewField("entry", nil, entry), "rad/s"),
+		withUnit(data.NewField("mid", nil, mid), "rad/s"),
+		withUnit(data.NewField("exit", nil, exit), "rad/s"),
+		withUnit(data.NewField("mean", nil, mean), "rad/s"),
+		withUnit(data.NewField("peakOversteer", nil, peakOversteer), "rad/s"),
+		withUnit(data.NewField("peakUndersteer", nil, peakUndersteer), "rad/s"),
+	)
+}
+
+// cornersFrame is a table of every lap through the corners and straights of the reference lap
+func cornersFrame(reports []analysis.SegmentReport, units packet.UnitSystem) *data.Frame {
+	var (
+		start                    []time.Time
+		drivers, kinds           []string
+		laps                     []int16
+		numbers                  []int32
+		times, timeLost          []float64
+		entry, apex, exit, top   []float32
+		brakePoint, brakeToEntry []*float64
+	)
+	for _, r := range reports {
+		start = append(start, r.Start)
+		drivers = append(drivers, r.Driver)
+		laps = append(laps, r.Lap)
+		kinds = append(kinds, string(r.Kind))
+		numbers = append(numbers, int32(r.Number))
+		times = append(times, milliseconds(r.Time))
+		timeLost = append(timeLost, milliseconds(r.TimeLost))
+		entry = append(entry, units.Value("CarSpeed", r.EntrySpeed))
+		apex = append(apex, units.Value("CarSpeed", r.ApexSpeed))
+		exit = append(exit, units.Value("CarSpeed", r.ExitSpeed))
+		top = append(top, units.Value("CarSpeed", r.TopSpeed))
+		if r.Braked {
+			distance, beforeEntry := units.Length(r.BrakeDistance), units.Length(r.BrakeBeforeEntry)
+			brakePoint = append(brakePoint, &distance)
+			brakeToEntry = append(brakeToEntry, &beforeEntry)
+		} else {
+			brakePoint = append(brakePoint, nil)
+			brakeToEntry = append(brakeToEntry, nil)
+		}
+	}
+
+	speedUnit, lengthUnit := units.Unit("CarSpeed"), units.Unit("PositionX")
+	return data.NewFrame("corners",
+		data.NewField("time", nil, start),
+		data.NewField("driver", nil, drivers),
+		data.NewField("lap", nil, laps),
+		data.NewField("segment", nil, kinds),
+		data.NewField("corner", nil, numbers),
+		withUnit(data.NewField("duration", nil, times), "ms"),
+		withUnit(data.NewField("timeLost", nil, timeLost), "ms"),
+		withUnit(data.NewField("entrySpeed", nil, entry), speedUnit),
+		withUnit(data.NewField("apexSpeed", nil, apex), speedUnit),
+		withUnit(data.NewField("exitSpeed", nil, exit), speedUnit),
+		withUnit(data.NewField("topSpeed", nil, top), speedUnit),
+		withUnit(data.NewField("brakePoint", nil, brakePoint), lengthUnit),
+		withUnit(data.NewField("brakeBeforeEntry", nil, brakeToEntry), lengthUnit),
+	)
+}
+
+// brakingFrame is the braking report: a row per braking zone, compared with the same zone of
+// the reference lap
+func brakingFrame(zones []analysis.BrakingZone, units packet.UnitSystem) *data.Frame {
+	var (
+		start                           []time.Time
+		drivers                         []string
+		laps                            []int16
+		numbers, lockups                []int32
+		durations, releaseTimes         []float64
+		trailTimes, timeLost            []float64
+		brakePoints, lengths            []float64
+		brakePointDeltas                []float64
+		initial, minimum, release       []float32
+		initialDeltas, minimumDeltas    []float32
+		peakBrake                       []float32
+		peakDecel                       []float64
+		release25, release50, release75 []float32
+	)
+	speed := func(v float32) float32 {
+		return units.Value("CarSpeed", v)
+	}
+	for _, z := range zones {
+		start = append(start, z.Start)
+		drivers = append(drivers, z.Driver)
+		laps = append(laps, z.Lap)
+		numbers = append(numbers, int32(z.Number))
+		durations = append(durations, milliseconds(z.Duration))
+		brakePoints = append(brakePoints, units.Length(z.BrakeDistance))
+		lengths = append(lengths, units.Length(z.Length))
+		initial = append(initial, speed(z.InitialSpeed))
+		minimum = append(minimum, speed(z.MinSpeed))
+		release = append(release, speed(z.ReleaseSpeed))
+		peakBrake = append(peakBrake, z.PeakBrake)
+		peakDecel = append(peakDecel, z.PeakDeceleration)
+		releaseTimes = append(releaseTimes, milliseconds(z.ReleaseTime))
+		release25 = append(release25, z.ReleaseProfile[0])
+		release50 = append(release50, z.ReleaseProfile[1])
+		release75 = append(release75, z.ReleaseProfile[2])
+		trailTimes = append(trailTimes, milliseconds(z.TrailTime))
+		lockups = append(lockups, int32(z.Lockups))
+		brakePointDeltas = append(brakePointDeltas, units.Length(z.BrakePointDelta))
+		// Speed differences convert like speeds, there is no offset
+		initialDeltas = append(initialDeltas, speed(z.InitialSpeedDelta))
+		minimumDeltas = append(minimumDeltas, speed(z.MinSpeedDelta))
+		timeLost = append(timeLost, milliseconds(z.TimeLost))
+	}
+
+	speedUnit, lengthUnit := units.Unit("CarSpeed"), units.Unit("PositionX")
+	return data.NewFrame("braking",
+		data.NewField("time", nil, start),
+		data.NewField("driver", nil, drivers),
+		data.NewField("lap", nil, laps),
+		data.NewField("zone", nil, numbers),
+		withUnit(data.NewField("duration", nil, durations), "ms"),
+		withUnit(data.NewField("brakePoint", nil, brakePoints), lengthUnit),
+		withUnit(data.NewField("length", nil, lengths), lengthUnit),
+		withUnit(data.NewField("initialSpeed", nil, initial), speedUnit),
+		withUnit(data.NewField("minSpeed", nil, minimum), speedUnit),
+		withUnit(data.NewField("releaseSpeed", nil, release), speedUnit),
+		withUnit(data.NewField("peakBrake", nil, peakBrake), "%"),
+		withUnit(data.NewField("peakDeceleration", nil, peakDecel), "g"),
+		withUnit(data.NewField("releaseTime", nil, releaseTimes), "ms"),
+		withUnit(data.NewField("release25", nil, release25), "%"),
+		withUnit(data.NewField("release50", nil, release50), "%"),
+		withUnit(data.NewField("release75", nil, release75), "%"),
+		withUnit(data.NewField("trailBraking", nil, trailTimes), "ms"),
+		data.NewField("lockups", nil, lockups),
+		withUnit(data.NewField("brakePointDelta", nil, brakePointDeltas), lengthUnit),
+		withUnit(data.NewField("initialSpeedDelta", nil, initialDeltas), speedUnit),
+		withUnit(data.NewField("minSpeedDelta", nil, minimumDeltas), speedUnit),
+		withUnit(data.NewField("timeLost", nil, timeLost), "ms"),
+	)
+}
+
+// knownRPM returns nil for the RPMs the shift analysis couldn't estimate
+func knownRPM(rpm float64) *float64 {
+	if rpm <= 0 {
+		return nil
+	}
+	return &rpm
+}
+
+// shiftPointsFrame compares the optimal upshift RPM of every gear with the driver's upshifts
+func shiftPointsFrame(points []analysis.ShiftPoint) *data.Frame {
+	var (
+		drivers                 []string
+		cars, shifts            []int32
+		gears                   []uint8
+		optimal, mean, rpmDelta []*float64
+	)
+	for _, p := range points {
+		drivers = append(drivers, p.Driver)
+		cars = append(cars, p.CarID)
+		gears = append(gears, p.Gear)
+		shifts = append(shifts, int32(p.Shifts))
+		optimal = append(optimal, knownRPM(p.OptimalRPM))
+		mean = append(mean, knownRPM(p.MeanRPM))
+		if p.OptimalRPM > 0 && p.Shifts > 0 {
+			delta := p.MeanRPM - p.OptimalRPM
+			rpmDelta = append(rpmDelta, &delta)
+		} else {
+			rpmDelta = append(rpmDelta, nil)
+		}
+	}
+
+	return data.NewFrame("shiftPoints",
+		data.NewField("driver", nil, drivers),
+		data.NewField("car", nil, cars),
+		data.NewField("gear", nil, gears),
+		withUnit(data.NewField("optimalRpm", nil, optimal), "rpm"),
+		data.NewField("shifts", nil, shifts),
+		withUnit(data.NewField("meanRpm", nil, mean), "rpm"),
+		withUnit(data.NewField("rpmDelta", nil, rpmDelta), "rpm"),
+	)
+}
+
+// shiftsFrame lists the full throttle upshifts against the optimal RPM of their gear
+func shiftsFrame(shifts []analysis.GearShift, units packet.UnitSystem) *data.Frame {
+	var (
+		times             []time.Time
+		drivers           []string
+		laps              []int16
+		from, to          []uint8
+		rpm, speed        []float32
+		optimal, rpmDelta []*float64
+	)
+	for _, s := range shifts {
+		times = append(times, s.Time)
+		drivers = append(drivers, s.Driver)
+		laps = append(laps, s.Lap)
+		from = append(from, s.From)
+		to = append(to, s.To)
+		rpm = append(rpm, s.RPM)
+		speed = append(speed, units.Value("CarSpeed", s.Speed))
+		optimal = append(optimal, knownRPM(s.OptimalRPM))
+		if s.OptimalRPM > 0 {
+			delta := s.RPMDelta
+			rpmDelta = append(rpmDelta, &delta)
+		} else {
+			rpmDelta = append(rpmDelta, nil)
+		}
+	}
+
+	return data.NewFrame("shifts",
+		data.NewField("time", nil, times),
+		data.NewField("driver", nil, drivers),
+		data.NewField("lap", nil, laps),
+		data.NewField("from", nil, from),
+		data.NewField("to", nil, to),
+		withUnit(data.NewField("rpm", nil, rpm), "rpm"),
+		withUnit(data.NewField("speed", nil, speed), units.Unit("CarSpeed")),
+		withUnit(data.NewField("optimalRpm", nil, optimal), "rpm"),
+		withUnit(data.NewField("rpmDelta", nil, rpmDelta), "rpm"),
+	)
+}
+
+// gearsFrame is the gear usage of every lap, with a time field per gear
+func gearsFrame(laps []analysis.LapGears) *data.Frame {
+	var (
+		drivers              []string
+		lapNumbers           []int16
+		gearTimes            [len(analysis.LapGears{}.GearTime)][]float64
+		limiter, shiftLights []float64
+		offSuggested         []float64
+		upshifts, downshifts []int32
+		meanRPMDelta         []float64
+	)
+	for _, l := range laps {
+		drivers = append(drivers, l.Driver)
+		lapNumbers = append(lapNumbers, l.Lap)
+		for gear, t := range l.GearTime {
+			gearTimes[gear] = append(gearTimes[gear], milliseconds(t))
+		}
+		limiter = append(limiter, milliseconds(l.LimiterTime))
+		shiftLights = append(shiftLights, milliseconds(l.ShiftLightsTime))
+		offSuggested = append(offSuggested, milliseconds(l.OffSuggestedTime))
+		upshifts = append(upshifts, int32(l.Upshifts))
+		downshifts = append(downshifts, int32(l.Downshifts))
+		meanRPMDelta = append(meanRPMDelta, l.MeanRPMDelta)
+	}
+
+	frame := data.NewFrame("gears",
+		data.NewField("driver", nil, drivers),
+		data.NewField("lap", nil, lapNumbers),
+	)
+	for gear, times := range gearTimes {
+		name := fmt.Sprintf("gear%d", gear)
+		if gear == 0 {
+			name = "reverse"
+		}
+		frame.Fields = append(frame.Fields, withUnit(data.NewField(name, nil, times), "ms"))
+	}
+	frame.Fields = append(frame.Fields,
+		withUnit(data.NewField("limiter", nil, limiter), "ms"),
+		withUnit(data.NewField("shiftLights", nil, shiftLights), "ms"),
+		withUnit(data.NewField("offSuggestedGear", nil, offSuggested), "ms"),
+		data.NewField("upshifts", nil, upshifts),
+		data.NewField("downshifts", nil, downshifts),
+		withUnit(data.NewField("meanRpmDelta", nil, meanRPMDelta), "rpm"),
+	)
+	return frame
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/analysis"
+)
+
+func TestFilterLap(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame *data.Frame
+		// Expected rows, an error when negative
+		rows int
+	}{
+		{
+			name: "laps",
+			frame: data.NewFrame("gears",
+				data.NewField("driver", nil, []string{"a", "a", "b"}),
+				data.NewField("lap", nil, []int16{1, 2, 2}),
+			),
+			rows: 2,
+		},
+		{
+			name:  "lap without rows",
+			frame: data.NewFrame("gears", data.NewField("lap", nil, []int16{1, 3})),
+			rows:  0,
+		},
+		{
+			name:  "no lap field",
+			frame: shiftPointsFrame([]analysis.ShiftPoint{{Driver: "a", Gear: 2}}),
+			rows:  -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := filterLap(tt.frame, 2)
+			if tt.rows < 0 {
+				if err == nil {
+					t.Errorf("filterLap() = %d rows, want an error", frame.Rows())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("filterLap() failed: %v", err)
+			}
+			if frame.Rows() != tt.rows {
+				t.Errorf("filterLap() = %d rows, want %d", frame.Rows(), tt.rows)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Streamed on gt7/diagnostics, so no driver can use it as a name
+const diagnosticsPath = "diagnostics"
+
+const diagnosticsInterval = time.Second
+
+// runDiagnosticsStream sends the telemetry server counters of every driver once a second.
+// It doesn't start the telemetry server by itself.
+func (d *GT7TelemetryDatasource) runDiagnosticsStream(ctx context.Context, sender *backend.StreamSender) error {
+	ticker := time.NewTicker(diagnosticsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.DefaultLogger.Info("Context done, finish streaming", "path", diagnosticsPath)
+			return nil
+
+		case <-ticker.C:
+			err := sender.SendFrame(d.diagnosticsFrame(), data.IncludeAll)
+			if err != nil {
+				log.DefaultLogger.Error("Error sending frame", "error", err)
+			}
+		}
+	}
+}
+
+// diagnosticsFrame has one row per driver
+func (d *GT7TelemetryDatasource) diagnosticsFrame() *data.Frame {
+	stats := d.serverConfig.Stats
+	drivers := stats.Drivers()
+	snapshot := stats.Snapshot()
+	now := time.Now()
+
+	times := make([]time.Time, 0, len(drivers))
+	datagrams := make([]uint64, 0, len(drivers))
+	bytes := make([]uint64, 0, len(drivers))
+	decodeFailures := make([]uint64, 0, len(drivers))
+	magicMismatches := make([]uint64, 0, len(drivers))
+	packetsLost := make([]uint64, 0, len(drivers))
+	duplicates := make([]uint64, 0, len(drivers))
+	reordered := make([]uint64, 0, len(drivers))
+	framesDropped := make([]uint64, 0, len(drivers))
+	heartbeatErrors := make([]uint64, 0, len(drivers))
+	relayErrors := make([]uint64, 0, len(drivers))
+	lastLatency := make([]float64, 0, len(drivers))
+	meanLatency := make([]float64, 0, len(drivers))
+
+	for _, name := range drivers {
+		s := snapshot[name]
+		times = append(times, now)
+		datagrams = append(datagrams, s.Datagrams)
+		bytes = append(bytes, s.Bytes)
+		decodeFailures = append(decodeFailures, s.DecodeFailures)
+		magicMismatches = append(magicMismatches, s.MagicMismatches)
+		packetsLost = append(packetsLost, s.PacketsLost)
+		duplicates = append(duplicates, s.Duplicates)
+		reordered = append(reordered, s.Reordered)
+		framesDropped = append(framesDropped, s.FramesDropped)
+		heartbeatErrors = append(heartbeatErrors, s.HeartbeatErrors)
+		relayErrors = append(relayErrors, s.RelayErrors)
+		lastLatency = append(lastLatency, float64(s.LastLatency)/float64(time.Millisecond))
+		meanLatency = append(meanLatency, float64(s.MeanLatency())/float64(time.Millisecond))
+	}
+
+	return data.NewFrame("diagnostics",
+		data.NewField("time", nil, times),
+		data.NewField("driver", nil, drivers),
+		data.NewField("datagrams", nil, datagrams),
+		data.NewField("bytes", nil, bytes),
+		data.NewField("decodeFailures", nil, decodeFailures),
+		data.NewField("magicMismatches", nil, magicMismatches),
+		data.NewField("packetsLost", nil, packetsLost),
+		data.NewField("duplicates", nil, duplicates),
+		data.NewField("reordered", nil, reordered),
+		data.NewField("framesDropped", nil, framesDropped),
+		data.NewField("heartbeatErrors", nil, heartbeatErrors),
+		data.NewField("relayErrors", nil, relayErrors),
+		data.NewField("lastLatencyMs", nil, lastLatency),
+		data.NewField("meanLatencyMs", nil, meanLatency),
+	)
+}
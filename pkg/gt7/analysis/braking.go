@@ -0,0 +1,261 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+)
+
+// BrakingConfig holds the thresholds of the braking zone detection
+type BrakingConfig struct {
+	// Brake position, in %, starting a zone, and below which it ends
+	StartBrake float64
+	EndBrake   float64
+	// Zones whose deceleration never reaches this, in g, are brake dabs and dropped
+	MinDeceleration float64
+	// Shorter zones are dropped
+	MinDuration time.Duration
+	// Zones less than this apart are merged
+	MinGap time.Duration
+	// Lateral acceleration, in g, above which braking counts as trail braking
+	TrailLateralG float64
+	// Zones whose braking point is further than this, in m, from those of the reference lap
+	// are left unnumbered
+	MatchDistance float64
+}
+
+// DefaultBrakingConfig starts zones at 10% of brake with at least 0.3 g of deceleration
+var DefaultBrakingConfig = BrakingConfig{
+	StartBrake:      10,
+	EndBrake:        2,
+	MinDeceleration: 0.3,
+	MinDuration:     300 * time.Millisecond,
+	MinGap:          200 * time.Millisecond,
+	TrailLateralG:   0.3,
+	MatchDistance:   100,
+}
+
+// BrakingZone is a braking event of a lap. Speeds are in km/h and distances in m from the
+// start of the lap.
+type BrakingZone struct {
+	Driver string `json:"driver"`
+	Lap    int16  `json:"lap"`
+	// Number of the zone on the track, taken from the matching zone of the reference lap.
+	// 0 when no zone of the reference lap matches.
+	Number int `json:"number"`
+
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration"`
+
+	// Braking point
+	BrakeDistance float64 `json:"brakeDistance"`
+	BrakeX        float32 `json:"brakeX"`
+	BrakeZ        float32 `json:"brakeZ"`
+	// Distance driven while braking
+	Length float64 `json:"length"`
+
+	InitialSpeed float32 `json:"initialSpeed"`
+	MinSpeed     float32 `json:"minSpeed"`
+	// Speed when the brake is released
+	ReleaseSpeed float32 `json:"releaseSpeed"`
+
+	// Peak brake position in %, and peak deceleration in g
+	PeakBrake        float32 `json:"peakBrake"`
+	PeakDeceleration float64 `json:"peakDeceleration"`
+
+	// Trail braking: time from the peak brake position to the release, brake position at a
+	// quarter, half and three quarters of it, and time spent braking while cornering
+	ReleaseTime    time.Duration `json:"releaseTime"`
+	ReleaseProfile [3]float32    `json:"releaseProfile"`
+	TrailTime      time.Duration `json:"trailTime"`
+
+	// Lockups starting during the zone
+	Lockups int `json:"lockups"`
+
+	// Comparison with the same zone of the reference lap: braking point later (positive) or
+	// earlier, initial and minimum speed differences, and time lost from the reference
+	// braking point to its release, negative when faster
+	BrakePointDelta   float64       `json:"brakePointDelta"`
+	InitialSpeedDelta float32       `json:"initialSpeedDelta"`
+	MinSpeedDelta     float32       `json:"minSpeedDelta"`
+	TimeLost          time.Duration `json:"timeLost"`
+}
+
+// Window, in s, the deceleration is measured over
+const decelerationWindow = 0.1
+
+// deceleration returns the longitudinal deceleration at every sample, in g, from the
+// change of the car speed
+func deceleration(samples []gt7.Sample) []float64 {
+	d := make([]float64, len(samples))
+	behind := 0
+	for i := range samples {
+		for behind < i && samples[i].Received.Sub(samples[behind+1].Received).Seconds() >= decelerationWindow {
+			behind++
+		}
+		elapsed := samples[i].Received.Sub(samples[behind].Received).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		d[i] = float64(samples[behind].Frame.CarSpeed-samples[i].Frame.CarSpeed) / 3.6 / elapsed / 9.81
+	}
+	return d
+}
+
+// LapBrakingZones returns the braking zones of a lap, numbered in order
+func LapBrakingZones(lap *Lap, config BrakingConfig) []BrakingZone {
+	samples := lap.Samples
+	decel := deceleration(samples)
+
+	var zones []BrakingZone
+	first, last := -1, -1
+	closeZone := func() {
+		if first >= 0 && samples[last].Received.Sub(samples[first].Received) >= config.MinDuration {
+			if z, ok := brakingZone(lap, decel, first, last, config); ok {
+				z.Number = len(zones) + 1
+				zones = append(zones, z)
+			}
+		}
+		first, last = -1, -1
+	}
+
+	for i, s := range samples {
+		f := &s.Frame
+		if first >= 0 && s.Received.Sub(samples[last].Received) > maxSampleGap {
+			closeZone()
+		}
+
+		brake := float64(f.Brake)
+		switch {
+		case f.IsPaused:
+			closeZone()
+		case first >= 0 && brake >= config.EndBrake:
+			last = i
+		case first >= 0 && s.Received.Sub(samples[last].Received) < config.MinGap:
+			// Brake briefly released
+		case brake >= config.StartBrake:
+			closeZone()
+			first, last = i, i
+		default:
+			closeZone()
+		}
+	}
+	closeZone()
+	return zones
+}
+
+func brakingZone(lap *Lap, decel []float64, first, last int, config BrakingConfig) (BrakingZone, bool) {
+	samples := lap.Samples
+	start := &samples[first]
+	z := BrakingZone{
+		Driver:        lap.Driver,
+		Lap:           lap.Number,
+		Start:         start.Received,
+		End:           samples[last].Received,
+		Duration:      samples[last].Received.Sub(start.Received),
+		BrakeDistance: lap.Distance[first],
+		BrakeX:        start.Frame.PositionX,
+		BrakeZ:        start.Frame.PositionZ,
+		Length:        lap.Distance[last] - lap.Distance[first],
+		InitialSpeed:  start.Frame.CarSpeed,
+		MinSpeed:      start.Frame.CarSpeed,
+		ReleaseSpeed:  samples[last].Frame.CarSpeed,
+	}
+
+	peak := first
+	var previous time.Time
+	for i := first; i <= last; i++ {
+		f := &samples[i].Frame
+		if f.CarSpeed < z.MinSpeed {
+			z.MinSpeed = f.CarSpeed
+		}
+		if f.Brake > samples[peak].Frame.Brake {
+			peak = i
+		}
+		z.PeakDeceleration = math.Max(z.PeakDeceleration, decel[i])
+		if i > first && LateralG(f) >= config.TrailLateralG && float64(f.Brake) >= config.EndBrake {
+			z.TrailTime += samples[i].Received.Sub(previous)
+		}
+		previous = samples[i].Received
+	}
+	if z.PeakDeceleration < config.MinDeceleration {
+		return z, false
+	}
+
+	z.PeakBrake = samples[peak].Frame.Brake
+	z.ReleaseTime = samples[last].Received.Sub(samples[peak].Received)
+	for q := range z.ReleaseProfile {
+		at := samples[peak].Received.Add(z.ReleaseTime * time.Duration(q+1) / 4)
+		i := peak + sort.Search(last-peak+1, func(j int) bool { return !samples[peak+j].Received.Before(at) })
+		if i > last {
+			i = last
+		}
+		z.ReleaseProfile[q] = samples[i].Frame.Brake
+	}
+	return z, true
+}
+
+// matchZone returns the reference zone with the nearest braking point
+func matchZone(z BrakingZone, reference []BrakingZone, maxDistance float64) *BrakingZone {
+	var match *BrakingZone
+	nearest := maxDistance
+	for i := range reference {
+		r := &reference[i]
+		if d := math.Hypot(float64(z.BrakeX-r.BrakeX), float64(z.BrakeZ-r.BrakeZ)); d <= nearest {
+			match, nearest = r, d
+		}
+	}
+	return match
+}
+
+// BrakingReport returns the braking zones of every lap of the samples, by start time. Zones
+// are numbered after the fastest complete lap, and compared with its zones. Laps whose start
+// isn't in the samples are left out, as their distances aren't from the start line.
+func BrakingReport(samples []gt7.Sample, config BrakingConfig) []BrakingZone {
+	laps := SplitLaps(samples)
+	reference := ReferenceLap(laps)
+
+	var referenceZones []BrakingZone
+	if reference != nil {
+		referenceZones = LapBrakingZones(reference, config)
+	}
+	lockups := map[string][]time.Time{}
+	for _, e := range SlipEvents(samples, DefaultSlipConfig) {
+		if e.Kind == SlipLockup {
+			lockups[e.Driver] = append(lockups[e.Driver], e.Start)
+		}
+	}
+
+	var zones []BrakingZone
+	for i := range laps {
+		lap := &laps[i]
+		if !lap.Started {
+			continue
+		}
+		for _, z := range LapBrakingZones(lap, config) {
+			for _, t := range lockups[z.Driver] {
+				if !t.Before(z.Start) && !t.After(z.End) {
+					z.Lockups++
+				}
+			}
+
+			if reference != nil {
+				z.Number = 0
+				if r := matchZone(z, referenceZones, config.MatchDistance); r != nil {
+					z.Number = r.Number
+					z.BrakePointDelta = z.BrakeDistance - r.BrakeDistance
+					z.InitialSpeedDelta = z.InitialSpeed - r.InitialSpeed
+					z.MinSpeedDelta = z.MinSpeed - r.MinSpeed
+					from, to := r.BrakeDistance, r.BrakeDistance+r.Length
+					z.TimeLost = lap.TimeAt(to).Sub(lap.TimeAt(from)) - reference.TimeAt(to).Sub(reference.TimeAt(from))
+				}
+			}
+			zones = append(zones, z)
+		}
+	}
+	sort.SliceStable(zones, func(i, j int) bool { return zones[i].Start.Before(zones[j].Start) })
+	return zones
+}
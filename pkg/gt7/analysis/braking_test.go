@@ -0,0 +1,105 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+func TestBrakingReport(t *testing.T) {
+	// The first lap brakes the latest, and is the reference. The second locks the front left
+	// wheel whenever it brakes.
+	laps := []ovalLap{{brake: 12}, {}, {brake: 11}}
+	samples := ovalSamples(laps, func(along float64, f *packet.TelemetryFrame) {
+		f.TyreSlipRatioFL, f.TyreSlipRatioFR, f.TyreSlipRatioRL, f.TyreSlipRatioRR = 1, 1, 1, 1
+		if f.CurrentLap == 2 && f.Brake > 0 {
+			f.TyreSlipRatioFL = 0.5
+		}
+	})
+	zones := BrakingReport(samples, DefaultBrakingConfig)
+
+	tests := []struct {
+		lap     int16
+		number  int
+		lockups int
+	}{
+		{1, 1, 0},
+		{1, 2, 0},
+		{2, 1, 1},
+		{2, 2, 1},
+		{3, 1, 0},
+		{3, 2, 0},
+	}
+	if len(zones) != len(tests) {
+		t.Fatalf("BrakingReport() returned %d zones, want %d: %+v", len(zones), len(tests), zones)
+	}
+	for i, tt := range tests {
+		z := zones[i]
+		if z.Lap != tt.lap || z.Number != tt.number {
+			t.Errorf("zone %d is lap %d zone %d, want lap %d zone %d", i, z.Lap, z.Number, tt.lap, tt.number)
+			continue
+		}
+		lap := laps[tt.lap-1]
+		want := lap.brakeDistance(tt.number)
+		if math.Abs(z.BrakeDistance-want) > 2 {
+			t.Errorf("lap %d zone %d: BrakeDistance = %.1f, want %.1f", z.Lap, z.Number, z.BrakeDistance, want)
+		}
+		// Later (positive) or earlier than the reference
+		delta := want - laps[0].brakeDistance(tt.number)
+		if math.Abs(z.BrakePointDelta-delta) > 2 {
+			t.Errorf("lap %d zone %d: BrakePointDelta = %.1f, want %.1f", z.Lap, z.Number, z.BrakePointDelta, delta)
+		}
+		if math.Abs(float64(z.InitialSpeed)-200) > 1 || math.Abs(float64(z.MinSpeed)-90) > 1 {
+			t.Errorf("lap %d zone %d: speeds = %.1f to %.1f, want 200 to 90", z.Lap, z.Number, z.InitialSpeed, z.MinSpeed)
+		}
+		if decel := lap.decel() / 9.81; math.Abs(z.PeakDeceleration-decel) > 0.05 {
+			t.Errorf("lap %d zone %d: PeakDeceleration = %.2f, want %.2f", z.Lap, z.Number, z.PeakDeceleration, decel)
+		}
+		if z.Lockups != tt.lockups {
+			t.Errorf("lap %d zone %d: Lockups = %d, want %d", z.Lap, z.Number, z.Lockups, tt.lockups)
+		}
+		if tt.lap > 1 && z.TimeLost <= 0 {
+			t.Errorf("lap %d zone %d: TimeLost = %v, want more than 0 braking earlier", z.Lap, z.Number, z.TimeLost)
+		}
+	}
+}
+
+func TestLapBrakingZones(t *testing.T) {
+	tests := []struct {
+		name  string
+		brake float32
+		// Deceleration, in m/s² from 200 km/h
+		decel float64
+		zones int
+	}{
+		{name: "braking", brake: 100, decel: 9.81, zones: 1},
+		{name: "brake dab", brake: 100, decel: 1},
+		{name: "under the start threshold", brake: 8, decel: 9.81},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// One second of braking on a straight
+			samples := slipSamples(180, 0, 0, -1, 1, 0, 0)
+			for i := range samples {
+				f := &samples[i].Frame
+				f.CarSpeed = 200
+				if i >= 60 {
+					f.CarSpeed = float32(200 - tt.decel*3.6*math.Min(float64(i-60), 60)*testInterval.Seconds())
+				}
+				if i >= 60 && i < 120 {
+					f.Brake = tt.brake
+				}
+				f.PositionX = float32(i)
+			}
+			lap := splitLaps(samples)[0]
+			zones := LapBrakingZones(&lap, DefaultBrakingConfig)
+			if len(zones) != tt.zones {
+				t.Fatalf("LapBrakingZones() returned %d zones, want %d", len(zones), tt.zones)
+			}
+			if tt.zones > 0 && (zones[0].BrakeDistance != 60 || zones[0].Number != 1) {
+				t.Errorf("zone %d braked at %.1f, want zone 1 at 60", zones[0].Number, zones[0].BrakeDistance)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// CornerConfig holds the thresholds of DetectCorners. A corner starts where the path is
+// tighter than EnterRadius with at least EnterLateralG of lateral acceleration, so that slow
+// manoeuvres aren't corners, and lasts while the path is tighter than ExitRadius with at
+// least ExitLateralG.
+type CornerConfig struct {
+	// Path radius, in m
+	EnterRadius float64
+	ExitRadius  float64
+	// Lateral acceleration, in g
+	EnterLateralG float64
+	ExitLateralG  float64
+	// Corners shorter than this are dropped
+	MinDuration time.Duration
+	// Corners less than this apart are merged
+	MinGap time.Duration
+	// Distance, in m, behind and ahead of a sample the path curvature is measured on
+	CurvatureSpan float64
+	// Corners whose apex is further than this, in m, from the apexes of the reference lap
+	// are left unnumbered
+	MatchDistance float64
+}
+
+// DefaultCornerConfig starts corners tighter than 400 m at 0.3 g of lateral acceleration
+var DefaultCornerConfig = CornerConfig{
+	EnterRadius:   400,
+	ExitRadius:    600,
+	EnterLateralG: 0.3,
+	ExitLateralG:  0.2,
+	MinDuration:   500 * time.Millisecond,
+	MinGap:        300 * time.Millisecond,
+	CurvatureSpan: 10,
+	MatchDistance: 50,
+}
+
+// Corner is a part of a lap where the car turns
+type Corner struct {
+	Driver string
+	Lap    int16
+	// Number of the corner on the track, taken from the matching corner of the reference
+	// lap: the same corner has the same number on every lap. 0 when no corner of the reference
+	// lap matches.
+	Number int
+	Start  time.Time
+	End    time.Time
+	// Distance from the start of the lap, in m, of the start, apex and end of the corner.
+	// The apex is where the car is the slowest.
+	StartDistance float64
+	ApexDistance  float64
+	EndDistance   float64
+	ApexX, ApexZ  float32
+	// Samples of the corner
+	Samples []gt7.Sample
+}
+
+// LateralG returns the lateral acceleration of a frame in g, whichever way the car turns: the
+// speed times the turn rate of the path. GForceX leaves out the centripetal part, as it is the
+// change of the velocity in car coordinates.
+func LateralG(f *packet.TelemetryFrame) float64 {
+	return math.Abs(float64(f.YawRateExpected)) * float64(f.CarSpeed) / 3.6 / 9.81
+}
+
+// byDriver splits the samples per driver, keeping their order
+func byDriver(samples []gt7.Sample) [][]gt7.Sample {
+	index := map[string]int{}
+	var drivers [][]gt7.Sample
+	for _, s := range samples {
+		i, ok := index[s.Driver]
+		if !ok {
+			i = len(drivers)
+			index[s.Driver] = i
+			drivers = append(drivers, nil)
+		}
+		drivers[i] = append(drivers[i], s)
+	}
+	return drivers
+}
+
+// DetectCorners splits every lap into corners, by start time. Samples of a driver must be in
+// time order. Corners are numbered after the fastest complete lap, or in the order of each
+// lap when there is none. That lap is the fastest of the samples, so numbers only compare
+// within a call: another time range with another fastest lap may number corners differently.
+func DetectCorners(samples []gt7.Sample, config CornerConfig) []Corner {
+	laps := SplitLaps(samples)
+	reference := ReferenceLap(laps)
+
+	var referenceCorners []Corner
+	if reference != nil {
+		referenceCorners = LapCorners(reference, config)
+	}
+
+	var corners []Corner
+	for i := range laps {
+		lapCorners := LapCorners(&laps[i], config)
+		if reference != nil {
+			for j := range lapCorners {
+				lapCorners[j].Number = matchCorner(lapCorners[j], referenceCorners, config.MatchDistance)
+			}
+		}
+		corners = append(corners, lapCorners...)
+	}
+	sort.SliceStable(corners, func(i, j int) bool { return corners[i].Start.Before(corners[j].Start) })
+	return corners
+}
+
+// matchCorner returns the number of the reference corner with the nearest apex
+func matchCorner(c Corner, reference []Corner, maxDistance float64) int {
+	number := 0
+	nearest := maxDistance
+	for _, r := range reference {
+		if d := math.Hypot(float64(c.ApexX-r.ApexX), float64(c.ApexZ-r.ApexZ)); d <= nearest {
+			number, nearest = r.Number, d
+		}
+	}
+	return number
+}
+
+// LapCorners returns the corners of a lap, numbered in order
+func LapCorners(lap *Lap, config CornerConfig) []Corner {
+	samples := lap.Samples
+	curvature := curvatures(lap, config.CurvatureSpan)
+
+	var corners []Corner
+	first, last := -1, -1
+	closeCorner := func() {
+		if first >= 0 && samples[last].Received.Sub(samples[first].Received) >= config.MinDuration {
+			apex := first
+			for i := first; i <= last; i++ {
+				if samples[i].Frame.CarSpeed < samples[apex].Frame.CarSpeed {
+					apex = i
+				}
+			}
+			corners = append(corners, Corner{
+				Driver:        lap.Driver,
+				Lap:           lap.Number,
+				Number:        len(corners) + 1,
+				Start:         samples[first].Received,
+				End:           samples[last].Received,
+				StartDistance: lap.Distance[first],
+				ApexDistance:  lap.Distance[apex],
+				EndDistance:   lap.Distance[last],
+				ApexX:         samples[apex].Frame.PositionX,
+				ApexZ:         samples[apex].Frame.PositionZ,
+				Samples:       samples[first : last+1],
+			})
+		}
+		first, last = -1, -1
+	}
+
+	for i, s := range samples {
+		f := &s.Frame
+		if first >= 0 && s.Received.Sub(samples[last].Received) > maxSampleGap {
+			closeCorner()
+		}
+
+		g := LateralG(f)
+		switch {
+		case f.IsPaused:
+			closeCorner()
+		case first >= 0 && g >= config.ExitLateralG && curvature[i] >= 1/config.ExitRadius:
+			last = i
+		case first >= 0 && s.Received.Sub(samples[last].Received) < config.MinGap:
+			// Short straightening, e.g. between the two apexes of a corner
+		case g >= config.EnterLateralG && curvature[i] >= 1/config.EnterRadius:
+			closeCorner()
+			first, last = i, i
+		default:
+			closeCorner()
+		}
+	}
+	closeCorner()
+	return corners
+}
+
+// CornerBalance sums up the handling balance of a corner. Balances are means of
+// HandlingBalance in rad/s, positive when oversteering.
+type CornerBalance struct {
+	Driver   string        `json:"driver"`
+	Lap      int16         `json:"lap"`
+	Corner   int           `json:"corner"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	// Means over the first, middle and last third of the corner, and over all of it
+	Entry float64 `json:"entry"`
+	Mid   float64 `json:"mid"`
+	Exit  float64 `json:"exit"`
+	Mean  float64 `json:"mean"`
+	// Largest oversteer, and largest understeer as a negative balance
+	PeakOversteer  float64 `json:"peakOversteer"`
+	PeakUndersteer float64 `json:"peakUndersteer"`
+}
+
+// BalanceOf returns the handling balance of a corner
+func BalanceOf(c Corner) CornerBalance {
+	b := CornerBalance{
+		Driver:   c.Driver,
+		Lap:      c.Lap,
+		Corner:   c.Number,
+		Start:    c.Start,
+		Duration: c.End.Sub(c.Start),
+	}
+
+	var sums [3]float64
+	var counts [3]int
+	total := 0.0
+	for i, s := range c.Samples {
+		balance := float64(s.Frame.HandlingBalance)
+		third := i * 3 / len(c.Samples)
+		sums[third] += balance
+		counts[third]++
+		total += balance
+		if balance > b.PeakOversteer {
+			b.PeakOversteer = balance
+		}
+		if balance < b.PeakUndersteer {
+			b.PeakUndersteer = balance
+		}
+	}
+
+	mean := func(sum float64, n int) float64 {
+		if n == 0 {
+			return 0
+		}
+		return sum / float64(n)
+	}
+	b.Entry = mean(sums[0], counts[0])
+	b.Mid = mean(sums[1], counts[1])
+	b.Exit = mean(sums[2], counts[2])
+	b.Mean = mean(total, len(c.Samples))
+	return b
+}
+
+// CornerBalances returns the handling balance of every corner of the samples
+func CornerBalances(samples []gt7.Sample, config CornerConfig) []CornerBalance {
+	var balances []CornerBalance
+	for _, c := range DetectCorners(samples, config) {
+		balances = append(balances, BalanceOf(c))
+	}
+	return balances
+}
@@ -0,0 +1,257 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// The synthetic track is an oval of two 500 m straights joined by two 60 m radius half
+// circles, driven anticlockwise. The start line is in the middle of the first straight.
+const (
+	ovalStraight = 500.0
+	ovalRadius   = 60.0
+	// Speeds in m/s: flat out on the straights, 90 km/h into and out of the corners and
+	// 80 km/h at their apex, halfway round
+	ovalTopSpeed    = 200 / 3.6
+	ovalCornerSpeed = 90 / 3.6
+	ovalApexSpeed   = 80 / 3.6
+	ovalAccel       = 0.5 * 9.81
+)
+
+var (
+	ovalCorner = math.Pi * ovalRadius
+	ovalLength = 2*ovalStraight + 2*ovalCorner
+)
+
+// ovalLap holds how a lap of the oval is driven
+type ovalLap struct {
+	// Deceleration into the corners, in m/s², 1 g when 0
+	brake float64
+	// Scale of the driven line, 1 when 0: a lap on a line 0.5% shorter has a scale of 0.995
+	scale float64
+}
+
+// brakeDistance returns where, from the start of the lap, the lap brakes for a corner
+func (l ovalLap) brakeDistance(corner int) float64 {
+	return ovalCornerStart(corner) - (ovalTopSpeed*ovalTopSpeed-ovalCornerSpeed*ovalCornerSpeed)/(2*l.decel())
+}
+
+func (l ovalLap) decel() float64 {
+	if l.brake == 0 {
+		return 9.81
+	}
+	return l.brake
+}
+
+// ovalCornerStart returns the distance from the start line of the corner 1 or 2
+func ovalCornerStart(corner int) float64 {
+	return ovalStraight/2 + float64(corner-1)*(ovalStraight+ovalCorner)
+}
+
+// ovalApex returns the distance from the start line of the apex of the corner 1 or 2
+func ovalApex(corner int) float64 {
+	return ovalCornerStart(corner) + ovalCorner/2
+}
+
+// ovalFrame sets the position, speed and pedals of a car d metres from the start line
+// (positive), and returns where it is along the corner, from 0 to 1, or -1 on the straights
+func ovalFrame(lap ovalLap, d float64, f *packet.TelemetryFrame) float64 {
+	// Distance from the start of the first straight
+	p := math.Mod(d+ovalStraight/2, ovalLength)
+	x, z, speed, yawRate, along := 0.0, 0.0, 0.0, 0.0, -1.0
+	switch {
+	case p < ovalStraight:
+		x, z = p-ovalStraight/2, 0
+		speed = ovalStraightSpeed(lap, p, f)
+	case p < ovalStraight+ovalCorner:
+		along = (p - ovalStraight) / ovalCorner
+		angle := -math.Pi/2 + math.Pi*along
+		x, z = ovalStraight/2+ovalRadius*math.Cos(angle), ovalRadius+ovalRadius*math.Sin(angle)
+	case p < 2*ovalStraight+ovalCorner:
+		q := p - ovalStraight - ovalCorner
+		x, z = ovalStraight/2-q, 2*ovalRadius
+		speed = ovalStraightSpeed(lap, q, f)
+	default:
+		along = (p - 2*ovalStraight - ovalCorner) / ovalCorner
+		angle := math.Pi/2 + math.Pi*along
+		x, z = -ovalStraight/2+ovalRadius*math.Cos(angle), ovalRadius+ovalRadius*math.Sin(angle)
+	}
+	if along >= 0 {
+		speed = ovalCornerSpeed - (ovalCornerSpeed-ovalApexSpeed)*math.Sin(math.Pi*along)
+		yawRate = speed / ovalRadius
+		f.Throttle = 30
+	}
+
+	scale := lap.scale
+	if scale == 0 {
+		scale = 1
+	}
+	// Scaled around the centre of the oval
+	f.PositionX = float32(x * scale)
+	f.PositionZ = float32(ovalRadius + (z-ovalRadius)*scale)
+	f.CarSpeed = float32(speed * 3.6)
+	f.YawRateExpected = float32(yawRate)
+	return along
+}
+
+// ovalStraightSpeed returns the speed q metres along a straight, accelerating out of the
+// previous corner and braking for the next one
+func ovalStraightSpeed(lap ovalLap, q float64, f *packet.TelemetryFrame) float64 {
+	accelerating := ovalCornerSpeed*ovalCornerSpeed + 2*ovalAccel*q
+	braking := ovalCornerSpeed*ovalCornerSpeed + 2*lap.decel()*(ovalStraight-q)
+	if braking < accelerating && braking < ovalTopSpeed*ovalTopSpeed {
+		f.Brake = 100
+		return math.Sqrt(braking)
+	}
+	f.Throttle = 100
+	return math.Sqrt(math.Min(accelerating, ovalTopSpeed*ovalTopSpeed))
+}
+
+// ovalSamples drives the laps of the oval, from 100 m before the start line to 100 m into the
+// lap after the last one, which completes it. set, when not nil, is called on every frame with
+// where the car is along the corner, -1 on the straights.
+func ovalSamples(laps []ovalLap, set func(along float64, f *packet.TelemetryFrame)) []gt7.Sample {
+	var samples []gt7.Sample
+	var lapStart time.Time
+	lastLap := int32(-1)
+	for i, d := 0, -100.0; d < float64(len(laps))*ovalLength+100; i++ {
+		s := testSample(i)
+		f := &s.Frame
+		number := int(math.Floor(d/ovalLength)) + 1
+		if number > 0 && (len(samples) == 0 || int(samples[len(samples)-1].Frame.CurrentLap) != number) {
+			if number > 1 {
+				lastLap = int32(s.Received.Sub(lapStart) / time.Millisecond)
+			}
+			lapStart = s.Received
+		}
+		f.CurrentLap = int16(number)
+		f.LastLap = lastLap
+
+		lap := laps[0]
+		if number > len(laps) {
+			lap = laps[len(laps)-1]
+		} else if number > 0 {
+			lap = laps[number-1]
+		}
+		along := ovalFrame(lap, math.Mod(d+ovalLength, ovalLength), f)
+		if set != nil {
+			set(along, f)
+		}
+		samples = append(samples, s)
+		d += float64(f.CarSpeed) / 3.6 * testInterval.Seconds()
+	}
+	return samples
+}
+
+func TestCornerBalances(t *testing.T) {
+	tests := []struct {
+		name string
+		// Balance in the first, middle and last third of the corners
+		thirds [3]float32
+	}{
+		{name: "neutral"},
+		{name: "understeer on entry, oversteer on exit", thirds: [3]float32{-0.2, 0, 0.3}},
+		{name: "oversteer", thirds: [3]float32{0.1, 0.2, 0.1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := ovalSamples([]ovalLap{{}, {}}, func(along float64, f *packet.TelemetryFrame) {
+				if along >= 0 {
+					f.HandlingBalance = tt.thirds[int(math.Min(along*3, 2))]
+				}
+			})
+			balances := CornerBalances(samples, DefaultCornerConfig)
+			if len(balances) != 4 {
+				t.Fatalf("CornerBalances() returned %d corners, want 4: %+v", len(balances), balances)
+			}
+
+			mean := float64(tt.thirds[0]+tt.thirds[1]+tt.thirds[2]) / 3
+			peakOversteer := math.Max(0, float64(tt.thirds[0]))
+			peakUndersteer := math.Min(0, float64(tt.thirds[0]))
+			for _, balance := range tt.thirds {
+				peakOversteer = math.Max(peakOversteer, float64(balance))
+				peakUndersteer = math.Min(peakUndersteer, float64(balance))
+			}
+			for i, b := range balances {
+				if b.Lap != int16(i/2+1) || b.Corner != i%2+1 {
+					t.Errorf("balance %d is of lap %d corner %d, want lap %d corner %d", i, b.Lap, b.Corner, i/2+1, i%2+1)
+				}
+				got := []float64{b.Entry, b.Mid, b.Exit, b.Mean, b.PeakOversteer, b.PeakUndersteer}
+				want := []float64{float64(tt.thirds[0]), float64(tt.thirds[1]), float64(tt.thirds[2]), mean, peakOversteer, peakUndersteer}
+				for j := range want {
+					// The corner edges are found a few samples off the geometry
+					if math.Abs(got[j]-want[j]) > 0.02 {
+						t.Errorf("corner %d: entry, mid, exit, mean, peaks = %.3f, want %.3f", i, got, want)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDetectCorners(t *testing.T) {
+	type corner struct {
+		lap    int16
+		number int
+		apex   float64
+	}
+	tests := []struct {
+		name string
+		laps []ovalLap
+		// Lap whose first corner is driven straight, for GT7 expecting no turn
+		missed int16
+		want   []corner
+	}{
+		{
+			name: "two laps",
+			laps: []ovalLap{{}, {}},
+			want: []corner{{1, 1, ovalApex(1)}, {1, 2, ovalApex(2)}, {2, 1, ovalApex(1)}, {2, 2, ovalApex(2)}},
+		},
+		{
+			name:   "corner missing from a lap",
+			laps:   []ovalLap{{brake: 12}, {}},
+			missed: 2,
+			// Numbered after the reference lap, not in order
+			want: []corner{{1, 1, ovalApex(1)}, {1, 2, ovalApex(2)}, {2, 2, ovalApex(2)}},
+		},
+		{
+			name: "shorter line",
+			laps: []ovalLap{{brake: 12}, {scale: 0.99}},
+			want: []corner{{1, 1, ovalApex(1)}, {1, 2, ovalApex(2)}, {2, 1, ovalApex(1) * 0.99}, {2, 2, ovalApex(2) * 0.99}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := ovalSamples(tt.laps, func(along float64, f *packet.TelemetryFrame) {
+				if f.CurrentLap == tt.missed && along >= 0 && f.PositionX > 0 {
+					f.YawRateExpected = 0
+				}
+			})
+			corners := DetectCorners(samples, DefaultCornerConfig)
+			if len(corners) != len(tt.want) {
+				t.Fatalf("DetectCorners() returned %d corners, want %d", len(corners), len(tt.want))
+			}
+			for i, want := range tt.want {
+				c := corners[i]
+				if c.Lap != want.lap || c.Number != want.number {
+					t.Errorf("corner %d is lap %d corner %d, want lap %d corner %d", i, c.Lap, c.Number, want.lap, want.number)
+				}
+				// Within two samples of the geometry
+				if math.Abs(c.ApexDistance-want.apex) > 2 {
+					t.Errorf("corner %d: ApexDistance = %.1f, want %.1f", i, c.ApexDistance, want.apex)
+				}
+				if c.ApexDistance < c.StartDistance || c.ApexDistance > c.EndDistance {
+					t.Errorf("corner %d: apex %.1f outside of %.1f-%.1f", i, c.ApexDistance, c.StartDistance, c.EndDistance)
+				}
+				if speed := c.Samples[0].Frame.CarSpeed; math.Abs(float64(speed)-90) > 1 {
+					t.Errorf("corner %d: entry speed = %.1f, want 90", i, speed)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+)
+
+// Lap holds the samples of a lap of a driver
+type Lap struct {
+	Driver string
+	Number int16
+	// Lap time reported by GT7 once the lap is completed, 0 before
+	Time time.Duration
+	// Whether the samples start at the start line, and cover the lap up to the finish line
+	Started  bool
+	Complete bool
+	Samples  []gt7.Sample
+	// Distance driven since the first sample of the lap, in m, for every sample
+	Distance []float64
+}
+
+// Position jumps longer than this, in m, e.g. after a restart, aren't counted as driven
+const maxPositionJump = 100
+
+// SplitLaps splits the samples of every driver into laps, in the order of the drivers
+// first sample. Samples of a driver must be in time order.
+func SplitLaps(samples []gt7.Sample) []Lap {
+	var laps []Lap
+	for _, driverSamples := range byDriver(samples) {
+		laps = append(laps, splitLaps(driverSamples)...)
+	}
+	return laps
+}
+
+func splitLaps(samples []gt7.Sample) []Lap {
+	var laps []Lap
+	first := 0
+	for i := 1; i <= len(samples); i++ {
+		if i < len(samples) && samples[i].Frame.CurrentLap == samples[first].Frame.CurrentLap {
+			continue
+		}
+
+		lap := Lap{
+			Driver:  samples[first].Driver,
+			Number:  samples[first].Frame.CurrentLap,
+			Samples: samples[first:i],
+		}
+		lap.Distance = distances(lap.Samples)
+		// Lap 0 is before the start, and the first lap of the samples may have begun earlier
+		lap.Started = lap.Number > 0 && first > 0
+		if i < len(samples) && samples[i].Frame.CurrentLap == lap.Number+1 && samples[i].Frame.LastLap > 0 {
+			lap.Time = time.Duration(samples[i].Frame.LastLap) * time.Millisecond
+			lap.Complete = lap.Started
+		}
+		laps = append(laps, lap)
+		first = i
+	}
+	return laps
+}
+
+func distances(samples []gt7.Sample) []float64 {
+	d := make([]float64, len(samples))
+	for i := 1; i < len(samples); i++ {
+		a, b := &samples[i-1].Frame, &samples[i].Frame
+		step := math.Sqrt(square(b.PositionX-a.PositionX) + square(b.PositionY-a.PositionY) + square(b.PositionZ-a.PositionZ))
+		if step > maxPositionJump {
+			step = 0
+		}
+		d[i] = d[i-1] + step
+	}
+	return d
+}
+
+func square(v float32) float64 {
+	return float64(v) * float64(v)
+}
+
+// ReferenceLap returns the fastest complete lap, nil when no lap is complete
+func ReferenceLap(laps []Lap) *Lap {
+	var reference *Lap
+	for i := range laps {
+		l := &laps[i]
+		if l.Complete && (reference == nil || l.Time < reference.Time) {
+			reference = l
+		}
+	}
+	return reference
+}
+
+// index returns the index of the first sample at distance d or further, len(Samples) when
+// the lap is shorter
+func (l *Lap) index(d float64) int {
+	return sort.SearchFloat64s(l.Distance, d)
+}
+
+// TimeAt returns when the lap reached the distance d, interpolating between samples
+func (l *Lap) TimeAt(d float64) time.Time {
+	i := l.index(d)
+	switch {
+	case i == 0:
+		return l.Samples[0].Received
+	case i >= len(l.Samples):
+		return l.Samples[len(l.Samples)-1].Received
+	}
+	before, after := l.Samples[i-1].Received, l.Samples[i].Received
+	span := l.Distance[i] - l.Distance[i-1]
+	if span <= 0 {
+		return after
+	}
+	return before.Add(time.Duration(float64(after.Sub(before)) * (d - l.Distance[i-1]) / span))
+}
+
+// curvatures returns the curvature of the horizontal path at every sample, in 1/m, measured
+// on the points span metres behind and ahead
+func curvatures(l *Lap, span float64) []float64 {
+	c := make([]float64, len(l.Samples))
+	behind, ahead := 0, 0
+	for i := range l.Samples {
+		for behind < i && l.Distance[i]-l.Distance[behind+1] >= span {
+			behind++
+		}
+		if ahead < i {
+			ahead = i
+		}
+		for ahead < len(l.Samples)-1 && l.Distance[ahead]-l.Distance[i] < span {
+			ahead++
+		}
+		if l.Distance[i]-l.Distance[behind] < span || l.Distance[ahead]-l.Distance[i] < span {
+			continue
+		}
+		c[i] = mengerCurvature(horizontal(&l.Samples[behind]), horizontal(&l.Samples[i]), horizontal(&l.Samples[ahead]))
+	}
+	return c
+}
+
+// horizontal returns the position of a sample on the horizontal plane, Y being vertical
+func horizontal(s *gt7.Sample) [2]float64 {
+	return [2]float64{float64(s.Frame.PositionX), float64(s.Frame.PositionZ)}
+}
+
+// mengerCurvature is the inverse of the radius of the circle through three points
+func mengerCurvature(a, b, c [2]float64) float64 {
+	ab := math.Hypot(b[0]-a[0], b[1]-a[1])
+	ac := math.Hypot(c[0]-a[0], c[1]-a[1])
+	bc := math.Hypot(c[0]-b[0], c[1]-b[1])
+	if ab == 0 || ac == 0 || bc == 0 {
+		return 0
+	}
+	cross := (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+	return 2 * math.Abs(cross) / (ab * ac * bc)
+}
@@ -0,0 +1,182 @@
+package analysis
+
+import (
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+)
+
+// SegmentKind tells corners and straights apart
+type SegmentKind string
+
+const (
+	SegmentCorner   SegmentKind = "corner"
+	SegmentStraight SegmentKind = "straight"
+)
+
+// Segment is a corner of the reference lap, or the straight before it
+type Segment struct {
+	Kind SegmentKind
+	// Corner number, for straights the number of the corner after them. The straight from
+	// the last corner to the finish line is numbered 0.
+	Number int
+	// Distance from the start of the lap, in m
+	StartDistance float64
+	ApexDistance  float64
+	EndDistance   float64
+}
+
+// TrackSegments splits a lap into its corners and the straights between them
+func TrackSegments(lap *Lap, corners []Corner) []Segment {
+	var segments []Segment
+	previous := 0.0
+	for _, c := range corners {
+		if c.StartDistance > previous {
+			segments = append(segments, Segment{Kind: SegmentStraight, Number: c.Number, StartDistance: previous, EndDistance: c.StartDistance})
+		}
+		segments = append(segments, Segment{
+			Kind:          SegmentCorner,
+			Number:        c.Number,
+			StartDistance: c.StartDistance,
+			ApexDistance:  c.ApexDistance,
+			EndDistance:   c.EndDistance,
+		})
+		previous = c.EndDistance
+	}
+	if end := lap.Distance[len(lap.Distance)-1]; end > previous {
+		segments = append(segments, Segment{Kind: SegmentStraight, StartDistance: previous, EndDistance: end})
+	}
+	return segments
+}
+
+// SegmentReport is how a lap went through a segment of the reference lap. Speeds are in km/h.
+type SegmentReport struct {
+	Driver string        `json:"driver"`
+	Lap    int16         `json:"lap"`
+	Kind   SegmentKind   `json:"kind"`
+	Number int           `json:"number"`
+	Start  time.Time     `json:"start"`
+	Time   time.Duration `json:"time"`
+	// Time compared to the reference lap, negative when faster
+	TimeLost time.Duration `json:"timeLost"`
+
+	EntrySpeed float32 `json:"entrySpeed"`
+	// Lowest speed, at the apex of corners
+	ApexSpeed float32 `json:"apexSpeed"`
+	ExitSpeed float32 `json:"exitSpeed"`
+	TopSpeed  float32 `json:"topSpeed"`
+
+	// Braking point of corners: where, from the start of the lap, the brake was first applied
+	// since the apex of the previous corner, and how far before the corner it is
+	Braked           bool    `json:"braked"`
+	BrakeDistance    float64 `json:"brakeDistance"`
+	BrakeBeforeEntry float64 `json:"brakeBeforeEntry"`
+}
+
+// Brake position, in %, counted as braking
+const minBrake = 5
+
+// CornerReports goes through every lap of the samples along the corners and straights of the
+// fastest complete lap, the reference. There are no reports without a complete lap.
+func CornerReports(samples []gt7.Sample, config CornerConfig) []SegmentReport {
+	laps := SplitLaps(samples)
+	reference := ReferenceLap(laps)
+	if reference == nil {
+		return nil
+	}
+	segments := TrackSegments(reference, LapCorners(reference, config))
+
+	var reports []SegmentReport
+	for i := range laps {
+		lap := &laps[i]
+		if !lap.Started {
+			// Distances don't start at the start line
+			continue
+		}
+		lapEnd := lap.Distance[len(lap.Distance)-1]
+		for j, seg := range segments {
+			referenceTime := segmentReport(reference, seg).Time
+			if seg.EndDistance > lapEnd {
+				if !lap.Complete {
+					// The lap hasn't reached the segment yet
+					break
+				}
+				// A complete lap driven on a shorter line ends before the reference: its last
+				// segments end at the finish line
+				seg = clampSegment(seg, lapEnd)
+			}
+			r := segmentReport(lap, seg)
+			r.TimeLost = r.Time - referenceTime
+			if seg.Kind == SegmentCorner {
+				brakeFrom := 0.0
+				for k := j - 1; k >= 0; k-- {
+					if segments[k].Kind == SegmentCorner {
+						brakeFrom = segments[k].ApexDistance
+						break
+					}
+				}
+				r.Braked, r.BrakeDistance = brakingPoint(lap, brakeFrom, seg.ApexDistance)
+				if r.Braked {
+					r.BrakeBeforeEntry = seg.StartDistance - r.BrakeDistance
+				}
+			}
+			reports = append(reports, r)
+		}
+	}
+	return reports
+}
+
+func clampSegment(seg Segment, end float64) Segment {
+	clamp := func(d float64) float64 {
+		if d > end {
+			return end
+		}
+		return d
+	}
+	seg.StartDistance = clamp(seg.StartDistance)
+	seg.ApexDistance = clamp(seg.ApexDistance)
+	seg.EndDistance = end
+	return seg
+}
+
+func segmentReport(lap *Lap, seg Segment) SegmentReport {
+	start := lap.TimeAt(seg.StartDistance)
+	r := SegmentReport{
+		Driver: lap.Driver,
+		Lap:    lap.Number,
+		Kind:   seg.Kind,
+		Number: seg.Number,
+		Start:  start,
+		Time:   lap.TimeAt(seg.EndDistance).Sub(start),
+	}
+
+	first, last := lap.index(seg.StartDistance), lap.index(seg.EndDistance)
+	if last >= len(lap.Samples) {
+		last = len(lap.Samples) - 1
+	}
+	if first > last {
+		first = last
+	}
+	r.EntrySpeed = lap.Samples[first].Frame.CarSpeed
+	r.ExitSpeed = lap.Samples[last].Frame.CarSpeed
+	r.ApexSpeed, r.TopSpeed = r.EntrySpeed, r.EntrySpeed
+	for _, s := range lap.Samples[first : last+1] {
+		if s.Frame.CarSpeed < r.ApexSpeed {
+			r.ApexSpeed = s.Frame.CarSpeed
+		}
+		if s.Frame.CarSpeed > r.TopSpeed {
+			r.TopSpeed = s.Frame.CarSpeed
+		}
+	}
+	return r
+}
+
+// brakingPoint returns the distance of the first brake application between from and to
+func brakingPoint(lap *Lap, from, to float64) (bool, float64) {
+	for i := lap.index(from); i < len(lap.Samples) && lap.Distance[i] <= to; i++ {
+		if lap.Samples[i].Frame.Brake >= minBrake {
+			return true, lap.Distance[i]
+		}
+	}
+	return false, 0
+}
@@ -0,0 +1,63 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCornerReports(t *testing.T) {
+	// The first lap brakes the latest, and is the reference
+	laps := []ovalLap{{brake: 12}, {}, {scale: 0.995}}
+	reports := CornerReports(ovalSamples(laps, nil), DefaultCornerConfig)
+
+	segments := []struct {
+		kind   SegmentKind
+		number int
+	}{
+		{SegmentStraight, 1},
+		{SegmentCorner, 1},
+		{SegmentStraight, 2},
+		{SegmentCorner, 2},
+		// To the finish line, even on the shorter line of the last lap
+		{SegmentStraight, 0},
+	}
+	// Lap 0 isn't started, and the last one hasn't reached the first corner
+	if len(reports) != len(laps)*len(segments) {
+		t.Fatalf("CornerReports() returned %d reports, want %d", len(reports), len(laps)*len(segments))
+	}
+	for i, r := range reports {
+		lap, seg := laps[i/len(segments)], segments[i%len(segments)]
+		if r.Lap != int16(i/len(segments)+1) || r.Kind != seg.kind || r.Number != seg.number {
+			t.Errorf("report %d is lap %d %s %d, want lap %d %s %d", i, r.Lap, r.Kind, r.Number, i/len(segments)+1, seg.kind, seg.number)
+			continue
+		}
+		if r.Time <= 0 {
+			t.Errorf("lap %d %s %d: Time = %v, want more than 0", r.Lap, r.Kind, r.Number, r.Time)
+		}
+		switch {
+		case r.Lap == 1 && r.TimeLost != 0:
+			t.Errorf("lap 1 %s %d: TimeLost = %v against itself", r.Kind, r.Number, r.TimeLost)
+		case r.Lap == 2 && r.Kind == SegmentStraight && r.Number > 0 && r.TimeLost <= 0:
+			t.Errorf("lap 2 straight %d: TimeLost = %v, want more than 0 braking earlier", r.Number, r.TimeLost)
+		}
+		if r.Kind != SegmentCorner {
+			continue
+		}
+
+		if math.Abs(float64(r.ApexSpeed)-80) > 0.5 {
+			t.Errorf("lap %d corner %d: ApexSpeed = %.1f, want 80", r.Lap, r.Number, r.ApexSpeed)
+		}
+		scale := lap.scale
+		if scale == 0 {
+			scale = 1
+		}
+		want := lap.brakeDistance(r.Number) * scale
+		if !r.Braked || math.Abs(r.BrakeDistance-want) > 2 {
+			t.Errorf("lap %d corner %d: braked %v at %.1f, want at %.1f", r.Lap, r.Number, r.Braked, r.BrakeDistance, want)
+		}
+		// Before the corner of the reference lap
+		if want := ovalCornerStart(r.Number) - want; math.Abs(r.BrakeBeforeEntry-want) > 3 {
+			t.Errorf("lap %d corner %d: BrakeBeforeEntry = %.1f, want %.1f", r.Lap, r.Number, r.BrakeBeforeEntry, want)
+		}
+	}
+}
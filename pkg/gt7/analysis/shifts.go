@@ -0,0 +1,332 @@
+package analysis
+
+import (
+	"sort"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// ShiftConfig holds the settings of the shift point analysis. The optimal upshift RPM of a gear
+// is where the next gear starts accelerating harder at the same speed, from the mean full
+// throttle acceleration of every gear per RPM bin.
+type ShiftConfig struct {
+	// Throttle position, in %, from which the car counts as flat out
+	FullThrottle float64
+	// Width of the RPM bins the acceleration is averaged over
+	RPMBin float64
+	// Bins with fewer samples are ignored
+	MinBinSamples int
+	// Samples this soon after a gear change are left out of the acceleration, as the clutch
+	// may still be slipping
+	ShiftSettle time.Duration
+}
+
+// DefaultShiftConfig averages the acceleration over 250 RPM bins, above 95% of throttle
+var DefaultShiftConfig = ShiftConfig{
+	FullThrottle:  95,
+	RPMBin:        250,
+	MinBinSamples: 3,
+	ShiftSettle:   300 * time.Millisecond,
+}
+
+// Highest forward gear of the TelemetryFrame ratios
+const maxGear = 8
+
+// GearRatio returns the ratio of a forward gear, 0 when the car has no such gear
+func GearRatio(f *packet.TelemetryFrame, gear uint8) float32 {
+	ratios := [maxGear]float32{f.Gear1, f.Gear2, f.Gear3, f.Gear4, f.Gear5, f.Gear6, f.Gear7, f.Gear8}
+	if gear < 1 || gear > maxGear {
+		return 0
+	}
+	return ratios[gear-1]
+}
+
+// ShiftPoint compares the optimal upshift RPM of a gear of a car with the driver's full
+// throttle upshifts out of it
+type ShiftPoint struct {
+	Driver string `json:"driver"`
+	CarID  int32  `json:"carId"`
+	Gear   uint8  `json:"gear"`
+	// Optimal upshift RPM, 0 when the acceleration of the gear or of the next one isn't known
+	// at comparable speeds
+	OptimalRPM float64 `json:"optimalRpm"`
+	// Full throttle upshifts out of the gear, and their mean RPM
+	Shifts  int     `json:"shifts"`
+	MeanRPM float64 `json:"meanRpm"`
+}
+
+// GearShift is a full throttle upshift
+type GearShift struct {
+	Driver string    `json:"driver"`
+	CarID  int32     `json:"carId"`
+	Lap    int16     `json:"lap"`
+	Time   time.Time `json:"time"`
+	From   uint8     `json:"from"`
+	To     uint8     `json:"to"`
+	// Engine and car speed, in km/h, in the last sample before the shift
+	RPM   float32 `json:"rpm"`
+	Speed float32 `json:"speed"`
+	// Optimal upshift RPM of the gear, 0 when unknown, and how much later (positive) or
+	// earlier the shift was
+	OptimalRPM float64 `json:"optimalRpm"`
+	RPMDelta   float64 `json:"rpmDelta"`
+}
+
+// LapGears sums up the gear usage of a lap
+type LapGears struct {
+	Driver string `json:"driver"`
+	Lap    int16  `json:"lap"`
+	// Time spent in every gear, reverse first
+	GearTime [maxGear + 1]time.Duration `json:"gearTime"`
+	// Time on the rev limiter, from the simulator flag, and with the shift lights on, at or
+	// above RPMRevWarning
+	LimiterTime     time.Duration `json:"limiterTime"`
+	ShiftLightsTime time.Duration `json:"shiftLightsTime"`
+	// Time in another gear than the suggested one, while GT7 suggests one
+	OffSuggestedTime time.Duration `json:"offSuggestedTime"`
+	Upshifts         int           `json:"upshifts"`
+	Downshifts       int           `json:"downshifts"`
+	// Mean RPMDelta of the full throttle upshifts whose optimal RPM is known
+	MeanRPMDelta float64 `json:"meanRpmDelta"`
+}
+
+// ShiftReport holds the shift point and gear usage analysis of samples
+type ShiftReport struct {
+	Points []ShiftPoint
+	Shifts []GearShift
+	Laps   []LapGears
+}
+
+// rpmBin is the acceleration of a gear in an RPM bin
+type rpmBin struct {
+	sum   float64
+	count int
+}
+
+// car holds the full throttle acceleration, in g, of every gear of a car per RPM bin
+type car struct {
+	ratios  [maxGear + 1]float32
+	limiter uint16
+	curves  [maxGear + 1]map[int]*rpmBin
+	// Optimal upshift RPM of every gear
+	optimal [maxGear + 1]float64
+}
+
+func (c *car) acceleration(gear uint8, rpm float64, config ShiftConfig) (float64, bool) {
+	b, ok := c.curves[gear][int(rpm/config.RPMBin)]
+	if !ok || b.count < config.MinBinSamples {
+		return 0, false
+	}
+	return b.sum / float64(b.count), true
+}
+
+// optimalUpshift returns the highest RPM at which the gear still accelerates at least as hard
+// as the next one would at the same speed, the limiter when it does up to its highest bin
+func (c *car) optimalUpshift(gear uint8, config ShiftConfig) float64 {
+	if gear >= maxGear || c.ratios[gear] <= 0 || c.ratios[gear+1] <= 0 {
+		return 0
+	}
+	bins := make([]int, 0, len(c.curves[gear]))
+	for bin := range c.curves[gear] {
+		bins = append(bins, bin)
+	}
+	sort.Ints(bins)
+
+	optimal, lowest, better := 0.0, -1.0, false
+	for _, bin := range bins {
+		rpm := (float64(bin) + 0.5) * config.RPMBin
+		a, ok := c.acceleration(gear, rpm, config)
+		if !ok {
+			continue
+		}
+		// Same speed in the next gear
+		next, ok := c.acceleration(gear+1, rpm*float64(c.ratios[gear+1]/c.ratios[gear]), config)
+		if !ok {
+			continue
+		}
+		if lowest < 0 {
+			lowest = float64(bin) * config.RPMBin
+		}
+		better = a >= next
+		if better {
+			optimal = float64(bin+1) * config.RPMBin
+		}
+	}
+	switch {
+	case lowest < 0:
+		return 0
+	case better && c.limiter > 0:
+		return float64(c.limiter)
+	case optimal == 0:
+		// The next gear is better from the lowest comparable RPM
+		return lowest
+	}
+	return optimal
+}
+
+// ShiftAnalysis computes the optimal upshift RPM of every gear from the full throttle
+// acceleration, and compares it with the full throttle upshifts. Gear usage is summed up per
+// lap. Samples of a driver must be in time order.
+func ShiftAnalysis(samples []gt7.Sample, config ShiftConfig) ShiftReport {
+	var report ShiftReport
+	for _, driverSamples := range byDriver(samples) {
+		laps := splitLaps(driverSamples)
+		cars := map[int32]*car{}
+		var order []int32
+		for i := range laps {
+			order = append(order, addAcceleration(cars, &laps[i], config)...)
+		}
+		for _, c := range cars {
+			for gear := uint8(1); gear < maxGear; gear++ {
+				c.optimal[gear] = c.optimalUpshift(gear, config)
+			}
+		}
+
+		var shifts []GearShift
+		for i := range laps {
+			lapGears, lapShifts := gearUsage(cars, &laps[i], config)
+			report.Laps = append(report.Laps, lapGears)
+			shifts = append(shifts, lapShifts...)
+		}
+		report.Shifts = append(report.Shifts, shifts...)
+
+		for _, id := range order {
+			c := cars[id]
+			for gear := uint8(1); gear < maxGear && c.ratios[gear+1] > 0; gear++ {
+				p := ShiftPoint{
+					Driver:     laps[0].Driver,
+					CarID:      id,
+					Gear:       gear,
+					OptimalRPM: c.optimal[gear],
+				}
+				for _, s := range shifts {
+					if s.CarID == id && s.From == gear {
+						p.Shifts++
+						p.MeanRPM += float64(s.RPM)
+					}
+				}
+				if p.Shifts > 0 {
+					p.MeanRPM /= float64(p.Shifts)
+				}
+				report.Points = append(report.Points, p)
+			}
+		}
+	}
+	return report
+}
+
+// addAcceleration adds the full throttle samples of a lap to the acceleration curves of their
+// car, and returns the cars seen for the first time
+func addAcceleration(cars map[int32]*car, lap *Lap, config ShiftConfig) []int32 {
+	samples := lap.Samples
+	decel := deceleration(samples)
+
+	var added []int32
+	var shifted time.Time
+	for i, s := range samples {
+		f := &s.Frame
+		c, ok := cars[f.CarID]
+		if !ok {
+			c = &car{}
+			for gear := range c.curves {
+				c.curves[gear] = map[int]*rpmBin{}
+			}
+			cars[f.CarID] = c
+			added = append(added, f.CarID)
+		}
+		// Imported sessions may lack the gear ratios
+		if f.Gear1 > 0 {
+			for gear := uint8(1); gear <= maxGear; gear++ {
+				c.ratios[gear] = GearRatio(f, gear)
+			}
+		}
+		if f.RPMRevLimiter > c.limiter {
+			c.limiter = f.RPMRevLimiter
+		}
+
+		if i == 0 || f.CurrentGear != samples[i-1].Frame.CurrentGear || s.Received.Sub(samples[i-1].Received) > maxSampleGap {
+			shifted = s.Received
+		}
+		gear := f.CurrentGear
+		if f.IsPaused || gear < 1 || gear > maxGear || float64(f.Throttle) < config.FullThrottle ||
+			float64(f.Brake) >= minBrake || s.Received.Sub(shifted) < config.ShiftSettle {
+			continue
+		}
+		bin := int(float64(f.RPM) / config.RPMBin)
+		b, ok := c.curves[gear][bin]
+		if !ok {
+			b = &rpmBin{}
+			c.curves[gear][bin] = b
+		}
+		b.sum -= decel[i]
+		b.count++
+	}
+	return added
+}
+
+// SuggestedGear when GT7 suggests none
+const noSuggestedGear = 15
+
+// gearUsage sums up the gear usage of a lap, and returns its full throttle upshifts
+func gearUsage(cars map[int32]*car, lap *Lap, config ShiftConfig) (LapGears, []GearShift) {
+	samples := lap.Samples
+	usage := LapGears{Driver: lap.Driver, Lap: lap.Number}
+
+	var shifts []GearShift
+	deltas := 0
+	for i := 1; i < len(samples); i++ {
+		previous, s := &samples[i-1], &samples[i]
+		f := &s.Frame
+		elapsed := s.Received.Sub(previous.Received)
+		if elapsed > maxSampleGap || f.IsPaused {
+			continue
+		}
+		if f.CurrentGear <= maxGear {
+			usage.GearTime[f.CurrentGear] += elapsed
+		}
+		if f.Flags&packet.SimFlagRevLimiterAlert != 0 {
+			usage.LimiterTime += elapsed
+		}
+		if f.RPMRevWarning > 0 && f.RPM >= float32(f.RPMRevWarning) {
+			usage.ShiftLightsTime += elapsed
+		}
+		if f.SuggestedGear != noSuggestedGear && f.SuggestedGear != f.CurrentGear {
+			usage.OffSuggestedTime += elapsed
+		}
+
+		from, to := previous.Frame.CurrentGear, f.CurrentGear
+		switch {
+		case from == to || from < 1 || to < 1 || from > maxGear || to > maxGear:
+		case to < from:
+			usage.Downshifts++
+		default:
+			usage.Upshifts++
+			if float64(previous.Frame.Throttle) < config.FullThrottle {
+				continue
+			}
+			shift := GearShift{
+				Driver: lap.Driver,
+				CarID:  f.CarID,
+				Lap:    lap.Number,
+				Time:   s.Received,
+				From:   from,
+				To:     to,
+				RPM:    previous.Frame.RPM,
+				Speed:  previous.Frame.CarSpeed,
+			}
+			if c, ok := cars[f.CarID]; ok && c.optimal[from] > 0 {
+				shift.OptimalRPM = c.optimal[from]
+				shift.RPMDelta = float64(shift.RPM) - shift.OptimalRPM
+				usage.MeanRPMDelta += shift.RPMDelta
+				deltas++
+			}
+			shifts = append(shifts, shift)
+		}
+	}
+	if deltas > 0 {
+		usage.MeanRPMDelta /= float64(deltas)
+	}
+	return usage, shifts
+}
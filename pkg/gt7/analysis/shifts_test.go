@@ -0,0 +1,261 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+func TestGearUsage(t *testing.T) {
+	// phase is a run of samples in the same state
+	type phase struct {
+		samples   int
+		gear      uint8
+		suggested uint8
+		rpm       float32
+		throttle  float32
+		limiter   bool
+	}
+	tests := []struct {
+		name   string
+		phases []phase
+		// Expected intervals spent in each state
+		gearTime        [maxGear + 1]int
+		limiterTime     int
+		shiftLightsTime int
+		offSuggested    int
+		upshifts        int
+		downshifts      int
+		shifts          []GearShift
+	}{
+		{
+			name:     "full throttle upshift",
+			phases:   []phase{{samples: 30, gear: 2, rpm: 6500, throttle: 100}, {samples: 30, gear: 3, rpm: 5000, throttle: 100}},
+			gearTime: [maxGear + 1]int{2: 29, 3: 30},
+			upshifts: 1,
+			shifts:   []GearShift{{From: 2, To: 3, RPM: 6500}},
+		},
+		{
+			name:     "part throttle upshift",
+			phases:   []phase{{samples: 30, gear: 2, rpm: 6500, throttle: 50}, {samples: 30, gear: 3, rpm: 5000, throttle: 50}},
+			gearTime: [maxGear + 1]int{2: 29, 3: 30},
+			upshifts: 1,
+		},
+		{
+			name:       "downshift",
+			phases:     []phase{{samples: 30, gear: 3, rpm: 4000}, {samples: 30, gear: 2, rpm: 5300}},
+			gearTime:   [maxGear + 1]int{2: 30, 3: 29},
+			downshifts: 1,
+		},
+		{
+			name: "shift lights, then the limiter",
+			phases: []phase{
+				{samples: 30, gear: 2, rpm: 6000, throttle: 100},
+				{samples: 30, gear: 2, rpm: 7200, throttle: 100},
+				{samples: 30, gear: 2, rpm: 8000, throttle: 100, limiter: true},
+			},
+			gearTime:        [maxGear + 1]int{2: 89},
+			shiftLightsTime: 60,
+			limiterTime:     30,
+		},
+		{
+			name: "rev limiter flag below the shift lights",
+			phases: []phase{
+				{samples: 30, gear: 2, rpm: 6000, throttle: 100},
+				{samples: 30, gear: 2, rpm: 6500, throttle: 100, limiter: true},
+			},
+			gearTime:    [maxGear + 1]int{2: 59},
+			limiterTime: 30,
+		},
+		{
+			name:         "off the suggested gear",
+			phases:       []phase{{samples: 30, gear: 4, suggested: 3, rpm: 5000}, {samples: 30, gear: 4, rpm: 5000}},
+			gearTime:     [maxGear + 1]int{4: 59},
+			offSuggested: 29,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var samples []gt7.Sample
+			for _, p := range tt.phases {
+				for i := 0; i < p.samples; i++ {
+					s := testSample(len(samples))
+					f := &s.Frame
+					f.CurrentLap = 1
+					f.CurrentGear = p.gear
+					f.SuggestedGear = p.suggested
+					if p.suggested == 0 {
+						f.SuggestedGear = noSuggestedGear
+					}
+					f.RPM, f.Throttle = p.rpm, p.throttle
+					f.RPMRevWarning, f.RPMRevLimiter = 7000, 8000
+					if p.limiter {
+						f.Flags |= packet.SimFlagRevLimiterAlert
+					}
+					samples = append(samples, s)
+				}
+			}
+
+			report := ShiftAnalysis(samples, DefaultShiftConfig)
+			if len(report.Laps) != 1 {
+				t.Fatalf("ShiftAnalysis() returned %d laps, want 1", len(report.Laps))
+			}
+			got := report.Laps[0]
+			for gear, n := range tt.gearTime {
+				if got.GearTime[gear] != time.Duration(n)*testInterval {
+					t.Errorf("GearTime[%d] = %v, want %v", gear, got.GearTime[gear], time.Duration(n)*testInterval)
+				}
+			}
+			durations := []struct {
+				name      string
+				got, want time.Duration
+			}{
+				{"LimiterTime", got.LimiterTime, time.Duration(tt.limiterTime) * testInterval},
+				{"ShiftLightsTime", got.ShiftLightsTime, time.Duration(tt.shiftLightsTime) * testInterval},
+				{"OffSuggestedTime", got.OffSuggestedTime, time.Duration(tt.offSuggested) * testInterval},
+			}
+			for _, d := range durations {
+				if d.got != d.want {
+					t.Errorf("%s = %v, want %v", d.name, d.got, d.want)
+				}
+			}
+			if got.Upshifts != tt.upshifts || got.Downshifts != tt.downshifts {
+				t.Errorf("Upshifts, Downshifts = %d, %d, want %d, %d", got.Upshifts, got.Downshifts, tt.upshifts, tt.downshifts)
+			}
+
+			if len(report.Shifts) != len(tt.shifts) {
+				t.Fatalf("ShiftAnalysis() returned %d shifts, want %d", len(report.Shifts), len(tt.shifts))
+			}
+			for i, want := range tt.shifts {
+				s := report.Shifts[i]
+				if s.From != want.From || s.To != want.To || s.RPM != want.RPM || s.Lap != 1 {
+					t.Errorf("shift %d = %d to %d at %v rpm on lap %d, want %d to %d at %v rpm on lap 1", i, s.From, s.To, s.RPM, s.Lap, want.From, want.To, want.RPM)
+				}
+			}
+		})
+	}
+}
+
+// Drivetrain of the simulated car: rpm per m/s and gear ratio unit, and wheel force per N·m
+// and gear ratio unit, for 0.33 m tyres and a final drive of 4
+const (
+	testRPMPerSpeed = 4 * 60 / (2 * math.Pi * 0.33)
+	testForce       = 4 / 0.33
+	testMass        = 1200
+	testLimiter     = 8000
+)
+
+// peakyTorque peaks at 5000 rpm, with 220 N·m left at 2000 and 8000 rpm
+func peakyTorque(rpm float64) float64 {
+	return 400 - 0.00002*(rpm-5000)*(rpm-5000)
+}
+
+func flatTorque(float64) float64 {
+	return 300
+}
+
+// accelerationRuns drives every gear flat out from 2000 rpm to the limiter, then half a second
+// on it, runs two seconds apart, and ends with a run shifting from first to second gear at
+// shiftRPM
+func accelerationRuns(ratios []float32, torque func(float64) float64, shiftRPM float64) []gt7.Sample {
+	var samples []gt7.Sample
+	received := testStart
+	run := func(gears []uint8, shiftRPM float64) {
+		gear := 0
+		speed := 2000 / testRPMPerSpeed / float64(ratios[gears[0]-1])
+		for held := 0; held < 30; {
+			ratio := float64(ratios[gears[gear]-1])
+			rpm := speed * ratio * testRPMPerSpeed
+			s := gt7.Sample{Driver: "driver", Received: received}
+			f := &s.Frame
+			f.CurrentLap, f.CarID = 1, 1
+			f.CurrentGear, f.SuggestedGear = gears[gear], noSuggestedGear
+			f.Throttle = 100
+			f.RPMRevLimiter = testLimiter
+			f.Gear1, f.Gear2 = ratios[0], ratios[1]
+			if rpm >= testLimiter {
+				rpm = testLimiter
+				f.Flags |= packet.SimFlagRevLimiterAlert
+				held++
+			} else {
+				speed += torque(rpm) * ratio * testForce / testMass * testInterval.Seconds()
+			}
+			f.RPM = float32(rpm)
+			f.CarSpeed = float32(speed * 3.6)
+			samples = append(samples, s)
+			received = received.Add(testInterval)
+
+			if gear+1 < len(gears) && rpm >= shiftRPM {
+				gear++
+			}
+		}
+		received = received.Add(2 * time.Second)
+	}
+	run([]uint8{1}, 0)
+	run([]uint8{2}, 0)
+	run([]uint8{1, 2}, shiftRPM)
+	return samples
+}
+
+// optimalRPM returns the highest rpm at which first gear accelerates at least as hard as
+// second would at the same speed
+func optimalRPM(ratios []float32, torque func(float64) float64) float64 {
+	r1, r2 := float64(ratios[0]), float64(ratios[1])
+	optimal := 0.0
+	for rpm := 2000.0; rpm <= testLimiter; rpm++ {
+		if torque(rpm)*r1 >= torque(rpm*r2/r1)*r2 {
+			optimal = rpm
+		}
+	}
+	return optimal
+}
+
+func TestShiftPoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		ratios []float32
+		torque func(float64) float64
+		// Whether first gear pulls harder up to the limiter
+		limiter bool
+	}{
+		{name: "close ratios", ratios: []float32{3.5, 3}, torque: peakyTorque},
+		{name: "wide ratios", ratios: []float32{3.5, 2.4}, torque: peakyTorque},
+		{name: "flat torque", ratios: []float32{3.5, 2.4}, torque: flatTorque, limiter: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := optimalRPM(tt.ratios, tt.torque)
+			if tt.limiter != (want == testLimiter) {
+				t.Fatalf("optimal shift at %.0f rpm, the test expects the limiter: %v", want, tt.limiter)
+			}
+			report := ShiftAnalysis(accelerationRuns(tt.ratios, tt.torque, 6000), DefaultShiftConfig)
+
+			if len(report.Points) != 1 {
+				t.Fatalf("ShiftAnalysis() returned %d shift points, want 1: %+v", len(report.Points), report.Points)
+			}
+			p := report.Points[0]
+			// Within a bin, and the sample interval
+			if p.Gear != 1 || math.Abs(p.OptimalRPM-want) > DefaultShiftConfig.RPMBin+100 {
+				t.Errorf("gear %d: OptimalRPM = %.0f, want gear 1 at %.0f", p.Gear, p.OptimalRPM, want)
+			}
+			if p.Shifts != 1 || math.Abs(p.MeanRPM-6000) > 100 {
+				t.Errorf("Shifts, MeanRPM = %d, %.0f, want 1, 6000", p.Shifts, p.MeanRPM)
+			}
+
+			if len(report.Shifts) != 1 {
+				t.Fatalf("ShiftAnalysis() returned %d shifts, want 1", len(report.Shifts))
+			}
+			s := report.Shifts[0]
+			if s.OptimalRPM != p.OptimalRPM || s.RPMDelta != float64(s.RPM)-p.OptimalRPM {
+				t.Errorf("shift at %.0f rpm: OptimalRPM, RPMDelta = %.0f, %.0f, want %.0f, %.0f", s.RPM, s.OptimalRPM, s.RPMDelta, p.OptimalRPM, float64(s.RPM)-p.OptimalRPM)
+			}
+			// Every run ends half a second on the limiter
+			if got := report.Laps[0].LimiterTime; got < 80*testInterval || got > 90*testInterval {
+				t.Errorf("LimiterTime = %v, want about 1.5s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+// Package analysis derives driving events and per-lap reports from decoded samples, for
+// coaching rather than live display.
+package analysis
+
+import (
+	"sort"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// Wheels in the order of the TelemetryFrame fields
+var Wheels = []string{"FL", "FR", "RL", "RR"}
+
+// SlipKind tells lockups and wheelspin apart
+type SlipKind string
+
+const (
+	// Wheel turning slower than the car while braking
+	SlipLockup SlipKind = "lockup"
+	// Wheel turning faster than the car while on the throttle
+	SlipWheelspin SlipKind = "wheelspin"
+)
+
+// SlipConfig holds the thresholds of the SlipDetector. Slip is how far the tyre slip ratio is
+// from 1, e.g. 0.2 for a wheel turning 20% slower or faster than the car.
+type SlipConfig struct {
+	// Slip starting a lockup, under braking
+	LockupSlip float64
+	// Slip starting wheelspin, on the throttle
+	WheelspinSlip float64
+	// How much the slip has to drop below the threshold to end an event
+	Hysteresis float64
+	// Pedal positions, in %, below which lockups and wheelspin aren't considered
+	MinBrake    float64
+	MinThrottle float64
+	// Car speed, in km/h, below which slip ratios are meaningless
+	MinSpeed float64
+	// Shorter events are dropped
+	MinDuration time.Duration
+}
+
+// DefaultSlipConfig flags a wheel 20% slower than the car under braking, or 25% faster on
+// the throttle, for at least 100 ms
+var DefaultSlipConfig = SlipConfig{
+	LockupSlip:    0.2,
+	WheelspinSlip: 0.25,
+	Hysteresis:    0.1,
+	MinBrake:      5,
+	MinThrottle:   5,
+	MinSpeed:      15,
+	MinDuration:   100 * time.Millisecond,
+}
+
+// SlipEvent is a lockup or wheelspin of a wheel
+type SlipEvent struct {
+	Driver string   `json:"driver"`
+	Wheel  string   `json:"wheel"`
+	Kind   SlipKind `json:"kind"`
+	Lap    int16    `json:"lap"`
+
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration"`
+
+	// Where the event started
+	PositionX float32 `json:"positionX"`
+	PositionY float32 `json:"positionY"`
+	PositionZ float32 `json:"positionZ"`
+	// Car speed when the event started, in km/h
+	Speed float32 `json:"speed"`
+	// Largest slip during the event
+	PeakSlip float64 `json:"peakSlip"`
+}
+
+// slipState is an event in progress on a wheel
+type slipState struct {
+	event SlipEvent
+	last  time.Time
+}
+
+// SlipDetector follows the slip ratio of every wheel of every driver, and reports the events
+// once they end. A wheel leaves a lockup or wheelspin when its slip drops below the threshold
+// minus the hysteresis, or when the pedal is released.
+type SlipDetector struct {
+	config SlipConfig
+	active map[string]*[4]*slipState
+}
+
+func NewSlipDetector(config SlipConfig) *SlipDetector {
+	return &SlipDetector{
+		config: config,
+		active: map[string]*[4]*slipState{},
+	}
+}
+
+// Gaps longer than this, e.g. menus, end the events in progress
+const maxSampleGap = time.Second
+
+func slipRatios(tf *packet.TelemetryFrame) [4]float32 {
+	return [4]float32{tf.TyreSlipRatioFL, tf.TyreSlipRatioFR, tf.TyreSlipRatioRL, tf.TyreSlipRatioRR}
+}
+
+// Detect returns the events of the driver that ended with this sample
+func (d *SlipDetector) Detect(s gt7.Sample) []SlipEvent {
+	wheels, ok := d.active[s.Driver]
+	if !ok {
+		wheels = &[4]*slipState{}
+		d.active[s.Driver] = wheels
+	}
+
+	f := &s.Frame
+	onTrack := !f.IsPaused && float64(f.CarSpeed) >= d.config.MinSpeed
+	ratios := slipRatios(f)
+
+	var events []SlipEvent
+	for i, ratio := range ratios {
+		state := wheels[i]
+		if state != nil && (s.Received.Sub(state.last) > maxSampleGap || state.event.Lap != f.CurrentLap) {
+			events = d.end(events, state, state.last)
+			state = nil
+		}
+
+		kind, slip := d.classify(f, float64(ratio))
+		if state != nil {
+			threshold := d.threshold(state.event.Kind) - d.config.Hysteresis
+			if !onTrack || kind != state.event.Kind || slip < threshold {
+				events = d.end(events, state, s.Received)
+				state = nil
+			} else {
+				if slip > state.event.PeakSlip {
+					state.event.PeakSlip = slip
+				}
+				state.last = s.Received
+			}
+		}
+
+		if state == nil && onTrack && kind != "" && slip >= d.threshold(kind) {
+			state = &slipState{
+				event: SlipEvent{
+					Driver:    s.Driver,
+					Wheel:     Wheels[i],
+					Kind:      kind,
+					Lap:       f.CurrentLap,
+					Start:     s.Received,
+					PositionX: f.PositionX,
+					PositionY: f.PositionY,
+					PositionZ: f.PositionZ,
+					Speed:     f.CarSpeed,
+					PeakSlip:  slip,
+				},
+				last: s.Received,
+			}
+		}
+		wheels[i] = state
+	}
+	return events
+}
+
+// classify returns the kind of slip the pedals allow, and the slip in that direction
+func (d *SlipDetector) classify(f *packet.TelemetryFrame, ratio float64) (SlipKind, float64) {
+	if ratio < 0 {
+		// No slip ratio when the car is stopped
+		return "", 0
+	}
+	switch {
+	case float64(f.Brake) >= d.config.MinBrake:
+		return SlipLockup, 1 - ratio
+	case float64(f.Throttle) >= d.config.MinThrottle:
+		return SlipWheelspin, ratio - 1
+	}
+	return "", 0
+}
+
+func (d *SlipDetector) threshold(kind SlipKind) float64 {
+	if kind == SlipLockup {
+		return d.config.LockupSlip
+	}
+	return d.config.WheelspinSlip
+}
+
+func (d *SlipDetector) end(events []SlipEvent, state *slipState, end time.Time) []SlipEvent {
+	e := state.event
+	e.End = end
+	e.Duration = end.Sub(e.Start)
+	if e.Duration < d.config.MinDuration {
+		return events
+	}
+	return append(events, e)
+}
+
+// Flush ends the events in progress, at the last sample they were seen in
+func (d *SlipDetector) Flush() []SlipEvent {
+	drivers := make([]string, 0, len(d.active))
+	for driver := range d.active {
+		drivers = append(drivers, driver)
+	}
+	sort.Strings(drivers)
+
+	var events []SlipEvent
+	for _, driver := range drivers {
+		wheels := d.active[driver]
+		for i, state := range wheels {
+			if state != nil {
+				events = d.end(events, state, state.last)
+				wheels[i] = nil
+			}
+		}
+	}
+	return events
+}
+
+// SlipEvents returns every lockup and wheelspin of the samples, by start time
+func SlipEvents(samples []gt7.Sample, config SlipConfig) []SlipEvent {
+	detector := NewSlipDetector(config)
+	var events []SlipEvent
+	for _, s := range samples {
+		events = append(events, detector.Detect(s)...)
+	}
+	events = append(events, detector.Flush()...)
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	return events
+}
+
+// LapSlip sums up the slip events of a wheel during a lap
+type LapSlip struct {
+	Driver        string        `json:"driver"`
+	Lap           int16         `json:"lap"`
+	Wheel         string        `json:"wheel"`
+	Lockups       int           `json:"lockups"`
+	LockupTime    time.Duration `json:"lockupTime"`
+	Wheelspins    int           `json:"wheelspins"`
+	WheelspinTime time.Duration `json:"wheelspinTime"`
+	// Largest slip of any event
+	PeakSlip float64 `json:"peakSlip"`
+}
+
+// SummarizeSlip returns a LapSlip for every driver, lap and wheel that had events, in that order
+func SummarizeSlip(events []SlipEvent) []LapSlip {
+	type key struct {
+		driver string
+		lap    int16
+		wheel  string
+	}
+	summaries := map[key]*LapSlip{}
+	var keys []key
+	for _, e := range events {
+		k := key{e.Driver, e.Lap, e.Wheel}
+		s, ok := summaries[k]
+		if !ok {
+			s = &LapSlip{Driver: e.Driver, Lap: e.Lap, Wheel: e.Wheel}
+			summaries[k] = s
+			keys = append(keys, k)
+		}
+		if e.Kind == SlipLockup {
+			s.Lockups++
+			s.LockupTime += e.Duration
+		} else {
+			s.Wheelspins++
+			s.WheelspinTime += e.Duration
+		}
+		if e.PeakSlip > s.PeakSlip {
+			s.PeakSlip = e.PeakSlip
+		}
+	}
+
+	wheelIndex := map[string]int{}
+	for i, w := range Wheels {
+		wheelIndex[w] = i
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.driver != b.driver {
+			return a.driver < b.driver
+		}
+		if a.lap != b.lap {
+			return a.lap < b.lap
+		}
+		return wheelIndex[a.wheel] < wheelIndex[b.wheel]
+	})
+
+	laps := make([]LapSlip, 0, len(keys))
+	for _, k := range keys {
+		laps = append(laps, *summaries[k])
+	}
+	return laps
+}
@@ -0,0 +1,149 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+)
+
+var testStart = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// Interval of the synthetic samples, GT7 sends 60 packets a second
+const testInterval = time.Second / 60
+
+// testSample returns the i-th sample of a driver
+func testSample(i int) gt7.Sample {
+	s := gt7.Sample{Driver: "driver", Received: testStart.Add(time.Duration(i) * testInterval)}
+	s.Frame.PackageID = int32(i)
+	return s
+}
+
+// slipSamples is a lap 1 run at 100 km/h, with the pedal and slip ratio of a wheel set from
+// sample first to last included
+func slipSamples(n, wheel, first, last int, ratio, brake, throttle float32) []gt7.Sample {
+	samples := make([]gt7.Sample, n)
+	for i := range samples {
+		s := testSample(i)
+		f := &s.Frame
+		f.CurrentLap = 1
+		f.CarSpeed = 100
+		f.TyreSlipRatioFL, f.TyreSlipRatioFR, f.TyreSlipRatioRL, f.TyreSlipRatioRR = 1, 1, 1, 1
+		if i >= first && i <= last {
+			f.Brake, f.Throttle = brake, throttle
+			switch wheel {
+			case 0:
+				f.TyreSlipRatioFL = ratio
+			case 1:
+				f.TyreSlipRatioFR = ratio
+			case 2:
+				f.TyreSlipRatioRL = ratio
+			case 3:
+				f.TyreSlipRatioRR = ratio
+			}
+		}
+		samples[i] = s
+	}
+	return samples
+}
+
+func TestSlipEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []gt7.Sample
+		// Expected event, none when wheel is empty
+		wheel    string
+		kind     SlipKind
+		start    int
+		duration time.Duration
+		peak     float64
+	}{
+		{
+			name:    "lockup",
+			samples: slipSamples(120, 0, 30, 47, 0.5, 80, 0),
+			wheel:   "FL",
+			kind:    SlipLockup,
+			start:   30,
+			// Ends with the first sample back in grip
+			duration: 18 * testInterval,
+			peak:     0.5,
+		},
+		{
+			name:     "wheelspin",
+			samples:  slipSamples(120, 3, 60, 89, 1.4, 0, 100),
+			wheel:    "RR",
+			kind:     SlipWheelspin,
+			start:    60,
+			duration: 30 * testInterval,
+			peak:     0.4,
+		},
+		{
+			name:    "lockup too short",
+			samples: slipSamples(120, 1, 30, 34, 0.5, 80, 0),
+		},
+		{
+			name:    "slip off the pedals",
+			samples: slipSamples(120, 0, 30, 89, 0.5, 0, 0),
+		},
+		{
+			name:    "slip under the threshold",
+			samples: slipSamples(120, 2, 30, 89, 0.85, 80, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := SlipEvents(tt.samples, DefaultSlipConfig)
+			if tt.wheel == "" {
+				if len(events) != 0 {
+					t.Fatalf("SlipEvents() = %+v, want none", events)
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("SlipEvents() returned %d events, want 1: %+v", len(events), events)
+			}
+			e := events[0]
+			if e.Wheel != tt.wheel || e.Kind != tt.kind || e.Lap != 1 {
+				t.Errorf("event = %s %s lap %d, want %s %s lap 1", e.Wheel, e.Kind, e.Lap, tt.wheel, tt.kind)
+			}
+			if want := tt.samples[tt.start].Received; !e.Start.Equal(want) {
+				t.Errorf("Start = %v, want %v", e.Start, want)
+			}
+			if e.Duration != tt.duration {
+				t.Errorf("Duration = %v, want %v", e.Duration, tt.duration)
+			}
+			if e.PeakSlip < tt.peak-1e-6 || e.PeakSlip > tt.peak+1e-6 {
+				t.Errorf("PeakSlip = %v, want %v", e.PeakSlip, tt.peak)
+			}
+		})
+	}
+}
+
+func TestSummarizeSlip(t *testing.T) {
+	samples := slipSamples(120, 0, 10, 29, 0.5, 80, 0)
+	// A second lockup of the same wheel, and wheelspin of another one
+	for i := 50; i < 70; i++ {
+		samples[i].Frame.Brake = 80
+		samples[i].Frame.TyreSlipRatioFL = 0.6
+	}
+	for i := 90; i < 110; i++ {
+		samples[i].Frame.Throttle = 100
+		samples[i].Frame.TyreSlipRatioRL = 1.5
+	}
+
+	got := SummarizeSlip(SlipEvents(samples, DefaultSlipConfig))
+	want := []LapSlip{
+		{Driver: "driver", Lap: 1, Wheel: "FL", Lockups: 2, LockupTime: 40 * testInterval, PeakSlip: 0.5},
+		{Driver: "driver", Lap: 1, Wheel: "RL", Wheelspins: 1, WheelspinTime: 20 * testInterval, PeakSlip: 0.5},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("SummarizeSlip() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		g := got[i]
+		g.PeakSlip = float64(float32(g.PeakSlip))
+		if g != want[i] {
+			t.Errorf("SummarizeSlip()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// Package capture reads UDP datagrams from pcap and pcapng files, such as Wireshark or
+// tcpdump captures of a PlayStation's telemetry.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// Link types, see https://www.tcpdump.org/linktypes.html
+const (
+	linkTypeNull      = 0
+	linkTypeEthernet  = 1
+	linkTypeRaw       = 101
+	linkTypeLoop      = 108
+	linkTypeLinuxSLL  = 113
+	linkTypeIPv4      = 228
+	linkTypeIPv6      = 229
+	linkTypeLinuxSLL2 = 276
+)
+
+const (
+	pcapMagicMicro   = 0xa1b2c3d4
+	pcapMagicNano    = 0xa1b23c4d
+	pcapngBlockSHB   = 0x0a0d0d0a
+	pcapngByteOrder  = 0x1a2b3c4d
+	maxPacketSize    = 1 << 18
+	maxPcapngBlock   = 1 << 24
+	etherTypeIPv4    = 0x0800
+	etherTypeIPv6    = 0x86dd
+	etherTypeVLAN    = 0x8100
+	etherTypeQinQ    = 0x88a8
+	ipProtocolUDP    = 17
+	ipv4HeaderLength = 20
+	ipv6HeaderLength = 40
+	udpHeaderLength  = 8
+)
+
+var ErrNotCapture = errors.New("not a pcap or pcapng file")
+
+// Datagram is a UDP datagram found in a capture
+type Datagram struct {
+	// Capture timestamp
+	Time time.Time
+	Src  net.UDPAddr
+	Dst  net.UDPAddr
+	Data []byte
+}
+
+// packetSource returns the link layer frames of a capture, one per call
+type packetSource interface {
+	next() (t time.Time, linkType uint16, frame []byte, err error)
+}
+
+// Reader returns the UDP datagrams of a capture, skipping every other packet
+type Reader struct {
+	src  packetSource
+	file *os.File
+}
+
+// Open opens a pcap or pcapng file
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	r.file = f
+
+	return r, nil
+}
+
+// NewReader reads a pcap or pcapng capture, telling them apart by their magic number
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, ErrNotCapture
+	}
+
+	var src packetSource
+	switch {
+	case binary.LittleEndian.Uint32(magic) == pcapngBlockSHB:
+		src, err = newPcapngReader(br)
+	default:
+		src, err = newPcapReader(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{src: src}, nil
+}
+
+// Next returns the next UDP datagram, or io.EOF at the end of the capture
+func (r *Reader) Next() (Datagram, error) {
+	for {
+		t, linkType, frame, err := r.src.next()
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				// Capture cut short, e.g. by stopping tcpdump
+				return Datagram{}, io.EOF
+			}
+			return Datagram{}, err
+		}
+
+		d, ok := parseFrame(linkType, frame)
+		if !ok {
+			continue
+		}
+		d.Time = t
+		return d, nil
+	}
+}
+
+func (r *Reader) Close() error {
+	if r.file != nil {
+		return r.file.Close()
+	}
+	return nil
+}
+
+// parseFrame extracts the UDP datagram of a link layer frame
+func parseFrame(linkType uint16, frame []byte) (Datagram, bool) {
+	switch linkType {
+	case linkTypeEthernet:
+		if len(frame) < 14 {
+			return Datagram{}, false
+		}
+		etherType := binary.BigEndian.Uint16(frame[12:14])
+		payload := frame[14:]
+		// 802.1Q tags, possibly stacked
+		for (etherType == etherTypeVLAN || etherType == etherTypeQinQ) && len(payload) >= 4 {
+			etherType = binary.BigEndian.Uint16(payload[2:4])
+			payload = payload[4:]
+		}
+		if etherType != etherTypeIPv4 && etherType != etherTypeIPv6 {
+			return Datagram{}, false
+		}
+		return parseIP(payload)
+	case linkTypeNull, linkTypeLoop:
+		// The address family, whose values differ between systems, is redundant with the IP version
+		if len(frame) < 4 {
+			return Datagram{}, false
+		}
+		return parseIP(frame[4:])
+	case linkTypeLinuxSLL:
+		if len(frame) < 16 {
+			return Datagram{}, false
+		}
+		return parseIP(frame[16:])
+	case linkTypeLinuxSLL2:
+		if len(frame) < 20 {
+			return Datagram{}, false
+		}
+		return parseIP(frame[20:])
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+		return parseIP(frame)
+	}
+	return Datagram{}, false
+}
+
+func parseIP(b []byte) (Datagram, bool) {
+	if len(b) == 0 {
+		return Datagram{}, false
+	}
+	switch b[0] >> 4 {
+	case 4:
+		return parseIPv4(b)
+	case 6:
+		return parseIPv6(b)
+	}
+	return Datagram{}, false
+}
+
+func parseIPv4(b []byte) (Datagram, bool) {
+	if len(b) < ipv4HeaderLength || b[9] != ipProtocolUDP {
+		return Datagram{}, false
+	}
+	headerLength := int(b[0]&0x0f) * 4
+	totalLength := int(binary.BigEndian.Uint16(b[2:4]))
+	if headerLength < ipv4HeaderLength || totalLength > len(b) || totalLength < headerLength+udpHeaderLength {
+		return Datagram{}, false
+	}
+	// Flags and fragment offset in 8 byte units
+	fragment := binary.BigEndian.Uint16(b[6:8])
+	if isFragment(fragment&0x1fff, fragment&0x2000 != 0) {
+		return Datagram{}, false
+	}
+
+	return parseUDP(net.IP(append([]byte(nil), b[12:16]...)), net.IP(append([]byte(nil), b[16:20]...)), b[headerLength:totalLength])
+}
+
+// IPv6 extension headers that can come before the UDP header
+const (
+	ipv6HopByHop    = 0
+	ipv6Routing     = 43
+	ipv6Fragment    = 44
+	ipv6Destination = 60
+)
+
+func parseIPv6(b []byte) (Datagram, bool) {
+	if len(b) < ipv6HeaderLength {
+		return Datagram{}, false
+	}
+	payloadLength := int(binary.BigEndian.Uint16(b[4:6]))
+	if ipv6HeaderLength+payloadLength > len(b) {
+		return Datagram{}, false
+	}
+	src := net.IP(append([]byte(nil), b[8:24]...))
+	dst := net.IP(append([]byte(nil), b[24:40]...))
+
+	next := b[6]
+	payload := b[ipv6HeaderLength : ipv6HeaderLength+payloadLength]
+	for {
+		switch next {
+		case ipProtocolUDP:
+			return parseUDP(src, dst, payload)
+		case ipv6HopByHop, ipv6Routing, ipv6Destination:
+			if len(payload) < 8 {
+				return Datagram{}, false
+			}
+			length := (int(payload[1]) + 1) * 8
+			if length > len(payload) {
+				return Datagram{}, false
+			}
+			next = payload[0]
+			payload = payload[length:]
+		case ipv6Fragment:
+			if len(payload) < 8 {
+				return Datagram{}, false
+			}
+			// Fragment offset in 8 byte units, then flags
+			fragment := binary.BigEndian.Uint16(payload[2:4])
+			if isFragment(fragment>>3, fragment&0x1 != 0) {
+				return Datagram{}, false
+			}
+			next = payload[0]
+			payload = payload[8:]
+		default:
+			return Datagram{}, false
+		}
+	}
+}
+
+// isFragment tells whether a packet is part of a fragmented datagram, from its fragment offset
+// and more fragments flag. Only the first fragment has the UDP header, and even that one
+// holds a truncated datagram, so fragments are skipped: GT7 datagrams are never fragmented.
+// An IPv6 fragment header with offset 0 and no more fragments is an atomic fragment, a whole
+// datagram.
+func isFragment(offset uint16, more bool) bool {
+	return offset != 0 || more
+}
+
+func parseUDP(src, dst net.IP, udp []byte) (Datagram, bool) {
+	if len(udp) < udpHeaderLength {
+		return Datagram{}, false
+	}
+	udpLength := int(binary.BigEndian.Uint16(udp[4:6]))
+	if udpLength < udpHeaderLength || udpLength > len(udp) {
+		return Datagram{}, false
+	}
+
+	return Datagram{
+		Src:  net.UDPAddr{IP: src, Port: int(binary.BigEndian.Uint16(udp[0:2]))},
+		Dst:  net.UDPAddr{IP: dst, Port: int(binary.BigEndian.Uint16(udp[2:4]))},
+		Data: append([]byte(nil), udp[udpHeaderLength:udpLength]...),
+	}, true
+}
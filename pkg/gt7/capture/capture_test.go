@@ -0,0 +1,87 @@
+package capture
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// udpPacket is a UDP header from port 33740 to 33739 followed by payload
+func udpPacket(payload []byte) []byte {
+	udp := make([]byte, udpHeaderLength, udpHeaderLength+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], 33740)
+	binary.BigEndian.PutUint16(udp[2:4], 33739)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpHeaderLength+len(payload)))
+	return append(udp, payload...)
+}
+
+// ipv4Packet is a UDP packet with the given flags and fragment offset field
+func ipv4Packet(fragment uint16, udp []byte) []byte {
+	b := make([]byte, ipv4HeaderLength, ipv4HeaderLength+len(udp))
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:4], uint16(ipv4HeaderLength+len(udp)))
+	binary.BigEndian.PutUint16(b[6:8], fragment)
+	b[9] = ipProtocolUDP
+	copy(b[12:16], []byte{192, 168, 1, 20})
+	copy(b[16:20], []byte{192, 168, 1, 10})
+	return append(b, udp...)
+}
+
+// ipv6Packet is a UDP packet behind a fragment header with the given offset and flags field
+func ipv6Packet(fragment uint16, udp []byte) []byte {
+	b := make([]byte, ipv6HeaderLength+8, ipv6HeaderLength+8+len(udp))
+	b[0] = 0x60
+	binary.BigEndian.PutUint16(b[4:6], uint16(8+len(udp)))
+	b[6] = ipv6Fragment
+	b[23], b[39] = 2, 1
+	b[ipv6HeaderLength] = ipProtocolUDP
+	binary.BigEndian.PutUint16(b[ipv6HeaderLength+2:], fragment)
+	return append(b, udp...)
+}
+
+func TestFragments(t *testing.T) {
+	payload := []byte("telemetry")
+	tests := []struct {
+		name string
+		// Fragment offset in 8 byte units, and more fragments flag
+		offset uint16
+		more   bool
+		want   bool
+	}{
+		{name: "whole datagram", want: true},
+		{name: "first fragment", more: true},
+		{name: "middle fragment", offset: 185, more: true},
+		{name: "last fragment", offset: 185},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// IPv4 puts the flags before the offset, IPv6 after it
+			v4 := tt.offset
+			v6 := tt.offset << 3
+			if tt.more {
+				v4 |= 0x2000
+				v6 |= 0x1
+			}
+			packets := []struct {
+				version string
+				b       []byte
+			}{
+				{"IPv4", ipv4Packet(v4, udpPacket(payload))},
+				// Don't fragment doesn't make a packet a fragment
+				{"IPv4 with don't fragment", ipv4Packet(v4|0x4000, udpPacket(payload))},
+				{"IPv6", ipv6Packet(v6, udpPacket(payload))},
+				// Reserved bits between the offset and the flag are ignored
+				{"IPv6 with reserved bits", ipv6Packet(v6|0x6, udpPacket(payload))},
+			}
+			for _, p := range packets {
+				d, ok := parseIP(p.b)
+				if ok != tt.want {
+					t.Errorf("%s: parseIP() = %v, want %v", p.version, ok, tt.want)
+					continue
+				}
+				if ok && (string(d.Data) != string(payload) || d.Src.Port != 33740 || d.Dst.Port != 33739) {
+					t.Errorf("%s: parseIP() = %q from port %d to %d, want %q from 33740 to 33739", p.version, d.Data, d.Src.Port, d.Dst.Port, payload)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package capture
+
+import (
+	"io"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// Filter tells GT7 datagrams apart from the rest of a capture by their ports
+type Filter struct {
+	HeartbeatPort int
+	ServerPort    int
+}
+
+// DefaultFilter matches the ports GT7 uses unless remapped
+var DefaultFilter = Filter{HeartbeatPort: gt7.DefaultHeartbeatPort, ServerPort: gt7.DefaultServerPort}
+
+// Heartbeat reports whether a datagram is a heartbeat sent to a console
+func (f Filter) Heartbeat(d Datagram) bool {
+	return d.Dst.Port == f.HeartbeatPort && len(d.Data) < packet.PacketSize
+}
+
+// Telemetry reports whether a datagram may be telemetry sent by a console, which is then
+// its source
+func (f Filter) Telemetry(d Datagram) bool {
+	return (d.Dst.Port == f.ServerPort || d.Src.Port == f.HeartbeatPort) && len(d.Data) >= packet.PacketSize
+}
+
+// Decoder decodes the telemetry of a capture with a packet.Decoder per console
+type Decoder struct {
+	Filter Filter
+
+	// Counters of what was seen so far
+	Heartbeats     int
+	Datagrams      int
+	DecodeFailures int
+
+	decoders map[string]*packet.Decoder
+}
+
+func NewDecoder(f Filter) *Decoder {
+	return &Decoder{
+		Filter:   f,
+		decoders: map[string]*packet.Decoder{},
+	}
+}
+
+// Decode returns the sample of a telemetry datagram, with the capture time as receive time
+// and the console IP as driver. It returns false for any other datagram, and for packets
+// that are duplicated or reordered.
+func (d *Decoder) Decode(dg Datagram) (gt7.Sample, bool) {
+	if d.Filter.Heartbeat(dg) {
+		d.Heartbeats++
+		return gt7.Sample{}, false
+	}
+	if !d.Filter.Telemetry(dg) {
+		return gt7.Sample{}, false
+	}
+	d.Datagrams++
+
+	console := dg.Src.IP.String()
+	decoder, ok := d.decoders[console]
+	if !ok {
+		decoder = packet.NewDecoder()
+		d.decoders[console] = decoder
+	}
+
+	frame, err := decoder.ReadPacket(dg.Data)
+	if err != nil {
+		d.DecodeFailures++
+		return gt7.Sample{}, false
+	}
+	if frame.Stale() {
+		return gt7.Sample{}, false
+	}
+
+	return gt7.Sample{Driver: console, Frame: *frame, Received: dg.Time, Raw: dg.Data}, true
+}
+
+// ReadSamples decodes the telemetry of every console in a capture file
+func ReadSamples(path string, f Filter) ([]gt7.Sample, error) {
+	r, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	decoder := NewDecoder(f)
+	var samples []gt7.Sample
+	for {
+		dg, err := r.Next()
+		if err == io.EOF {
+			return samples, nil
+		}
+		if err != nil {
+			return samples, err
+		}
+
+		if s, ok := decoder.Decode(dg); ok {
+			samples = append(samples, s)
+		}
+	}
+}
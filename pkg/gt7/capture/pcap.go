@@ -0,0 +1,65 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// pcapReader reads the classic libpcap format
+type pcapReader struct {
+	r        *bufio.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType uint16
+}
+
+func newPcapReader(r *bufio.Reader) (*pcapReader, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrNotCapture
+	}
+
+	p := &pcapReader{r: r}
+	switch {
+	case binary.LittleEndian.Uint32(header[0:4]) == pcapMagicMicro:
+		p.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header[0:4]) == pcapMagicMicro:
+		p.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(header[0:4]) == pcapMagicNano:
+		p.order, p.nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(header[0:4]) == pcapMagicNano:
+		p.order, p.nano = binary.BigEndian, true
+	default:
+		return nil, ErrNotCapture
+	}
+	p.linkType = uint16(p.order.Uint32(header[20:24]))
+
+	return p, nil
+}
+
+func (p *pcapReader) next() (time.Time, uint16, []byte, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(p.r, header[:]); err != nil {
+		return time.Time{}, 0, nil, err
+	}
+
+	length := p.order.Uint32(header[8:12])
+	if length > maxPacketSize {
+		return time.Time{}, 0, nil, fmt.Errorf("pcap record of %d bytes", length)
+	}
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(p.r, frame); err != nil {
+		return time.Time{}, 0, nil, err
+	}
+
+	fraction := int64(p.order.Uint32(header[4:8]))
+	if !p.nano {
+		fraction *= 1000
+	}
+	t := time.Unix(int64(p.order.Uint32(header[0:4])), fraction)
+
+	return t, p.linkType, frame, nil
+}
@@ -0,0 +1,184 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"time"
+)
+
+// pcapng block types
+const (
+	pcapngBlockIDB = 0x00000001
+	pcapngBlockSPB = 0x00000003
+	pcapngBlockEPB = 0x00000006
+
+	pcapngOptionEnd      = 0
+	pcapngOptionTSResol  = 9
+	pcapngDefaultTSResol = 6
+)
+
+type pcapngInterface struct {
+	linkType uint16
+	// Timestamp units per second
+	resolution uint64
+}
+
+// pcapngReader reads the pcapng format, see https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-02.html
+type pcapngReader struct {
+	r          *bufio.Reader
+	order      binary.ByteOrder
+	interfaces []pcapngInterface
+}
+
+func newPcapngReader(r *bufio.Reader) (*pcapngReader, error) {
+	p := &pcapngReader{r: r}
+	// The section header block is read like any other, it sets the byte order
+	return p, nil
+}
+
+func (p *pcapngReader) readBlock() (uint32, []byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(p.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	if binary.LittleEndian.Uint32(header[0:4]) == pcapngBlockSHB {
+		var magic [4]byte
+		if _, err := io.ReadFull(p.r, magic[:]); err != nil {
+			return 0, nil, err
+		}
+		switch {
+		case binary.LittleEndian.Uint32(magic[:]) == pcapngByteOrder:
+			p.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic[:]) == pcapngByteOrder:
+			p.order = binary.BigEndian
+		default:
+			return 0, nil, ErrNotCapture
+		}
+		// Interfaces are numbered per section
+		p.interfaces = nil
+
+		length := p.order.Uint32(header[4:8])
+		if length < 16 || length > maxPcapngBlock {
+			return 0, nil, fmt.Errorf("invalid pcapng section header length %d", length)
+		}
+		body := make([]byte, length-12)
+		if _, err := io.ReadFull(p.r, body); err != nil {
+			return 0, nil, err
+		}
+		return pcapngBlockSHB, body[:len(body)-4], nil
+	}
+
+	if p.order == nil {
+		return 0, nil, ErrNotCapture
+	}
+
+	blockType := p.order.Uint32(header[0:4])
+	length := p.order.Uint32(header[4:8])
+	if length < 12 || length%4 != 0 || length > maxPcapngBlock {
+		return 0, nil, fmt.Errorf("invalid pcapng block length %d", length)
+	}
+	body := make([]byte, length-8)
+	if _, err := io.ReadFull(p.r, body); err != nil {
+		return 0, nil, err
+	}
+	// Drop the trailing copy of the length
+	return blockType, body[:len(body)-4], nil
+}
+
+func (p *pcapngReader) next() (time.Time, uint16, []byte, error) {
+	for {
+		blockType, body, err := p.readBlock()
+		if err != nil {
+			return time.Time{}, 0, nil, err
+		}
+
+		switch blockType {
+		case pcapngBlockIDB:
+			if len(body) < 8 {
+				return time.Time{}, 0, nil, fmt.Errorf("short pcapng interface block")
+			}
+			resolution, err := p.tsResolution(body[8:])
+			if err != nil {
+				return time.Time{}, 0, nil, err
+			}
+			p.interfaces = append(p.interfaces, pcapngInterface{
+				linkType:   p.order.Uint16(body[0:2]),
+				resolution: resolution,
+			})
+
+		case pcapngBlockEPB:
+			if len(body) < 20 {
+				return time.Time{}, 0, nil, fmt.Errorf("short pcapng packet block")
+			}
+			id := p.order.Uint32(body[0:4])
+			if int(id) >= len(p.interfaces) {
+				return time.Time{}, 0, nil, fmt.Errorf("pcapng packet on unknown interface %d", id)
+			}
+			iface := p.interfaces[id]
+			length := p.order.Uint32(body[12:16])
+			if int(length) > len(body)-20 {
+				return time.Time{}, 0, nil, fmt.Errorf("pcapng packet longer than its block")
+			}
+			ts := uint64(p.order.Uint32(body[4:8]))<<32 | uint64(p.order.Uint32(body[8:12]))
+			return iface.time(ts), iface.linkType, body[20 : 20+length], nil
+
+		case pcapngBlockSPB:
+			// Simple packets have no timestamp and belong to the first interface
+			if len(p.interfaces) == 0 || len(body) < 4 {
+				continue
+			}
+			length := p.order.Uint32(body[0:4])
+			if int(length) > len(body)-4 {
+				length = uint32(len(body) - 4)
+			}
+			return time.Time{}, p.interfaces[0].linkType, body[4 : 4+length], nil
+		}
+	}
+}
+
+// tsResolution reads the if_tsresol option of an interface block. Resolutions must fit in
+// 64 bits: powers of 10 up to 10^19 and powers of 2 up to 2^63.
+func (p *pcapngReader) tsResolution(options []byte) (uint64, error) {
+	resolution := pow10(pcapngDefaultTSResol)
+	for len(options) >= 4 {
+		code := p.order.Uint16(options[0:2])
+		length := int(p.order.Uint16(options[2:4]))
+		if code == pcapngOptionEnd || 4+length > len(options) {
+			break
+		}
+		if code == pcapngOptionTSResol && length >= 1 {
+			v := options[4]
+			switch exponent := v & 0x7f; {
+			case v&0x80 != 0 && exponent <= 63:
+				resolution = 1 << exponent
+			case v&0x80 == 0 && exponent <= 19:
+				resolution = pow10(exponent)
+			default:
+				return 0, fmt.Errorf("unsupported pcapng timestamp resolution 0x%02x", v)
+			}
+		}
+		options = options[4+(length+3)/4*4:]
+	}
+	return resolution, nil
+}
+
+func pow10(exponent uint8) uint64 {
+	v := uint64(1)
+	for ; exponent > 0; exponent-- {
+		v *= 10
+	}
+	return v
+}
+
+func (i pcapngInterface) time(ts uint64) time.Time {
+	seconds := ts / i.resolution
+	fraction := ts % i.resolution
+	// fraction * 1e9 may not fit in 64 bits, the quotient does as fraction < resolution
+	hi, lo := bits.Mul64(fraction, uint64(time.Second))
+	nanoseconds, _ := bits.Div64(hi, lo, i.resolution)
+	return time.Unix(int64(seconds), int64(nanoseconds))
+}
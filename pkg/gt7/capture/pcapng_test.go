@@ -0,0 +1,54 @@
+package capture
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestTSResolution(t *testing.T) {
+	tests := []struct {
+		name    string
+		tsresol byte
+		ts      uint64
+		want    time.Time
+		invalid bool
+	}{
+		{name: "microseconds", tsresol: 6, ts: 1_500_000_250, want: time.Unix(1500, 250_000)},
+		{name: "nanoseconds", tsresol: 9, ts: 1_500_000_000_250, want: time.Unix(1500, 250)},
+		{name: "seconds", tsresol: 0, ts: 1500, want: time.Unix(1500, 0)},
+		{name: "10^19", tsresol: 19, ts: 1<<64 - 1, want: time.Unix(1, 844_674_407)},
+		{name: "2^-10", tsresol: 0x80 | 10, ts: 1536, want: time.Unix(1, 500_000_000)},
+		{name: "2^-63", tsresol: 0x80 | 63, ts: 3 << 62, want: time.Unix(1, 500_000_000)},
+		{name: "10^20", tsresol: 20, invalid: true},
+		{name: "2^-64", tsresol: 0x80 | 64, invalid: true},
+		{name: "2^-127", tsresol: 0xff, invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &pcapngReader{order: binary.LittleEndian}
+			options := []byte{pcapngOptionTSResol, 0, 1, 0, tt.tsresol, 0, 0, 0, pcapngOptionEnd, 0, 0, 0}
+			resolution, err := p.tsResolution(options)
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("tsResolution() = %d, want an error", resolution)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("tsResolution() failed: %v", err)
+			}
+			if got := (pcapngInterface{resolution: resolution}).time(tt.ts); !got.Equal(tt.want) {
+				t.Errorf("time(%d) = %v, want %v", tt.ts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTSResolutionDefault(t *testing.T) {
+	p := &pcapngReader{order: binary.LittleEndian}
+	resolution, err := p.tsResolution(nil)
+	if err != nil || resolution != 1_000_000 {
+		t.Errorf("tsResolution(nil) = %d, %v, want microseconds", resolution, err)
+	}
+}
@@ -0,0 +1,129 @@
+package gt7
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+const (
+	DefaultHeartbeatPort = 33739
+	DefaultServerPort    = 33740
+	DefaultNetwork       = "udp4"
+	DefaultIdleTimeout   = 3 * time.Second
+)
+
+// ServerConfig describes where the telemetry server listens and where heartbeats are sent
+type ServerConfig struct {
+	Consoles []Console
+	// Port the consoles listen for heartbeats on
+	HeartbeatPort int
+	// Port telemetry is received on, which may differ from 33740 behind a port mapping
+	ServerPort int
+	// IP address or interface name to bind to, empty for all interfaces
+	BindAddress string
+	// One of udp4, udp6 or udp (dual stack)
+	Network string
+	// Time without packets after which a console is considered idle
+	IdleTimeout time.Duration
+	// Counters updated by the server, DefaultStats when unset
+	Stats *Stats
+	// Downstream tools receiving a copy of every datagram from the consoles
+	Relay []RelayTarget
+	// Packet format requested by the heartbeats, A unless set. B and ~ add fields.
+	Format packet.Format
+}
+
+// WithDefaults fills unset values with the GT7 defaults
+func (c ServerConfig) WithDefaults() ServerConfig {
+	if c.HeartbeatPort == 0 {
+		c.HeartbeatPort = DefaultHeartbeatPort
+	}
+	if c.ServerPort == 0 {
+		c.ServerPort = DefaultServerPort
+	}
+	if c.Network == "" {
+		c.Network = DefaultNetwork
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = DefaultIdleTimeout
+	}
+	if c.Stats == nil {
+		c.Stats = DefaultStats
+	}
+	if c.Format == "" {
+		c.Format = packet.FormatA
+	}
+	return c
+}
+
+// Validate checks the configuration once defaults have been applied
+func (c ServerConfig) Validate() error {
+	switch c.Network {
+	case "udp4", "udp6", "udp":
+	default:
+		return fmt.Errorf("unsupported network %q, expected udp4, udp6 or udp", c.Network)
+	}
+	if c.HeartbeatPort < 1 || c.HeartbeatPort > 65535 {
+		return fmt.Errorf("invalid heartbeat port %d", c.HeartbeatPort)
+	}
+	if c.ServerPort < 1 || c.ServerPort > 65535 {
+		return fmt.Errorf("invalid server port %d", c.ServerPort)
+	}
+	if _, err := packet.ParseFormat(string(c.Format)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// listenAddr resolves the address the server binds to. BindAddress may be an IP address or
+// the name of a network interface, in which case its first address matching the network is used.
+func (c ServerConfig) listenAddr() (*net.UDPAddr, error) {
+	host := c.BindAddress
+	if host != "" && net.ParseIP(host) == nil {
+		ifaceHost, err := interfaceHost(host, c.Network)
+		if err != nil {
+			return nil, err
+		}
+		host = ifaceHost
+	}
+
+	return net.ResolveUDPAddr(c.Network, net.JoinHostPort(host, strconv.Itoa(c.ServerPort)))
+}
+
+// heartbeatAddr resolves the heartbeat address of a console
+func (c ServerConfig) heartbeatAddr(ip string) (*net.UDPAddr, error) {
+	return net.ResolveUDPAddr(c.Network, net.JoinHostPort(ip, strconv.Itoa(c.HeartbeatPort)))
+}
+
+func interfaceHost(name string, network string) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", fmt.Errorf("bind interface %q not found: %v", name, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		isV4 := ipNet.IP.To4() != nil
+		if (network == "udp4" && isV4) || (network == "udp6" && !isV4) || network == "udp" {
+			if ipNet.IP.IsLinkLocalUnicast() && !isV4 {
+				// Link-local IPv6 addresses are only usable with their zone
+				return ipNet.IP.String() + "%" + name, nil
+			}
+			return ipNet.IP.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("interface %q has no %s address", name, network)
+}
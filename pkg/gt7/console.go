@@ -0,0 +1,31 @@
+package gt7
+
+import (
+	"strings"
+)
+
+// Console is a PlayStation configured on the datasource, named after the driver using it
+type Console struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+}
+
+// Path returns the live channel path segment for the console's driver. Channel paths only
+// allow a restricted character set, so anything else is replaced with a dash.
+func (c Console) Path() string {
+	return DriverPath(c.Name)
+}
+
+// DriverPath turns a driver name into a live channel path segment
+func DriverPath(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,184 @@
+package gt7
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// Largest subnet (in host addresses) that is swept host by host on top of the broadcast
+const maxSweepHosts = 1024
+
+// DiscoveredConsole is a PlayStation that answered a discovery heartbeat
+type DiscoveredConsole struct {
+	IP    string `json:"ip"`
+	CarID int32  `json:"carId"`
+}
+
+// DiscoverPlayStations sends heartbeats to every local IPv4 subnet and collects the consoles
+// that start streaming telemetry back before the timeout expires. Only the ports and bind
+// address of the configuration are used, along with its packet format. Discovery always runs
+// over IPv4.
+func DiscoverPlayStations(ctx context.Context, cfg ServerConfig, timeout time.Duration) ([]DiscoveredConsole, error) {
+	cfg = cfg.WithDefaults()
+	cfg.Network = "udp4"
+
+	targets, err := discoveryTargets(cfg.HeartbeatPort)
+	if err != nil {
+		return nil, fmt.Errorf("listing network interfaces failed: %v", err)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no IPv4 network interface available for discovery")
+	}
+
+	listenAddr, err := cfg.listenAddr()
+	if err != nil {
+		return nil, fmt.Errorf("server address resolution failed: %v", err)
+	}
+
+	conn, err := net.ListenUDP("udp4", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("discovery listener failed (is a stream already running?): %v", err)
+	}
+	defer conn.Close()
+
+	log.DefaultLogger.Info("Discovering PlayStations", "targets", len(targets))
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	found := map[string]DiscoveredConsole{}
+	buffer := make([]byte, 4096)
+	lastHeartbeatTime := time.Time{}
+
+	for time.Now().Before(deadline) {
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Repeat the sweep every second, consoles sometimes miss the first heartbeat
+		if time.Since(lastHeartbeatTime) >= time.Second {
+			for _, target := range targets {
+				_, err := conn.WriteToUDP([]byte(cfg.Format), target)
+				if err != nil {
+					log.DefaultLogger.Debug("Discovery heartbeat failed", "target", target.String(), "err", err.Error())
+				}
+			}
+			lastHeartbeatTime = time.Now()
+		}
+
+		err = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if err != nil {
+			return nil, err
+		}
+
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			return nil, fmt.Errorf("discovery read failed: %v", err)
+		}
+
+		decrypted, err := packet.Decrypt(buffer[0:n])
+		if err != nil {
+			// Not GT7 telemetry
+			continue
+		}
+
+		ip := addr.IP.String()
+		if _, ok := found[ip]; !ok {
+			log.DefaultLogger.Info("Discovered PlayStation", "ip", ip)
+		}
+		carID, _ := packet.LayoutA.Field("CarID")
+		found[ip] = DiscoveredConsole{
+			IP:    ip,
+			CarID: int32(carID.Float(decrypted)),
+		}
+	}
+
+	consoles := make([]DiscoveredConsole, 0, len(found))
+	for _, c := range found {
+		consoles = append(consoles, c)
+	}
+	sort.Slice(consoles, func(i, j int) bool { return consoles[i].IP < consoles[j].IP })
+
+	return consoles, nil
+}
+
+// discoveryTargets returns the broadcast address of every IPv4 interface that is up, plus
+// every host address of subnets small enough to sweep.
+func discoveryTargets(port int) ([]*net.UDPAddr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []*net.UDPAddr
+	seen := map[string]bool{}
+	addTarget := func(ip net.IP) {
+		if seen[ip.String()] {
+			return
+		}
+		seen[ip.String()] = true
+		targets = append(targets, &net.UDPAddr{IP: ip, Port: port})
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipNet.IP.To4()
+			if ip == nil {
+				continue
+			}
+			mask := net.IP(ipNet.Mask).To4()
+			if mask == nil {
+				continue
+			}
+
+			network := binary.BigEndian.Uint32(ip) & binary.BigEndian.Uint32(mask)
+			broadcast := network | ^binary.BigEndian.Uint32(mask)
+
+			if iface.Flags&net.FlagBroadcast != 0 {
+				addTarget(uint32ToIP(broadcast))
+			}
+
+			if broadcast-network-1 > maxSweepHosts {
+				continue
+			}
+			for host := network + 1; host < broadcast; host++ {
+				if hostIP := uint32ToIP(host); !hostIP.Equal(ip) {
+					addTarget(hostIP)
+				}
+			}
+		}
+	}
+
+	return targets, nil
+}
+
+func uint32ToIP(v uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, v)
+	return ip
+}
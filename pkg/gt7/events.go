@@ -0,0 +1,99 @@
+package gt7
+
+import (
+	"sort"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// EventType identifies a discrete event detected in the telemetry
+type EventType string
+
+const (
+	// EventLapComplete is emitted when LastLap changes, Value is the lap time in ms
+	EventLapComplete EventType = "lap_complete"
+	// EventFlagChange is emitted when a simulator flag is set or cleared
+	EventFlagChange EventType = "flag_change"
+	// EventGearChange is emitted when the current gear changes, Value is the new gear
+	EventGearChange EventType = "gear_change"
+)
+
+// Event is a discrete change detected between two consecutive samples of a driver
+type Event struct {
+	Driver string    `json:"driver"`
+	Type   EventType `json:"type"`
+	Time   time.Time `json:"time"`
+	Lap    int16     `json:"lap"`
+	// Flag name for flag changes. On and Value are always sent, as false and 0 are changes too,
+	// e.g. a flag turning off or a shift into reverse.
+	Flag  string  `json:"flag,omitempty"`
+	On    bool    `json:"on"`
+	Value float64 `json:"value"`
+}
+
+// EventDetector compares each sample of a driver with the previous one. GT7 has no pit lane
+// flag, so pit entries can't be detected from the telemetry.
+type EventDetector struct {
+	previous map[string]packet.TelemetryFrame
+}
+
+func NewEventDetector() *EventDetector {
+	return &EventDetector{
+		previous: map[string]packet.TelemetryFrame{},
+	}
+}
+
+// Detect returns the events that happened between the previous sample of the driver and this one
+func (d *EventDetector) Detect(s Sample) []Event {
+	previous, ok := d.previous[s.Driver]
+	d.previous[s.Driver] = s.Frame
+	if !ok {
+		return nil
+	}
+
+	current := s.Frame
+	newEvent := func(t EventType) Event {
+		ts := s.Received
+		if ts.IsZero() {
+			ts = time.Now()
+		}
+		return Event{Driver: s.Driver, Type: t, Time: ts, Lap: current.CurrentLap}
+	}
+
+	var events []Event
+
+	if current.LastLap != previous.LastLap && current.LastLap > 0 {
+		e := newEvent(EventLapComplete)
+		// The lap that was just completed
+		e.Lap = current.CurrentLap - 1
+		e.Value = float64(current.LastLap)
+		events = append(events, e)
+	}
+
+	if changed := current.Flags ^ previous.Flags; changed != 0 {
+		flags := make([]uint16, 0, len(packet.SimFlagNames))
+		for flag := range packet.SimFlagNames {
+			flags = append(flags, flag)
+		}
+		sort.Slice(flags, func(i, j int) bool { return flags[i] < flags[j] })
+
+		for _, flag := range flags {
+			if changed&flag == 0 {
+				continue
+			}
+			e := newEvent(EventFlagChange)
+			e.Flag = packet.SimFlagNames[flag]
+			e.On = current.HasFlag(flag)
+			events = append(events, e)
+		}
+	}
+
+	if current.CurrentGear != previous.CurrentGear {
+		e := newEvent(EventGearChange)
+		e.Value = float64(current.CurrentGear)
+		events = append(events, e)
+	}
+
+	return events
+}
@@ -0,0 +1,54 @@
+package gt7
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+func TestEventJSON(t *testing.T) {
+	received := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		before, after packet.TelemetryFrame
+		want          []string
+	}{
+		{
+			name:   "flag turning off",
+			before: packet.TelemetryFrame{Flags: packet.SimFlagPaused},
+			want:   []string{`"type":"flag_change"`, `"flag":"Paused"`, `"on":false`},
+		},
+		{
+			name:  "flag turning on",
+			after: packet.TelemetryFrame{Flags: packet.SimFlagPaused},
+			want:  []string{`"type":"flag_change"`, `"on":true`},
+		},
+		{
+			name:   "shift into reverse",
+			before: packet.TelemetryFrame{CurrentGear: 1},
+			want:   []string{`"type":"gear_change"`, `"value":0`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewEventDetector()
+			d.Detect(Sample{Driver: "driver", Frame: tt.before, Received: received})
+			events := d.Detect(Sample{Driver: "driver", Frame: tt.after, Received: received.Add(time.Second)})
+			if len(events) != 1 {
+				t.Fatalf("Detect() returned %d events, want 1: %+v", len(events), events)
+			}
+			b, err := json.Marshal(events[0])
+			if err != nil {
+				t.Fatalf("Marshal() failed: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(b), want) {
+					t.Errorf("event %s doesn't contain %s", b, want)
+				}
+			}
+		})
+	}
+}
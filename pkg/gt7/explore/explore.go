@@ -0,0 +1,357 @@
+// Package explore computes per-offset statistics of decrypted datagrams, to help mapping the
+// bytes of the packet that are still unknown.
+package explore
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// Guess is the most likely interpretation of a word
+type Guess string
+
+const (
+	GuessConstant Guess = "constant"
+	GuessFloat    Guess = "float32"
+	GuessCounter  Guess = "counter"
+	GuessFlags    Guess = "flags"
+	GuessInt16    Guess = "int16x2"
+	GuessInt32    Guess = "int32"
+)
+
+// Correlation of a word with a known field
+type Correlation struct {
+	Field string
+	// Pearson coefficient, between -1 and 1
+	R float64
+}
+
+// Word holds the statistics of the 4 bytes at an offset over every datagram
+type Word struct {
+	Offset int
+	// Known fields within the word, empty when unmapped
+	Field string
+	// Bytes of the word no known field covers
+	Unmapped int
+
+	// Shannon entropy in bits of the word, and of each of its bytes
+	Entropy     float64
+	ByteEntropy [4]float64
+	// Share of datagrams whose value differs from the previous one
+	Changes float64
+	// First value seen, the value of constant words
+	First uint32
+
+	// Share of values that are finite floats of a plausible magnitude
+	Plausible          float64
+	FloatMin, FloatMax float64
+	IntMin, IntMax     int64
+	// Low and high halves as int16
+	LowMin, LowMax   int16
+	HighMin, HighMax int16
+
+	// Bits set at least once, and bits that changed between two datagrams
+	SetBits     uint32
+	ToggledBits uint32
+
+	Guess Guess
+	// Known fields whose values follow this word the most, strongest first
+	Correlations []Correlation
+}
+
+// Fields that aren't telemetry, never used for correlations
+var ignored = map[string]bool{"Magic": true, "IV": true}
+
+// Analyze returns the statistics of every word of the decrypted datagrams, in offset order,
+// up to the size of the smallest packet format among them. Datagrams too short for any
+// format are skipped. At most maxCorrelations correlations are kept per word.
+func Analyze(datagrams [][]byte, maxCorrelations int) []Word {
+	var data [][]byte
+	layout := packet.LayoutTilde
+	for _, d := range datagrams {
+		l := packet.LayoutFor(len(d))
+		if l == nil {
+			continue
+		}
+		if l.Size < layout.Size {
+			layout = l
+		}
+		data = append(data, d)
+	}
+
+	known := knownSeries(layout, data)
+
+	words := make([]Word, 0, layout.Size/4)
+	for offset := 0; offset+4 <= layout.Size; offset += 4 {
+		w := analyzeWord(data, offset)
+		w.Field, w.Unmapped = fieldAt(layout, offset)
+		if len(data) > 1 && w.Guess != GuessConstant {
+			w.Correlations = correlate(wordSeries(data, offset, w.Guess), known, offset, maxCorrelations)
+		}
+		words = append(words, w)
+	}
+	return words
+}
+
+func overlaps(f packet.Field, offset int) bool {
+	return f.Offset < offset+4 && f.Offset+f.Size() > offset
+}
+
+// fieldAt returns the names of the known fields within the word at offset, and how many of
+// its bytes they leave unmapped
+func fieldAt(layout *packet.Layout, offset int) (string, int) {
+	name := ""
+	var mapped [4]bool
+	for _, f := range layout.Fields {
+		if !overlaps(f, offset) || f.Name == packet.UnknownField {
+			continue
+		}
+		if name != "" {
+			name += ", "
+		}
+		name += f.Name
+		for i := f.Offset; i < f.Offset+f.Size(); i++ {
+			if i >= offset && i < offset+4 {
+				mapped[i-offset] = true
+			}
+		}
+	}
+
+	unmapped := 0
+	for _, m := range mapped {
+		if !m {
+			unmapped++
+		}
+	}
+	return name, unmapped
+}
+
+func analyzeWord(data [][]byte, offset int) Word {
+	w := Word{
+		Offset:   offset,
+		FloatMin: math.Inf(1), FloatMax: math.Inf(-1),
+		IntMin: math.MaxInt32, IntMax: math.MinInt32,
+		LowMin: math.MaxInt16, LowMax: math.MinInt16,
+		HighMin: math.MaxInt16, HighMax: math.MinInt16,
+	}
+	if len(data) == 0 {
+		w.Guess = GuessConstant
+		return w
+	}
+
+	values := map[uint32]int{}
+	var bytes [4]map[byte]int
+	for i := range bytes {
+		bytes[i] = map[byte]int{}
+	}
+
+	changes, plausible := 0, 0
+	increasing := true
+	var previous uint32
+	for n, d := range data {
+		v := binary.LittleEndian.Uint32(d[offset : offset+4])
+		values[v]++
+		for i := range bytes {
+			bytes[i][d[offset+i]]++
+		}
+
+		if n == 0 {
+			w.First = v
+		} else {
+			if v != previous {
+				changes++
+				w.ToggledBits |= v ^ previous
+			}
+			if int32(v) < int32(previous) {
+				increasing = false
+			}
+		}
+		previous = v
+		w.SetBits |= v
+
+		f := float64(math.Float32frombits(v))
+		if isPlausible(f) {
+			plausible++
+			w.FloatMin = math.Min(w.FloatMin, f)
+			w.FloatMax = math.Max(w.FloatMax, f)
+		}
+		i := int64(int32(v))
+		if i < w.IntMin {
+			w.IntMin = i
+		}
+		if i > w.IntMax {
+			w.IntMax = i
+		}
+		lo, hi := int16(v), int16(v>>16)
+		if lo < w.LowMin {
+			w.LowMin = lo
+		}
+		if lo > w.LowMax {
+			w.LowMax = lo
+		}
+		if hi < w.HighMin {
+			w.HighMin = hi
+		}
+		if hi > w.HighMax {
+			w.HighMax = hi
+		}
+	}
+
+	w.Entropy = entropy32(values, len(data))
+	for i := range bytes {
+		w.ByteEntropy[i] = entropy8(bytes[i], len(data))
+	}
+	if len(data) > 1 {
+		w.Changes = float64(changes) / float64(len(data)-1)
+	}
+	w.Plausible = float64(plausible) / float64(len(data))
+	if plausible == 0 {
+		w.FloatMin, w.FloatMax = 0, 0
+	}
+
+	switch {
+	case changes == 0:
+		w.Guess = GuessConstant
+	case w.Plausible >= 0.99:
+		w.Guess = GuessFloat
+	case increasing && w.Changes > 0.9:
+		w.Guess = GuessCounter
+	case bitCount(w.ToggledBits) <= 8 && len(values) <= 64:
+		w.Guess = GuessFlags
+	case w.HighMin != w.HighMax && !(w.HighMin >= -1 && w.HighMax <= 0):
+		w.Guess = GuessInt16
+	default:
+		w.Guess = GuessInt32
+	}
+	return w
+}
+
+// isPlausible tells floats apart from integers and random bytes read as floats
+func isPlausible(f float64) bool {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return false
+	}
+	a := math.Abs(f)
+	return a == 0 || (a >= 1e-6 && a <= 1e7)
+}
+
+func entropy32(counts map[uint32]int, total int) float64 {
+	e := 0.0
+	for _, c := range counts {
+		p := float64(c) / float64(total)
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+func entropy8(counts map[byte]int, total int) float64 {
+	e := 0.0
+	for _, c := range counts {
+		p := float64(c) / float64(total)
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+func bitCount(v uint32) int {
+	n := 0
+	for ; v != 0; v &= v - 1 {
+		n++
+	}
+	return n
+}
+
+// wordSeries reads the word at offset of every datagram with its guessed interpretation
+func wordSeries(data [][]byte, offset int, guess Guess) []float64 {
+	series := make([]float64, len(data))
+	for n, d := range data {
+		v := binary.LittleEndian.Uint32(d[offset : offset+4])
+		switch guess {
+		case GuessFloat:
+			if f := float64(math.Float32frombits(v)); isPlausible(f) {
+				series[n] = f
+			}
+		case GuessInt16:
+			series[n] = float64(int16(v))
+		default:
+			series[n] = float64(int32(v))
+		}
+	}
+	return series
+}
+
+type fieldSeries struct {
+	field  packet.Field
+	values []float64
+}
+
+// knownSeries reads every known field of the datagrams
+func knownSeries(layout *packet.Layout, data [][]byte) []fieldSeries {
+	var known []fieldSeries
+	for _, f := range layout.Fields {
+		if ignored[f.Name] || f.Name == packet.UnknownField {
+			continue
+		}
+		values := make([]float64, len(data))
+		for n, d := range data {
+			values[n] = f.Float(d)
+		}
+		known = append(known, fieldSeries{f, values})
+	}
+	return known
+}
+
+// correlate returns the known fields most correlated with series, leaving out the fields
+// of the word itself
+func correlate(series []float64, known []fieldSeries, offset int, max int) []Correlation {
+	var correlations []Correlation
+	for _, k := range known {
+		if overlaps(k.field, offset) {
+			continue
+		}
+		r, ok := pearson(series, k.values)
+		if !ok {
+			continue
+		}
+		correlations = append(correlations, Correlation{Field: k.field.Name, R: r})
+	}
+
+	sort.SliceStable(correlations, func(i, j int) bool {
+		return math.Abs(correlations[i].R) > math.Abs(correlations[j].R)
+	})
+	if len(correlations) > max {
+		correlations = correlations[:max]
+	}
+	return correlations
+}
+
+// pearson returns the correlation coefficient of two series, false when either is constant
+// or not finite
+func pearson(x, y []float64) (float64, bool) {
+	n := float64(len(x))
+	var sx, sy float64
+	for i := range x {
+		sx += x[i]
+		sy += y[i]
+	}
+	mx, my := sx/n, sy/n
+
+	var cov, vx, vy float64
+	for i := range x {
+		dx, dy := x[i]-mx, y[i]-my
+		cov += dx * dy
+		vx += dx * dx
+		vy += dy * dy
+	}
+	if vx == 0 || vy == 0 {
+		return 0, false
+	}
+	r := cov / math.Sqrt(vx*vy)
+	if math.IsNaN(r) || math.IsInf(r, 0) {
+		return 0, false
+	}
+	return r, true
+}
@@ -0,0 +1,162 @@
+package explore
+
+import (
+	"math"
+	"testing"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// testDatagrams returns n decrypted datagrams where the magic is constant, RPM a float that
+// follows CarSpeed, PackageID a counter and Flags toggles a few bits
+func testDatagrams(n int) [][]byte {
+	flags := []uint16{packet.SimFlagCarOnTrack, packet.SimFlagCarOnTrack | packet.SimFlagPaused, packet.SimFlagCarOnTrack | packet.SimFlagLoadingOrProcessing}
+	datagrams := make([][]byte, n)
+	for i := range datagrams {
+		tf := packet.TelemetryFrame{
+			PackageID: int32(1000 + i),
+			RPM:       float32(3000 + 50*i),
+			CarSpeed:  float32(100 + i),
+			Flags:     flags[i%len(flags)],
+		}
+		// Encoded over the flags
+		tf.InRace = tf.Flags&packet.SimFlagCarOnTrack != 0
+		tf.IsPaused = tf.Flags&packet.SimFlagPaused != 0
+		datagrams[i] = packet.LayoutA.Encode(&tf)
+	}
+	return datagrams
+}
+
+func TestAnalyze(t *testing.T) {
+	datagrams := testDatagrams(60)
+	// Too short for any format, skipped
+	datagrams = append(datagrams, make([]byte, 16))
+
+	words := Analyze(datagrams, 3)
+	if len(words) != packet.LayoutA.Size/4 {
+		t.Fatalf("Analyze() returned %d words, want %d", len(words), packet.LayoutA.Size/4)
+	}
+	byOffset := map[int]Word{}
+	for i, w := range words {
+		if w.Offset != 4*i {
+			t.Fatalf("word %d at offset %#x, want %#x", i, w.Offset, 4*i)
+		}
+		byOffset[w.Offset] = w
+	}
+
+	tests := []struct {
+		name   string
+		offset int
+		field  string
+		guess  Guess
+		// A field expected among the correlations, with |R| close to 1
+		correlated string
+	}{
+		{name: "constant", offset: 0x00, field: "Magic", guess: GuessConstant},
+		{name: "float", offset: 0x3C, field: "RPM", guess: GuessFloat, correlated: "CarSpeed"},
+		{name: "counter", offset: 0x70, field: "PackageID", guess: GuessCounter, correlated: "RPM"},
+		{name: "flags", offset: 0x8C, field: "EstimatedTopSpeed, Flags, InRace, IsPaused", guess: GuessFlags},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := byOffset[tt.offset]
+			if w.Field != tt.field || w.Unmapped != 0 {
+				t.Errorf("Field = %q with %d bytes unmapped, want %q and none", w.Field, w.Unmapped, tt.field)
+			}
+			if w.Guess != tt.guess {
+				t.Errorf("Guess = %s, want %s", w.Guess, tt.guess)
+			}
+			if tt.guess == GuessConstant && (len(w.Correlations) != 0 || w.Changes != 0 || w.Entropy != 0) {
+				t.Errorf("constant word has correlations %v, changes %v and entropy %v", w.Correlations, w.Changes, w.Entropy)
+			}
+			if len(w.Correlations) > 3 {
+				t.Errorf("%d correlations, want at most 3", len(w.Correlations))
+			}
+			if tt.correlated == "" {
+				return
+			}
+			for _, c := range w.Correlations {
+				if c.Field == tt.correlated {
+					if math.Abs(c.R) < 0.999 {
+						t.Errorf("R with %s = %v, want 1", c.Field, c.R)
+					}
+					return
+				}
+			}
+			t.Errorf("Correlations = %v, want %s among them", w.Correlations, tt.correlated)
+		})
+	}
+
+	rpm := byOffset[0x3C]
+	if rpm.FloatMin != 3000 || rpm.FloatMax != 3000+50*59 || rpm.Plausible != 1 || rpm.Changes != 1 {
+		t.Errorf("RPM range %v to %v, plausible %v, changes %v, want 3000 to 5950, 1 and 1", rpm.FloatMin, rpm.FloatMax, rpm.Plausible, rpm.Changes)
+	}
+	counter := byOffset[0x70]
+	if counter.IntMin != 1000 || counter.IntMax != 1059 || counter.First != 1000 {
+		t.Errorf("PackageID range %d to %d from %d, want 1000 to 1059 from 1000", counter.IntMin, counter.IntMax, counter.First)
+	}
+	flags := byOffset[0x8C]
+	want := uint32(packet.SimFlagCarOnTrack|packet.SimFlagPaused|packet.SimFlagLoadingOrProcessing) << 16
+	if flags.SetBits != want || flags.ToggledBits != want&^(uint32(packet.SimFlagCarOnTrack)<<16) {
+		t.Errorf("SetBits %#x and ToggledBits %#x, want %#x and the car on track bit left out of the toggled ones", flags.SetBits, flags.ToggledBits, want)
+	}
+	// Three values equally likely
+	if math.Abs(flags.Entropy-math.Log2(3)) > 1e-9 {
+		t.Errorf("Entropy = %v, want log2(3)", flags.Entropy)
+	}
+}
+
+func TestPearson(t *testing.T) {
+	tests := []struct {
+		name string
+		x, y []float64
+		r    float64
+		ok   bool
+	}{
+		{name: "proportional", x: []float64{1, 2, 3, 4}, y: []float64{10, 20, 30, 40}, r: 1, ok: true},
+		{name: "opposite", x: []float64{1, 2, 3, 4}, y: []float64{8, 6, 4, 2}, r: -1, ok: true},
+		{name: "uncorrelated", x: []float64{1, 2, 3, 4}, y: []float64{1, -1, -1, 1}, r: 0, ok: true},
+		{name: "partly", x: []float64{1, 2, 3}, y: []float64{1, 3, 2}, r: 0.5, ok: true},
+		{name: "constant", x: []float64{1, 2, 3}, y: []float64{5, 5, 5}},
+		{name: "not finite", x: []float64{1, 2, 3}, y: []float64{1, math.Inf(1), 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ok := pearson(tt.x, tt.y)
+			if ok != tt.ok || math.Abs(r-tt.r) > 1e-9 {
+				t.Errorf("pearson() = %v, %v, want %v, %v", r, ok, tt.r, tt.ok)
+			}
+		})
+	}
+}
+
+func TestGuess(t *testing.T) {
+	word := func(values ...uint32) [][]byte {
+		data := make([][]byte, len(values))
+		for i, v := range values {
+			data[i] = []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+		}
+		return data
+	}
+	tests := []struct {
+		name string
+		data [][]byte
+		want Guess
+	}{
+		{name: "no data", want: GuessConstant},
+		{name: "constant", data: word(7, 7, 7), want: GuessConstant},
+		{name: "floats", data: word(math.Float32bits(1.5), math.Float32bits(-2), math.Float32bits(0)), want: GuessFloat},
+		{name: "counter", data: word(5, 6, 7, 8, 9), want: GuessCounter},
+		// Not increasing often enough for a counter
+		{name: "flags", data: word(1, 3, 1, 3, 1, 3), want: GuessFlags},
+		{name: "int16 pair", data: word(0x00050003, 0xFFF00001, 0x0120FF00, 0x7FFF0010, 0x8000ABCD, 0x0001F00F, 0x4000FFFF), want: GuessInt16},
+		{name: "int32", data: word(0x00001234, 0x0000ABCD, 0xFFFF8000, 0x0000F0F0, 0x00000F0F, 0x0000AAAA, 0x00005555), want: GuessInt32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := analyzeWord(tt.data, 0).Guess; got != tt.want {
+				t.Errorf("analyzeWord().Guess = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+// Package export writes decoded sessions to files that analysis tools can read.
+package export
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// Kind is the storage type of a column
+type Kind int
+
+const (
+	KindTimestamp Kind = iota
+	KindBool
+	KindInt32
+	KindUint32
+	KindInt64
+	KindFloat32
+)
+
+// Column is a TelemetryFrame field, or the receive time of the sample
+type Column struct {
+	Name string
+	Unit string
+	Kind Kind
+	// Size in bits of the Go type, for the integer kinds
+	Bits int
+
+	field int
+}
+
+// TimeColumn is the name of the column holding the receive time of each sample
+const TimeColumn = "time"
+
+// Columns are the exported columns: the receive time followed by every TelemetryFrame field,
+// in declaration order
+var Columns = frameColumns()
+
+func frameColumns() []Column {
+	columns := []Column{{Name: TimeColumn, Kind: KindTimestamp, field: -1}}
+
+	t := reflect.TypeOf(packet.TelemetryFrame{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		c := Column{Name: f.Name, Unit: packet.FieldUnits[f.Name], field: i}
+		switch f.Type.Kind() {
+		case reflect.Bool:
+			c.Kind = KindBool
+		case reflect.Int8, reflect.Int16, reflect.Int32:
+			c.Kind = KindInt32
+			c.Bits = f.Type.Bits()
+		case reflect.Uint8, reflect.Uint16:
+			c.Kind = KindUint32
+			c.Bits = f.Type.Bits()
+		case reflect.Int64:
+			c.Kind = KindInt64
+		case reflect.Float32:
+			c.Kind = KindFloat32
+		default:
+			panic(fmt.Sprintf("unsupported TelemetryFrame field %s of type %s", f.Name, f.Type))
+		}
+		columns = append(columns, c)
+	}
+
+	return columns
+}
+
+func (c Column) value(s *gt7.Sample) reflect.Value {
+	return reflect.ValueOf(&s.Frame).Elem().Field(c.field)
+}
+
+// Units returns the unit of every column that has one
+func Units() map[string]string {
+	units := map[string]string{}
+	for _, c := range Columns {
+		if c.Unit != "" {
+			units[c.Name] = c.Unit
+		}
+	}
+	return units
+}
+
+// Format is an export file format
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatParquet Format = "parquet"
+	// MoTeC i2 .ld and .ldx files, in a zip archive when both go to a single writer
+	FormatMoTeC Format = "motec"
+)
+
+// ParseFormat validates a format name, empty meaning CSV
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case "", FormatCSV:
+		return FormatCSV, nil
+	case FormatParquet:
+		return FormatParquet, nil
+	case FormatMoTeC:
+		return FormatMoTeC, nil
+	}
+	return "", fmt.Errorf("unknown export format %q", name)
+}
+
+// ContentType is the MIME type of files in the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatParquet:
+		return "application/vnd.apache.parquet"
+	case FormatMoTeC:
+		return "application/zip"
+	}
+	return "text/csv"
+}
+
+// Extension is the file name extension of the format
+func (f Format) Extension() string {
+	if f == FormatMoTeC {
+		return ".zip"
+	}
+	return "." + string(f)
+}
+
+// FilterLaps keeps the samples recorded during laps from to to, inclusive. A to lower than 1
+// keeps every lap from from onwards.
+func FilterLaps(samples []gt7.Sample, from, to int) []gt7.Sample {
+	var filtered []gt7.Sample
+	for _, s := range samples {
+		lap := int(s.Frame.CurrentLap)
+		if lap < from || (to > 0 && lap > to) {
+			continue
+		}
+		filtered = append(filtered, s)
+	}
+	return filtered
+}
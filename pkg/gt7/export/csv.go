@@ -0,0 +1,52 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+)
+
+// WriteCSV writes one row per sample, with a header of column names. When units is set,
+// the unit follows the name in brackets, e.g. "CarSpeed [km/h]".
+func WriteCSV(w io.Writer, samples []gt7.Sample, units bool) error {
+	cw := csv.NewWriter(w)
+
+	header := make([]string, len(Columns))
+	for i, c := range Columns {
+		header[i] = c.Name
+		if units && c.Unit != "" {
+			header[i] += " [" + c.Unit + "]"
+		}
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	row := make([]string, len(Columns))
+	for i := range samples {
+		s := &samples[i]
+		for j, c := range Columns {
+			switch c.Kind {
+			case KindTimestamp:
+				row[j] = s.Received.UTC().Format(time.RFC3339Nano)
+			case KindBool:
+				row[j] = strconv.FormatBool(c.value(s).Bool())
+			case KindInt32, KindInt64:
+				row[j] = strconv.FormatInt(c.value(s).Int(), 10)
+			case KindUint32:
+				row[j] = strconv.FormatUint(c.value(s).Uint(), 10)
+			case KindFloat32:
+				row[j] = strconv.FormatFloat(c.value(s).Float(), 'g', -1, 32)
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,104 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+)
+
+var testStart = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// testSamples returns n samples received at 60 Hz, with a few fields of every kind set
+func testSamples(n int) []gt7.Sample {
+	samples := make([]gt7.Sample, n)
+	for i := range samples {
+		s := &samples[i]
+		s.Driver = "driver"
+		s.Received = testStart.Add(time.Duration(i) * time.Second / 60)
+		s.Frame.PackageID = int32(1000 + i)
+		s.Frame.CurrentLap = 2
+		s.Frame.CurrentGear = uint8(i%6 + 1)
+		s.Frame.RPMRevLimiter = 8500
+		s.Frame.CarSpeed = 100 + float32(i)/4
+		s.Frame.InRace = i%2 == 0
+	}
+	return samples
+}
+
+// columnIndex returns the index of a column, failing the test when there's none
+func columnIndex(t *testing.T, name string) int {
+	t.Helper()
+	for i, c := range Columns {
+		if c.Name == name {
+			return i
+		}
+	}
+	t.Fatalf("no %s column", name)
+	return -1
+}
+
+func TestWriteCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		units bool
+		// Expected header of some columns
+		header map[string]string
+	}{
+		{
+			name:   "names only",
+			header: map[string]string{TimeColumn: "time", "CarSpeed": "CarSpeed", "PackageID": "PackageID"},
+		},
+		{
+			name:   "with units",
+			units:  true,
+			header: map[string]string{TimeColumn: "time", "CarSpeed": "CarSpeed [km/h]", "PackageID": "PackageID"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteCSV(&buf, testSamples(2), tt.units); err != nil {
+				t.Fatalf("WriteCSV() failed: %v", err)
+			}
+			records, err := csv.NewReader(&buf).ReadAll()
+			if err != nil {
+				t.Fatalf("written CSV doesn't parse: %v", err)
+			}
+			if len(records) != 3 {
+				t.Fatalf("CSV has %d records, want a header and 2 rows", len(records))
+			}
+			if records[0][0] != TimeColumn {
+				t.Errorf("first column = %q, want %q", records[0][0], TimeColumn)
+			}
+			for name, want := range tt.header {
+				if got := records[0][columnIndex(t, name)]; got != want {
+					t.Errorf("%s header = %q, want %q", name, got, want)
+				}
+			}
+
+			want := map[string]string{
+				TimeColumn:      "2024-01-01T12:00:00.016666666Z",
+				"PackageID":     "1001",
+				"CurrentLap":    "2",
+				"CurrentGear":   "2",
+				"RPMRevLimiter": "8500",
+				"CarSpeed":      "100.25",
+				"InRace":        "false",
+				"Throttle":      "0",
+			}
+			for _, row := range records[1:] {
+				if len(row) != len(Columns) {
+					t.Fatalf("row has %d columns, want %d", len(row), len(Columns))
+				}
+			}
+			for name, want := range want {
+				if got := records[2][columnIndex(t, name)]; got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
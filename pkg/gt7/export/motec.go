@@ -0,0 +1,411 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// MoTeC channel names for the fields that i2 knows about, so that its built-in maths and
+// worksheets work. Other fields keep their TelemetryFrame name.
+var motecChannelNames = map[string]string{
+	"CarSpeed":     "Ground Speed",
+	"RPM":          "Engine RPM",
+	"Throttle":     "Throttle Pos",
+	"Brake":        "Brake Pos",
+	"CurrentGear":  "Gear",
+	"CurrentLap":   "Lap Number",
+	"CurrentFuel":  "Fuel Level",
+	"Boost":        "Boost Pressure",
+	"OilTemp":      "Engine Oil Temp",
+	"OilPressure":  "Engine Oil Pressure",
+	"WaterTemp":    "Engine Water Temp",
+	"GForceX":      "G Force Lat",
+	"GForceY":      "G Force Vert",
+	"GForceZ":      "G Force Long",
+	"TyreTempFL":   "Tyre Temp FL Centre",
+	"TyreTempFR":   "Tyre Temp FR Centre",
+	"TyreTempRL":   "Tyre Temp RL Centre",
+	"TyreTempRR":   "Tyre Temp RR Centre",
+	"SuspensionFL": "Susp Pos FL",
+	"SuspensionFR": "Susp Pos FR",
+	"SuspensionRL": "Susp Pos RL",
+	"SuspensionRR": "Susp Pos RR",
+	"RideHeight":   "Ride Height",
+}
+
+// MoTeC only takes ASCII units
+var motecUnits = map[string]string{
+	"°C":   "C",
+	"°":    "deg",
+	"m/s²": "m/s/s",
+}
+
+// maxGapFill is the longest run of lost packets that is filled by repeating the previous
+// sample, so that the fixed MoTeC sample rate stays aligned with the session time
+const maxGapFill = 10 * packet.PacketRate
+
+// Fixed values written by MoTeC loggers, as documented by the ldparser project
+const (
+	ldMarker       = 0x40
+	ldDeviceSerial = 0x1f44
+	ldDeviceVer    = 420
+	ldProLogging   = 0xc81a4
+	ldChannelID    = 0x2ee1
+	ldFloatType    = 0x07
+)
+
+type ldHeader struct {
+	Marker        uint32
+	_             [4]byte
+	ChannelsPtr   uint32
+	DataPtr       uint32
+	_             [20]byte
+	EventPtr      uint32
+	_             [24]byte
+	Unknown1      [3]uint16
+	DeviceSerial  uint32
+	DeviceType    [8]byte
+	DeviceVersion uint16
+	Unknown2      uint16
+	NumChannels   uint32
+	_             [4]byte
+	Date          [16]byte
+	_             [16]byte
+	Time          [16]byte
+	_             [16]byte
+	Driver        [64]byte
+	Vehicle       [64]byte
+	_             [64]byte
+	Venue         [64]byte
+	_             [64]byte
+	_             [1024]byte
+	ProLogging    uint32
+	_             [66]byte
+	ShortComment  [64]byte
+	_             [126]byte
+}
+
+type ldEvent struct {
+	Name     [64]byte
+	Session  [64]byte
+	Comment  [1024]byte
+	VenuePtr uint16
+}
+
+type ldVenue struct {
+	Name       [64]byte
+	_          [1034]byte
+	VehiclePtr uint16
+}
+
+type ldVehicle struct {
+	ID      [64]byte
+	_       [128]byte
+	Weight  uint32
+	Type    [32]byte
+	Comment [32]byte
+}
+
+type ldChannel struct {
+	PrevPtr   uint32
+	NextPtr   uint32
+	DataPtr   uint32
+	NumValues uint32
+	ID        uint16
+	TypeClass uint16
+	TypeSize  uint16
+	Frequency uint16
+	Shift     int16
+	Mul       int16
+	Scale     int16
+	Decimals  int16
+	Name      [32]byte
+	ShortName [8]byte
+	Unit      [12]byte
+	_         [40]byte
+}
+
+func setString(dst []byte, s string) {
+	copy(dst, s)
+}
+
+// motecSamples resamples the session at the GT7 packet rate by repeating the previous sample
+// over short gaps. Longer gaps, e.g. menus, are skipped.
+func motecSamples(samples []gt7.Sample) []gt7.Sample {
+	resampled := make([]gt7.Sample, 0, len(samples))
+	for i, s := range samples {
+		if i > 0 && s.Frame.PacketsLost > 0 && s.Frame.PacketsLost <= maxGapFill {
+			for j := int32(0); j < s.Frame.PacketsLost; j++ {
+				resampled = append(resampled, samples[i-1])
+			}
+		}
+		resampled = append(resampled, s)
+	}
+	return resampled
+}
+
+// MoTeCLap is a lap marker of a MoTeC export
+type MoTeCLap struct {
+	Lap int16
+	// Offset of the end of the lap from the start of the log
+	End time.Duration
+	// Lap time reported by GT7
+	Time time.Duration
+}
+
+// motecLaps detects the completed laps with gt7.EventDetector, on the resampled timeline
+func motecLaps(samples []gt7.Sample) []MoTeCLap {
+	var laps []MoTeCLap
+	detector := gt7.NewEventDetector()
+	for i, s := range samples {
+		for _, e := range detector.Detect(s) {
+			if e.Type != gt7.EventLapComplete {
+				continue
+			}
+			laps = append(laps, MoTeCLap{
+				Lap:  e.Lap,
+				End:  time.Duration(i) * time.Second / packet.PacketRate,
+				Time: time.Duration(e.Value) * time.Millisecond,
+			})
+		}
+	}
+	return laps
+}
+
+// WriteMoTeC writes the session as a MoTeC i2 log (.ld) sampled at 60 Hz, and its lap
+// markers as the companion .ldx file
+func WriteMoTeC(ld io.Writer, ldx io.Writer, samples []gt7.Sample) error {
+	samples = motecSamples(samples)
+
+	var columns []Column
+	for _, c := range Columns {
+		if c.Kind != KindTimestamp {
+			columns = append(columns, c)
+		}
+	}
+
+	header := ldHeader{
+		Marker:        ldMarker,
+		Unknown1:      [3]uint16{1, 0x4240, 0xf},
+		DeviceSerial:  ldDeviceSerial,
+		DeviceVersion: ldDeviceVer,
+		Unknown2:      0xadb0,
+		NumChannels:   uint32(len(columns)),
+		ProLogging:    ldProLogging,
+	}
+	setString(header.DeviceType[:], "ADL")
+	setString(header.ShortComment[:], "Gran Turismo 7")
+
+	event := ldEvent{}
+	venue := ldVenue{}
+	vehicle := ldVehicle{}
+	setString(vehicle.Type[:], "Car")
+	if len(samples) > 0 {
+		first := samples[0]
+		setString(header.Date[:], first.Received.Format("02/01/2006"))
+		setString(header.Time[:], first.Received.Format("15:04:05"))
+		setString(header.Driver[:], first.Driver)
+		car := fmt.Sprintf("%d", first.Frame.CarID)
+		setString(header.Vehicle[:], car)
+		setString(vehicle.ID[:], car)
+		setString(event.Name[:], "GT7 "+first.Received.Format("2006-01-02 15:04"))
+	}
+
+	eventPtr := uint32(binary.Size(header))
+	venuePtr := eventPtr + uint32(binary.Size(event))
+	vehiclePtr := venuePtr + uint32(binary.Size(venue))
+	channelsPtr := vehiclePtr + uint32(binary.Size(vehicle))
+	channelSize := uint32(binary.Size(ldChannel{}))
+	dataPtr := channelsPtr + channelSize*uint32(len(columns))
+
+	header.EventPtr = eventPtr
+	header.ChannelsPtr = channelsPtr
+	header.DataPtr = dataPtr
+	event.VenuePtr = uint16(venuePtr)
+	venue.VehiclePtr = uint16(vehiclePtr)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header)
+	binary.Write(&buf, binary.LittleEndian, event)
+	binary.Write(&buf, binary.LittleEndian, venue)
+	binary.Write(&buf, binary.LittleEndian, vehicle)
+
+	channelDataSize := uint32(4 * len(samples))
+	for i, c := range columns {
+		channel := ldChannel{
+			DataPtr:   dataPtr + uint32(i)*channelDataSize,
+			NumValues: uint32(len(samples)),
+			ID:        ldChannelID + uint16(i),
+			TypeClass: ldFloatType,
+			TypeSize:  4,
+			Frequency: packet.PacketRate,
+			Mul:       1,
+			Scale:     1,
+		}
+		if i > 0 {
+			channel.PrevPtr = channelsPtr + uint32(i-1)*channelSize
+		}
+		if i < len(columns)-1 {
+			channel.NextPtr = channelsPtr + uint32(i+1)*channelSize
+		}
+
+		name := c.Name
+		if motecName, ok := motecChannelNames[c.Name]; ok {
+			name = motecName
+		}
+		unit := c.Unit
+		if motecUnit, ok := motecUnits[unit]; ok {
+			unit = motecUnit
+		}
+		setString(channel.Name[:], name)
+		setString(channel.ShortName[:], strings.ReplaceAll(name, " ", ""))
+		setString(channel.Unit[:], unit)
+		binary.Write(&buf, binary.LittleEndian, channel)
+	}
+
+	values := make([]byte, channelDataSize)
+	for _, c := range columns {
+		for j := range samples {
+			var v float64
+			switch c.Kind {
+			case KindBool:
+				if c.value(&samples[j]).Bool() {
+					v = 1
+				}
+			case KindInt32, KindInt64:
+				v = float64(c.value(&samples[j]).Int())
+			case KindUint32:
+				v = float64(c.value(&samples[j]).Uint())
+			case KindFloat32:
+				v = c.value(&samples[j]).Float()
+			}
+			binary.LittleEndian.PutUint32(values[4*j:], math.Float32bits(float32(v)))
+		}
+		buf.Write(values)
+	}
+
+	if _, err := ld.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	return writeLDX(ldx, motecLaps(samples))
+}
+
+type ldxFile struct {
+	XMLName       xml.Name  `xml:"LDXFile"`
+	Locale        string    `xml:"Locale,attr"`
+	DefaultLocale string    `xml:"DefaultLocale,attr"`
+	Version       string    `xml:"Version,attr"`
+	Layers        ldxLayers `xml:"Layers"`
+}
+
+type ldxLayers struct {
+	Layer   ldxLayer    `xml:"Layer"`
+	Details []ldxDetail `xml:"Details>String"`
+}
+
+type ldxLayer struct {
+	MarkerGroup ldxMarkerGroup `xml:"MarkerBlock>MarkerGroup"`
+	RangeBlock  struct{}       `xml:"RangeBlock"`
+}
+
+type ldxMarkerGroup struct {
+	Name    string      `xml:"Name,attr"`
+	Index   int         `xml:"Index,attr"`
+	Markers []ldxMarker `xml:"Marker"`
+}
+
+type ldxMarker struct {
+	Version   int    `xml:"Version,attr"`
+	ClassName string `xml:"ClassName,attr"`
+	Name      string `xml:"Name,attr"`
+	Flags     int    `xml:"Flags,attr"`
+	// Microseconds from the start of the log
+	Time string `xml:"Time,attr"`
+}
+
+type ldxDetail struct {
+	ID    string `xml:"Id,attr"`
+	Value string `xml:"Value,attr"`
+}
+
+// formatLapTime formats a lap time the way i2 displays it, e.g. 1:23.456
+func formatLapTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%d:%02d.%03d", ms/60000, ms/1000%60, ms%1000)
+}
+
+func writeLDX(w io.Writer, laps []MoTeCLap) error {
+	file := ldxFile{
+		Locale:        "English_United Kingdom.1252",
+		DefaultLocale: "C",
+		Version:       "1.6",
+	}
+	markers := &file.Layers.Layer.MarkerGroup
+	markers.Name = "Beacons"
+	markers.Index = 3
+
+	var fastest MoTeCLap
+	for i, lap := range laps {
+		markers.Markers = append(markers.Markers, ldxMarker{
+			Version:   100,
+			ClassName: "BCN",
+			Name:      fmt.Sprintf("Manual.%d", i+1),
+			Flags:     77,
+			Time:      fmt.Sprintf("%d.000", lap.End.Microseconds()),
+		})
+		if lap.Time > 0 && (fastest.Time == 0 || lap.Time < fastest.Time) {
+			fastest = lap
+		}
+	}
+
+	file.Layers.Details = append(file.Layers.Details, ldxDetail{ID: "Total Laps", Value: fmt.Sprintf("%d", len(laps))})
+	if fastest.Time > 0 {
+		file.Layers.Details = append(file.Layers.Details,
+			ldxDetail{ID: "Fastest Time", Value: formatLapTime(fastest.Time)},
+			ldxDetail{ID: "Fastest Lap", Value: fmt.Sprintf("%d", fastest.Lap)},
+		)
+	}
+
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", " ")
+	if err := enc.Encode(file); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteMoTeCArchive writes the .ld and .ldx files of a session, named after it, in a zip archive
+func WriteMoTeCArchive(w io.Writer, name string, samples []gt7.Sample) error {
+	var ld, ldx bytes.Buffer
+	if err := WriteMoTeC(&ld, &ldx, samples); err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{{name + ".ld", ld.Bytes()}, {name + ".ldx", ldx.Bytes()}} {
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(file.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,154 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"math"
+	"testing"
+)
+
+// cString returns a NUL padded string field
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func TestWriteMoTeC(t *testing.T) {
+	samples := testSamples(10)
+	samples[0].Frame.CarID = 3359
+	// Two packets lost before the fifth sample, filled by repeating the fourth one
+	samples[4].Frame.PacketsLost = 2
+	// Lap 2 completed in 1:23.456 on the seventh sample
+	for i := 6; i < len(samples); i++ {
+		samples[i].Frame.CurrentLap = 3
+		samples[i].Frame.LastLap = 83456
+	}
+	const numValues = 12
+
+	var ld, ldx bytes.Buffer
+	if err := WriteMoTeC(&ld, &ldx, samples); err != nil {
+		t.Fatalf("WriteMoTeC() failed: %v", err)
+	}
+	b := ld.Bytes()
+
+	var header ldHeader
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &header); err != nil {
+		t.Fatalf("header doesn't decode: %v", err)
+	}
+	// Offsets documented by ldparser
+	offsets := []struct {
+		name      string
+		got, want uint32
+	}{
+		{"header size", uint32(binary.Size(header)), 0x6E2},
+		{"event", header.EventPtr, 0x6E2},
+		{"channels", header.ChannelsPtr, 0x6E2 + 1154 + 1100 + 260},
+	}
+	for _, o := range offsets {
+		if o.got != o.want {
+			t.Errorf("%s = %#x, want %#x", o.name, o.got, o.want)
+		}
+	}
+	if header.Marker != ldMarker || header.ProLogging != ldProLogging || cString(header.DeviceType[:]) != "ADL" {
+		t.Errorf("header marker, pro logging, device = %#x, %#x, %q", header.Marker, header.ProLogging, cString(header.DeviceType[:]))
+	}
+	if cString(header.Date[:]) != "01/01/2024" || cString(header.Time[:]) != "12:00:00" || cString(header.Driver[:]) != "driver" || cString(header.Vehicle[:]) != "3359" {
+		t.Errorf("header date, time, driver, vehicle = %q, %q, %q, %q", cString(header.Date[:]), cString(header.Time[:]), cString(header.Driver[:]), cString(header.Vehicle[:]))
+	}
+
+	var event ldEvent
+	binary.Read(bytes.NewReader(b[header.EventPtr:]), binary.LittleEndian, &event)
+	var venue ldVenue
+	binary.Read(bytes.NewReader(b[event.VenuePtr:]), binary.LittleEndian, &venue)
+	var vehicle ldVehicle
+	binary.Read(bytes.NewReader(b[venue.VehiclePtr:]), binary.LittleEndian, &vehicle)
+	if cString(event.Name[:]) != "GT7 2024-01-01 12:00" || cString(vehicle.ID[:]) != "3359" {
+		t.Errorf("event %q, vehicle %q, want GT7 2024-01-01 12:00 and 3359", cString(event.Name[:]), cString(vehicle.ID[:]))
+	}
+	if end := uint32(venue.VehiclePtr) + uint32(binary.Size(vehicle)); end != header.ChannelsPtr {
+		t.Errorf("vehicle ends at %#x, want the channels at %#x", end, header.ChannelsPtr)
+	}
+
+	// Follow the channel list, the sample data follows the last channel
+	channels := map[string]ldChannel{}
+	var previous uint32
+	next := header.DataPtr
+	n := uint32(0)
+	for ptr := header.ChannelsPtr; ptr != 0; n++ {
+		if n > header.NumChannels {
+			t.Fatalf("channel list longer than %d channels", header.NumChannels)
+		}
+		var c ldChannel
+		if err := binary.Read(bytes.NewReader(b[ptr:]), binary.LittleEndian, &c); err != nil {
+			t.Fatalf("channel at %#x doesn't decode: %v", ptr, err)
+		}
+		if c.PrevPtr != previous {
+			t.Errorf("channel %q previous pointer = %#x, want %#x", cString(c.Name[:]), c.PrevPtr, previous)
+		}
+		if c.DataPtr != next || c.NumValues != numValues || c.TypeSize != 4 || c.Frequency != 60 {
+			t.Errorf("channel %q data at %#x with %d values of %d bytes at %d Hz, want %#x, %d, 4 and 60",
+				cString(c.Name[:]), c.DataPtr, c.NumValues, c.TypeSize, c.Frequency, next, numValues)
+		}
+		channels[cString(c.Name[:])] = c
+		previous = ptr
+		next += 4 * c.NumValues
+		ptr = c.NextPtr
+	}
+	if n != header.NumChannels || n != uint32(len(Columns)-1) {
+		t.Errorf("%d channels in the list, header has %d, want a channel per column but the time", n, header.NumChannels)
+	}
+	if previous != header.DataPtr-uint32(binary.Size(ldChannel{})) || next != uint32(len(b)) {
+		t.Errorf("last channel at %#x and data end at %#x, want %#x and %#x", previous, next, header.DataPtr-uint32(binary.Size(ldChannel{})), len(b))
+	}
+
+	value := func(name string, i int) float32 {
+		c, ok := channels[name]
+		if !ok {
+			t.Fatalf("no %q channel", name)
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(b[c.DataPtr+4*uint32(i):]))
+	}
+	values := []struct {
+		channel string
+		i       int
+		want    float32
+	}{
+		{"Ground Speed", 0, 100},
+		// The fourth sample repeated over the gap
+		{"Ground Speed", 4, 100.75},
+		{"Ground Speed", 5, 100.75},
+		{"Ground Speed", 6, 101},
+		{"PackageID", 11, 1009},
+		{"Lap Number", 7, 2},
+		{"Lap Number", 8, 3},
+		{"InRace", 10, 1},
+		{"InRace", 11, 0},
+	}
+	for _, v := range values {
+		if got := value(v.channel, v.i); got != v.want {
+			t.Errorf("%s[%d] = %v, want %v", v.channel, v.i, got, v.want)
+		}
+	}
+	if c := channels["Engine Oil Temp"]; cString(c.Unit[:]) != "C" || cString(c.ShortName[:]) != "EngineOi" {
+		t.Errorf("oil temperature unit and short name = %q, %q, want C and EngineOi", cString(c.Unit[:]), cString(c.ShortName[:]))
+	}
+
+	var file ldxFile
+	if err := xml.Unmarshal(ldx.Bytes(), &file); err != nil {
+		t.Fatalf("ldx doesn't parse: %v", err)
+	}
+	markers := file.Layers.Layer.MarkerGroup.Markers
+	if len(markers) != 1 || markers[0].Time != "133333.000" {
+		t.Errorf("markers = %+v, want one at 133333 µs", markers)
+	}
+	details := map[string]string{}
+	for _, d := range file.Layers.Details {
+		details[d.ID] = d.Value
+	}
+	if details["Total Laps"] != "1" || details["Fastest Time"] != "1:23.456" || details["Fastest Lap"] != "2" {
+		t.Errorf("details = %v, want 1 lap, lap 2 fastest in 1:23.456", details)
+	}
+}
@@ -0,0 +1,212 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+)
+
+// Parquet enums, see parquet.thrift
+const (
+	parquetBoolean = 0
+	parquetInt32   = 1
+	parquetInt64   = 2
+	parquetFloat   = 4
+
+	convertedUint8          = 11
+	convertedUint16         = 12
+	convertedInt8           = 15
+	convertedInt16          = 16
+	convertedTimestampMicro = 10
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	repetitionRequired = 0
+	pageTypeData       = 0
+	codecUncompressed  = 0
+)
+
+const parquetMagic = "PAR1"
+
+// WriteParquet writes the samples as a Parquet file with a single row group and one
+// uncompressed page per column. Units are stored in the "gt7.units" key-value metadata as
+// a JSON object keyed by column name.
+func WriteParquet(w io.Writer, samples []gt7.Sample) error {
+	var buf bytes.Buffer
+	buf.WriteString(parquetMagic)
+
+	chunks := make([]parquetChunk, 0, len(Columns))
+	for _, c := range Columns {
+		values := encodeColumn(c, samples)
+
+		var header thriftWriter
+		header.fieldI32(1, pageTypeData)
+		header.fieldI32(2, int32(len(values)))
+		header.fieldI32(3, int32(len(values)))
+		header.fieldStruct(5)
+		header.fieldI32(1, int32(len(samples)))
+		header.fieldI32(2, encodingPlain)
+		header.fieldI32(3, encodingRLE)
+		header.fieldI32(4, encodingRLE)
+		header.endStruct()
+		header.endStruct()
+
+		offset := int64(buf.Len())
+		buf.Write(header.Bytes())
+		buf.Write(values)
+		chunks = append(chunks, parquetChunk{
+			column: c,
+			offset: offset,
+			size:   int64(buf.Len()) - offset,
+		})
+	}
+
+	units, err := json.Marshal(Units())
+	if err != nil {
+		return err
+	}
+
+	var meta thriftWriter
+	meta.fieldI32(1, 1)
+
+	meta.fieldList(2, thriftStruct, len(Columns)+1)
+	meta.beginStruct()
+	meta.fieldBinary(4, "schema")
+	meta.fieldI32(5, int32(len(Columns)))
+	meta.endStruct()
+	for _, c := range Columns {
+		physical, converted := parquetType(c)
+		meta.beginStruct()
+		meta.fieldI32(1, physical)
+		meta.fieldI32(3, repetitionRequired)
+		meta.fieldBinary(4, c.Name)
+		if converted >= 0 {
+			meta.fieldI32(6, converted)
+		}
+		meta.endStruct()
+	}
+
+	meta.fieldI64(3, int64(len(samples)))
+
+	var totalSize int64
+	for _, chunk := range chunks {
+		totalSize += chunk.size
+	}
+	meta.fieldList(4, thriftStruct, 1)
+	meta.beginStruct()
+	meta.fieldList(1, thriftStruct, len(chunks))
+	for _, chunk := range chunks {
+		physical, _ := parquetType(chunk.column)
+		meta.beginStruct()
+		meta.fieldI64(2, chunk.offset)
+		meta.fieldStruct(3)
+		meta.fieldI32(1, physical)
+		meta.fieldList(2, thriftI32, 1)
+		meta.i32(encodingPlain)
+		meta.fieldList(3, thriftBinary, 1)
+		meta.binary(chunk.column.Name)
+		meta.fieldI32(4, codecUncompressed)
+		meta.fieldI64(5, int64(len(samples)))
+		meta.fieldI64(6, chunk.size)
+		meta.fieldI64(7, chunk.size)
+		meta.fieldI64(9, chunk.offset)
+		meta.endStruct()
+		meta.endStruct()
+	}
+	meta.fieldI64(2, totalSize)
+	meta.fieldI64(3, int64(len(samples)))
+	meta.endStruct()
+
+	meta.fieldList(5, thriftStruct, 1)
+	meta.beginStruct()
+	meta.fieldBinary(1, "gt7.units")
+	meta.fieldBinary(2, string(units))
+	meta.endStruct()
+
+	meta.fieldBinary(6, "grafana-gt7")
+	meta.endStruct()
+
+	buf.Write(meta.Bytes())
+	binary.Write(&buf, binary.LittleEndian, uint32(len(meta.Bytes())))
+	buf.WriteString(parquetMagic)
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+type parquetChunk struct {
+	column Column
+	offset int64
+	size   int64
+}
+
+// parquetType returns the physical and converted types of a column, -1 meaning no converted type
+func parquetType(c Column) (int32, int32) {
+	switch c.Kind {
+	case KindTimestamp:
+		return parquetInt64, convertedTimestampMicro
+	case KindBool:
+		return parquetBoolean, -1
+	case KindInt32:
+		switch c.Bits {
+		case 8:
+			return parquetInt32, convertedInt8
+		case 16:
+			return parquetInt32, convertedInt16
+		}
+		return parquetInt32, -1
+	case KindUint32:
+		if c.Bits == 8 {
+			return parquetInt32, convertedUint8
+		}
+		return parquetInt32, convertedUint16
+	case KindInt64:
+		return parquetInt64, -1
+	}
+	return parquetFloat, -1
+}
+
+// encodeColumn returns the PLAIN encoded values of a column
+func encodeColumn(c Column, samples []gt7.Sample) []byte {
+	var b []byte
+	switch c.Kind {
+	case KindTimestamp:
+		b = make([]byte, 8*len(samples))
+		for i := range samples {
+			binary.LittleEndian.PutUint64(b[8*i:], uint64(samples[i].Received.UnixNano()/1000))
+		}
+	case KindBool:
+		b = make([]byte, (len(samples)+7)/8)
+		for i := range samples {
+			if c.value(&samples[i]).Bool() {
+				b[i/8] |= 1 << (i % 8)
+			}
+		}
+	case KindInt32:
+		b = make([]byte, 4*len(samples))
+		for i := range samples {
+			binary.LittleEndian.PutUint32(b[4*i:], uint32(c.value(&samples[i]).Int()))
+		}
+	case KindUint32:
+		b = make([]byte, 4*len(samples))
+		for i := range samples {
+			binary.LittleEndian.PutUint32(b[4*i:], uint32(c.value(&samples[i]).Uint()))
+		}
+	case KindInt64:
+		b = make([]byte, 8*len(samples))
+		for i := range samples {
+			binary.LittleEndian.PutUint64(b[8*i:], uint64(c.value(&samples[i]).Int()))
+		}
+	case KindFloat32:
+		b = make([]byte, 4*len(samples))
+		for i := range samples {
+			binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(float32(c.value(&samples[i]).Float())))
+		}
+	}
+	return b
+}
@@ -0,0 +1,265 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// thriftStructValue is a decoded Thrift struct, keyed by field id. Values are int64 for
+// integers, string for binaries, []interface{} for lists and thriftStructValue for structs.
+type thriftStructValue map[int16]interface{}
+
+// thriftReader decodes the Thrift compact protocol types written by thriftWriter
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.b) {
+		return 0, fmt.Errorf("unexpected end of data at %d", r.pos)
+	}
+	r.pos++
+	return r.b[r.pos-1], nil
+}
+
+func (r *thriftReader) varint() (uint64, error) {
+	var v uint64
+	for shift := uint(0); ; shift += 7 {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b&0x7F) << shift
+		if b < 0x80 {
+			return v, nil
+		}
+	}
+}
+
+func (r *thriftReader) value(typ byte) (interface{}, error) {
+	switch typ {
+	case thriftI32, thriftI64:
+		v, err := r.varint()
+		return int64(v>>1) ^ -int64(v&1), err
+	case thriftBinary:
+		n, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		if r.pos+int(n) > len(r.b) {
+			return nil, fmt.Errorf("binary of %d bytes past the end of data", n)
+		}
+		r.pos += int(n)
+		return string(r.b[r.pos-int(n) : r.pos]), nil
+	case thriftList:
+		header, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(header >> 4)
+		if size == 15 {
+			if size, err = r.varint(); err != nil {
+				return nil, err
+			}
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			if list[i], err = r.value(header & 0x0F); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case thriftStruct:
+		return r.structValue()
+	}
+	return nil, fmt.Errorf("unsupported thrift type %d at %d", typ, r.pos)
+}
+
+func (r *thriftReader) structValue() (thriftStructValue, error) {
+	s := thriftStructValue{}
+	var id int16
+	for {
+		header, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if header == 0 {
+			return s, nil
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v>>1) ^ -int16(v&1)
+		}
+		if s[id], err = r.value(header & 0x0F); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func TestThriftWriter(t *testing.T) {
+	var w thriftWriter
+	w.fieldI32(1, -3)
+	w.fieldBinary(2, "gt7")
+	// A delta over 15 needs the long field header
+	w.fieldI64(20, 1<<40)
+	w.fieldList(21, thriftI32, 20)
+	for i := int32(0); i < 20; i++ {
+		w.i32(i)
+	}
+	w.fieldStruct(22)
+	w.fieldI32(1, 7)
+	w.endStruct()
+	w.fieldI32(23, 8)
+	w.endStruct()
+
+	r := thriftReader{b: w.Bytes()}
+	s, err := r.structValue()
+	if err != nil {
+		t.Fatalf("structValue() failed: %v", err)
+	}
+	if r.pos != len(r.b) {
+		t.Errorf("read %d of %d bytes", r.pos, len(r.b))
+	}
+	if s[1] != int64(-3) || s[2] != "gt7" || s[20] != int64(1<<40) || s[23] != int64(8) {
+		t.Errorf("fields = %v, want 1: -3, 2: gt7, 20: 2^40, 23: 8", s)
+	}
+	if list := s[21].([]interface{}); len(list) != 20 || list[19] != int64(19) {
+		t.Errorf("list = %v, want 0 to 19", list)
+	}
+	if nested := s[22].(thriftStructValue); nested[1] != int64(7) {
+		t.Errorf("nested struct = %v, want 1: 7", nested)
+	}
+}
+
+func TestWriteParquet(t *testing.T) {
+	samples := testSamples(10)
+	var buf bytes.Buffer
+	if err := WriteParquet(&buf, samples); err != nil {
+		t.Fatalf("WriteParquet() failed: %v", err)
+	}
+	b := buf.Bytes()
+
+	if string(b[:4]) != parquetMagic || string(b[len(b)-4:]) != parquetMagic {
+		t.Fatalf("file doesn't start and end with %s", parquetMagic)
+	}
+	metaSize := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	metaStart := len(b) - 8 - metaSize
+	r := thriftReader{b: b[metaStart : len(b)-8]}
+	meta, err := r.structValue()
+	if err != nil {
+		t.Fatalf("footer doesn't decode: %v", err)
+	}
+	if r.pos != metaSize {
+		t.Errorf("footer decoded from %d of %d bytes", r.pos, metaSize)
+	}
+
+	if meta[1] != int64(1) || meta[3] != int64(len(samples)) || meta[6] != "grafana-gt7" {
+		t.Errorf("version, num_rows, created_by = %v, %v, %v, want 1, %d, grafana-gt7", meta[1], meta[3], meta[6], len(samples))
+	}
+
+	schema := meta[2].([]interface{})
+	if len(schema) != len(Columns)+1 {
+		t.Fatalf("schema has %d elements, want %d", len(schema), len(Columns)+1)
+	}
+	if root := schema[0].(thriftStructValue); root[4] != "schema" || root[5] != int64(len(Columns)) {
+		t.Errorf("schema root = %v, want %d children", root, len(Columns))
+	}
+	for i, c := range Columns {
+		e := schema[i+1].(thriftStructValue)
+		physical, converted := parquetType(c)
+		if e[4] != c.Name || e[1] != int64(physical) || e[3] != int64(repetitionRequired) {
+			t.Errorf("schema element %d = %v, want %s of type %d", i+1, e, c.Name, physical)
+		}
+		if got, ok := e[6]; (converted >= 0) != ok || (ok && got != int64(converted)) {
+			t.Errorf("%s converted type = %v, want %d", c.Name, got, converted)
+		}
+	}
+
+	rowGroups := meta[4].([]interface{})
+	if len(rowGroups) != 1 {
+		t.Fatalf("%d row groups, want 1", len(rowGroups))
+	}
+	rowGroup := rowGroups[0].(thriftStructValue)
+	chunks := rowGroup[1].([]interface{})
+	if len(chunks) != len(Columns) || rowGroup[3] != int64(len(samples)) {
+		t.Fatalf("row group has %d chunks and %v rows, want %d and %d", len(chunks), rowGroup[3], len(Columns), len(samples))
+	}
+
+	// Chunks follow each other from the magic to the footer
+	var totalSize int64
+	next := int64(len(parquetMagic))
+	values := map[string][]byte{}
+	for i, c := range Columns {
+		chunk := chunks[i].(thriftStructValue)
+		cm := chunk[3].(thriftStructValue)
+		offset, size := cm[9].(int64), cm[6].(int64)
+		if chunk[2] != offset || offset != next {
+			t.Fatalf("%s chunk at %v, data page at %d, want both at %d", c.Name, chunk[2], offset, next)
+		}
+		physical, _ := parquetType(c)
+		if cm[1] != int64(physical) || cm[4] != int64(codecUncompressed) || cm[5] != int64(len(samples)) || cm[7] != size {
+			t.Errorf("%s column metadata = %v", c.Name, cm)
+		}
+		if path := cm[3].([]interface{}); len(path) != 1 || path[0] != c.Name {
+			t.Errorf("%s path_in_schema = %v", c.Name, path)
+		}
+
+		page := thriftReader{b: b[offset : offset+size]}
+		header, err := page.structValue()
+		if err != nil {
+			t.Fatalf("%s page header doesn't decode: %v", c.Name, err)
+		}
+		data := header[5].(thriftStructValue)
+		valuesSize := header[2].(int64)
+		if header[1] != int64(pageTypeData) || header[3] != valuesSize || data[1] != int64(len(samples)) || data[2] != int64(encodingPlain) {
+			t.Errorf("%s page header = %v", c.Name, header)
+		}
+		if int64(page.pos)+valuesSize != size {
+			t.Errorf("%s page header of %d bytes and values of %d, want a chunk of %d", c.Name, page.pos, valuesSize, size)
+		}
+		values[c.Name] = page.b[page.pos:]
+
+		next += size
+		totalSize += size
+	}
+	if next != int64(metaStart) || rowGroup[2] != totalSize {
+		t.Errorf("chunks end at %d with a total of %v bytes, want %d and %d", next, rowGroup[2], metaStart, totalSize)
+	}
+
+	if got := int64(binary.LittleEndian.Uint64(values[TimeColumn][8:])); got != samples[1].Received.UnixNano()/1000 {
+		t.Errorf("time[1] = %d µs, want %d", got, samples[1].Received.UnixNano()/1000)
+	}
+	if got := binary.LittleEndian.Uint32(values["PackageID"][4*9:]); got != 1009 {
+		t.Errorf("PackageID[9] = %d, want 1009", got)
+	}
+	if got := binary.LittleEndian.Uint32(values["RPMRevLimiter"]); got != 8500 {
+		t.Errorf("RPMRevLimiter[0] = %d, want 8500", got)
+	}
+	if got := math.Float32frombits(binary.LittleEndian.Uint32(values["CarSpeed"][4*2:])); got != 100.5 {
+		t.Errorf("CarSpeed[2] = %v, want 100.5", got)
+	}
+	// Even samples are in race, bit packed from the least significant bit
+	if got := values["InRace"]; !bytes.Equal(got, []byte{0x55, 0x01}) {
+		t.Errorf("InRace = % x, want 55 01", got)
+	}
+
+	keyValues := meta[5].([]interface{})
+	kv := keyValues[0].(thriftStructValue)
+	var units map[string]string
+	if err := json.Unmarshal([]byte(kv[2].(string)), &units); kv[1] != "gt7.units" || err != nil {
+		t.Fatalf("key value metadata = %v, want gt7.units JSON", kv)
+	}
+	if units["CarSpeed"] != "km/h" || units["PackageID"] != "" {
+		t.Errorf("units = %v, want CarSpeed in km/h and none for PackageID", units)
+	}
+}
@@ -0,0 +1,101 @@
+package export
+
+import "bytes"
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the subset of the Thrift compact protocol needed by Parquet metadata.
+// The outermost struct is implicit and closed with endStruct, fields must be written in
+// increasing id order.
+type thriftWriter struct {
+	bytes.Buffer
+	// Last field id of the enclosing structs
+	parents []int16
+	current int16
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	delta := id - t.current
+	if delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.WriteByte(typ)
+		t.varint(uint64(zigzag(int64(id))))
+	}
+	t.current = id
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	for v >= 0x80 {
+		t.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	t.WriteByte(byte(v))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (t *thriftWriter) i32(v int32) {
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) binary(s string) {
+	t.varint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+func (t *thriftWriter) fieldI32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.i32(v)
+}
+
+func (t *thriftWriter) fieldI64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) fieldBinary(id int16, s string) {
+	t.fieldHeader(id, thriftBinary)
+	t.binary(s)
+}
+
+// fieldStruct starts a nested struct field, closed with endStruct
+func (t *thriftWriter) fieldStruct(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.beginStruct()
+}
+
+// fieldList starts a list field. Elements follow with i32 or binary, or between
+// beginStruct and endStruct for structs.
+func (t *thriftWriter) fieldList(id int16, elem byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.WriteByte(byte(size)<<4 | elem)
+	} else {
+		t.WriteByte(0xF0 | elem)
+		t.varint(uint64(size))
+	}
+}
+
+func (t *thriftWriter) beginStruct() {
+	t.parents = append(t.parents, t.current)
+	t.current = 0
+}
+
+// endStruct writes the stop field of the current struct and goes back to its parent
+func (t *thriftWriter) endStruct() {
+	t.WriteByte(0)
+	if n := len(t.parents); n > 0 {
+		t.current = t.parents[n-1]
+		t.parents = t.parents[:n-1]
+	}
+}
@@ -0,0 +1,112 @@
+package gt7
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// Samples older than this are no longer exported, so that idle consoles disappear
+const exporterStaleAfter = time.Minute
+
+var exporterLabels = []string{"console", "car"}
+
+// TelemetryExporter exposes the latest frame of every console as Prometheus gauges, one per
+// TelemetryFrame field, and completed lap times as a histogram.
+type TelemetryExporter struct {
+	mu          sync.Mutex
+	latest      map[string]Sample
+	lastLapTime map[string]int32
+	descs       map[string]*prometheus.Desc
+
+	lapTimes *prometheus.HistogramVec
+}
+
+func NewTelemetryExporter() *TelemetryExporter {
+	return &TelemetryExporter{
+		latest:      map[string]Sample{},
+		lastLapTime: map[string]int32{},
+		descs:       map[string]*prometheus.Desc{},
+		lapTimes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "gt7",
+			Name:      "lap_time_seconds",
+			Help:      "Completed lap times.",
+			Buckets:   prometheus.LinearBuckets(30, 10, 28),
+		}, exporterLabels),
+	}
+}
+
+// Observe records a sample, and its lap time when a lap was just completed
+func (e *TelemetryExporter) Observe(s Sample) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.latest[s.Driver] = s
+
+	// LastLap changes once per completed lap, the first sample only sets the baseline
+	previous, seen := e.lastLapTime[s.Driver]
+	e.lastLapTime[s.Driver] = s.Frame.LastLap
+	if seen && s.Frame.LastLap != previous && s.Frame.LastLap > 0 {
+		lap := time.Duration(s.Frame.LastLap) * time.Millisecond
+		e.lapTimes.WithLabelValues(s.Driver, carLabel(s.Frame)).Observe(lap.Seconds())
+	}
+}
+
+// Describe implements prometheus.Collector. Only the lap histogram is described, so the
+// registry checks it at registration. The gauges are created by Collect from the frame fields
+// and left undescribed, which only a pedantic registry would reject.
+func (e *TelemetryExporter) Describe(ch chan<- *prometheus.Desc) {
+	e.lapTimes.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (e *TelemetryExporter) Collect(ch chan<- prometheus.Metric) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for driver, s := range e.latest {
+		if time.Since(s.Received) > exporterStaleAfter {
+			continue
+		}
+		car := carLabel(s.Frame)
+		for name, value := range packet.TelemetryToMap(s.Frame) {
+			ch <- prometheus.MustNewConstMetric(e.desc(name), prometheus.GaugeValue, float64(value), driver, car)
+		}
+	}
+	e.lapTimes.Collect(ch)
+}
+
+func (e *TelemetryExporter) desc(field string) *prometheus.Desc {
+	desc, ok := e.descs[field]
+	if !ok {
+		desc = prometheus.NewDesc("gt7_"+snakeCase(field), "Latest "+field+" value.", exporterLabels, nil)
+		e.descs[field] = desc
+	}
+	return desc
+}
+
+func carLabel(tf packet.TelemetryFrame) string {
+	return strconv.Itoa(int(tf.CarID))
+}
+
+// snakeCase turns TyreTempFL into tyre_temp_fl
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (nextLower && unicode.IsUpper(runes[i-1])) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
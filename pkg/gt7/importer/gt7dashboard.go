@@ -0,0 +1,171 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/session"
+)
+
+// Layouts of the timestamps gt7dashboard writes in JSON, str(datetime) and ISO 8601
+var gt7DashboardTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999",
+	"2006-01-02T15:04:05.999999",
+	time.RFC3339Nano,
+}
+
+// importGT7Dashboard stores the laps saved by gt7dashboard as a single session. Laps only
+// hold a subset of the telemetry, one value per packet, which is encoded back into datagrams.
+func importGT7Dashboard(store session.Store, r io.Reader, format Format, opts Options) ([]string, error) {
+	var data interface{}
+	var err error
+	if format == FormatGT7DashboardPickle {
+		data, err = unpickle(r)
+	} else {
+		err = json.NewDecoder(r).Decode(&data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", format, err)
+	}
+
+	laps := gt7DashboardLaps(data)
+	if len(laps) == 0 {
+		return nil, errNoTelemetry
+	}
+	sort.SliceStable(laps, func(i, j int) bool { return number(laps[i]["number"]) < number(laps[j]["number"]) })
+
+	var frames []frame
+	var packageID int32
+	var lastLap, bestLap int32
+	next := opts.started()
+	for _, lap := range laps {
+		if start, ok := timestamp(lap["lap_start_timestamp"]); ok {
+			next = start
+		}
+
+		speed := series(lap["data_speed"])
+		throttle := series(lap["data_throttle"])
+		brake := series(lap["data_braking"])
+		rpm := series(lap["data_rpm"])
+		gear := series(lap["data_gear"])
+		boost := series(lap["data_boost"])
+		yaw := series(lap["data_rotation_yaw"])
+		x := series(lap["data_position_x"])
+		y := series(lap["data_position_y"])
+		z := series(lap["data_position_z"])
+
+		for i := range speed {
+			packageID++
+			tf := packet.TelemetryFrame{
+				PackageID:         packageID,
+				CurrentLap:        int16(number(lap["number"])),
+				TotalLaps:         int16(number(lap["total_laps"])),
+				LastLap:           lastLap,
+				BestLap:           bestLap,
+				CarID:             int32(number(lap["car_id"])),
+				CarSpeed:          float32(speed[i]),
+				Throttle:          float32(at(throttle, i)),
+				Brake:             float32(at(brake, i)),
+				RPM:               float32(at(rpm, i)),
+				CurrentGear:       uint8(at(gear, i)),
+				Boost:             float32(at(boost, i)),
+				PositionX:         float32(at(x, i)),
+				PositionY:         float32(at(y, i)),
+				PositionZ:         float32(at(z, i)),
+				Flags:             packet.SimFlagCarOnTrack,
+				RotationYaw:       float32(at(yaw, i)),
+				EstimatedTopSpeed: int16(number(lap["estimated_top_speed"])),
+			}
+			// Only the yaw component of the rotation is saved, assume a flat track
+			tf.QuaternionScalar = float32(math.Sqrt(math.Max(0, 1-float64(tf.RotationYaw*tf.RotationYaw))))
+
+			frames = append(frames, frame{time: next, frame: tf})
+			next = next.Add(time.Second / packet.PacketRate)
+		}
+
+		if finish := int32(number(lap["lap_finish_time"])); finish > 0 {
+			lastLap = finish
+			if bestLap == 0 || finish < bestLap {
+				bestLap = finish
+			}
+		}
+	}
+
+	id, err := writeFrames(store, session.Metadata{Driver: opts.driver("gt7dashboard"), Source: string(format)}, frames)
+	if err != nil {
+		return nil, err
+	}
+	return []string{id}, nil
+}
+
+// gt7DashboardLaps finds the laps in a list of laps, a single lap or a {"laps": [...]} object
+func gt7DashboardLaps(data interface{}) []map[string]interface{} {
+	switch v := data.(type) {
+	case []interface{}:
+		var laps []map[string]interface{}
+		for _, item := range v {
+			laps = append(laps, gt7DashboardLaps(item)...)
+		}
+		return laps
+	case *pickleObject:
+		return gt7DashboardLaps(v.State)
+	case map[string]interface{}:
+		if laps, ok := v["laps"]; ok {
+			return gt7DashboardLaps(laps)
+		}
+		if _, ok := v["data_speed"]; ok {
+			return []map[string]interface{}{v}
+		}
+	}
+	return nil
+}
+
+// number converts a decoded number, 0 for anything else
+func number(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int64:
+		return float64(n)
+	case bool:
+		if n {
+			return 1
+		}
+	}
+	return 0
+}
+
+func series(v interface{}) []float64 {
+	items, _ := v.([]interface{})
+	values := make([]float64, len(items))
+	for i, item := range items {
+		values[i] = number(item)
+	}
+	return values
+}
+
+func at(values []float64, i int) float64 {
+	if i < len(values) {
+		return values[i]
+	}
+	return 0
+}
+
+func timestamp(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		for _, layout := range gt7DashboardTimeLayouts {
+			if parsed, err := time.ParseInLocation(layout, t, time.Local); err == nil {
+				return parsed, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,123 @@
+// Package importer converts captures made by other GT7 tools into sessions of a session.Store,
+// so that old data can be exported and analysed like recorded sessions.
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/session"
+)
+
+var errNoTelemetry = errors.New("no GT7 telemetry found")
+
+// Format is a third-party capture format
+type Format string
+
+const (
+	// FormatPcap is a pcap or pcapng capture of the telemetry datagrams
+	FormatPcap Format = "pcap"
+	// FormatGT7DashboardPickle is a file of laps saved by gt7dashboard
+	FormatGT7DashboardPickle Format = "gt7dashboard-pickle"
+	// FormatGT7DashboardJSON is a list of gt7dashboard laps in JSON
+	FormatGT7DashboardJSON Format = "gt7dashboard-json"
+	// FormatSimHubCSV is a SimHub property log in CSV
+	FormatSimHubCSV Format = "simhub-csv"
+)
+
+// Formats lists the supported formats
+var Formats = []Format{FormatPcap, FormatGT7DashboardPickle, FormatGT7DashboardJSON, FormatSimHubCSV}
+
+// ParseFormat validates a format name
+func ParseFormat(name string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown import format %q", name)
+}
+
+// DetectFormat guesses the format of a file from its name
+func DetectFormat(name string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".pcap", ".pcapng", ".cap":
+		return FormatPcap, nil
+	case ".pickle", ".pkl":
+		return FormatGT7DashboardPickle, nil
+	case ".json":
+		return FormatGT7DashboardJSON, nil
+	case ".csv":
+		return FormatSimHubCSV, nil
+	}
+	return "", fmt.Errorf("can't tell the format of %s", name)
+}
+
+// Options of an import
+type Options struct {
+	// Driver of the imported sessions. Captures name them after the console IP when empty.
+	Driver string
+	// Start of the session for formats without timestamps, the import time when zero
+	Started time.Time
+}
+
+func (o Options) driver(fallback string) string {
+	if o.Driver != "" {
+		return o.Driver
+	}
+	return fallback
+}
+
+func (o Options) started() time.Time {
+	if o.Started.IsZero() {
+		return time.Now()
+	}
+	return o.Started
+}
+
+// Import reads a capture and stores it as new sessions, returning their IDs
+func Import(store session.Store, r io.Reader, format Format, opts Options) ([]string, error) {
+	switch format {
+	case FormatPcap:
+		return importPcap(store, r, opts)
+	case FormatGT7DashboardPickle, FormatGT7DashboardJSON:
+		return importGT7Dashboard(store, r, format, opts)
+	case FormatSimHubCSV:
+		return importSimHub(store, r, opts)
+	}
+	return nil, fmt.Errorf("unknown import format %q", format)
+}
+
+// frame is a decoded frame of a format without raw packets
+type frame struct {
+	time  time.Time
+	frame packet.TelemetryFrame
+}
+
+// writeFrames stores frames as a session, encoding them back into datagrams
+func writeFrames(store session.Store, meta session.Metadata, frames []frame) (string, error) {
+	if len(frames) == 0 {
+		return "", errNoTelemetry
+	}
+
+	meta.Started = frames[0].time
+	id, w, err := store.Create(meta)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range frames {
+		tf := &frames[i].frame
+		if err := w.Write(frames[i].time, packet.Encrypt(packet.Encode(tf), uint32(tf.PackageID))); err != nil {
+			w.Close()
+			return "", err
+		}
+	}
+
+	return id, w.Close()
+}
@@ -0,0 +1,80 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7/session"
+)
+
+func TestImport(t *testing.T) {
+	started := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		format Format
+		data   string
+		// Expected PackageID of every sample
+		ids []int32
+		// Expected EstimatedTopSpeed of the first sample
+		topSpeed int16
+	}{
+		{
+			name:   "simhub at 10 Hz",
+			format: FormatSimHubCSV,
+			data: "Time,SpeedKmh\n" +
+				"2024-01-01T12:00:00Z,100\n" +
+				"2024-01-01T12:00:00.1Z,101\n" +
+				"2024-01-01T12:00:00.2Z,102\n",
+			ids: []int32{1, 7, 13},
+		},
+		{
+			name:   "simhub without times",
+			format: FormatSimHubCSV,
+			data:   "SpeedKmh\n100\n101\n102\n",
+			ids:    []int32{1, 2, 3},
+		},
+		{
+			name:   "simhub rows with the same time",
+			format: FormatSimHubCSV,
+			data: "Time,SpeedKmh\n" +
+				"2024-01-01T12:00:00Z,100\n" +
+				"2024-01-01T12:00:00Z,101\n",
+			ids: []int32{1, 2},
+		},
+		{
+			name:     "gt7dashboard",
+			format:   FormatGT7DashboardJSON,
+			data:     `[{"number": 1, "estimated_top_speed": 287, "data_speed": [100, 101]}]`,
+			ids:      []int32{1, 2},
+			topSpeed: 287,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := session.Store{Dir: t.TempDir()}
+			ids, err := Import(store, strings.NewReader(tt.data), tt.format, Options{Driver: "driver", Started: started})
+			if err != nil {
+				t.Fatalf("Import() failed: %v", err)
+			}
+			if len(ids) != 1 {
+				t.Fatalf("Import() returned %d sessions, want 1", len(ids))
+			}
+			_, samples, err := store.Samples(ids[0])
+			if err != nil {
+				t.Fatalf("Samples() failed: %v", err)
+			}
+			if len(samples) != len(tt.ids) {
+				t.Fatalf("session has %d samples, want %d", len(samples), len(tt.ids))
+			}
+			for i, s := range samples {
+				if s.Frame.PackageID != tt.ids[i] {
+					t.Errorf("sample %d: PackageID = %d, want %d", i, s.Frame.PackageID, tt.ids[i])
+				}
+			}
+			if got := samples[0].Frame.EstimatedTopSpeed; got != tt.topSpeed {
+				t.Errorf("EstimatedTopSpeed = %d, want %d", got, tt.topSpeed)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package importer
+
+import (
+	"io"
+	"sort"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7/capture"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/session"
+)
+
+// importPcap stores the telemetry datagrams of a capture, one session per console. Datagrams
+// are kept as captured, those that packet.Decrypt rejects are dropped.
+func importPcap(store session.Store, r io.Reader, opts Options) ([]string, error) {
+	cr, err := capture.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	writers := map[string]*session.Writer{}
+	var ids []string
+	closeAll := func() {
+		for _, w := range writers {
+			w.Close()
+		}
+	}
+
+	for {
+		d, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+
+		if !capture.DefaultFilter.Telemetry(d) {
+			continue
+		}
+		if _, err := packet.Decrypt(d.Data); err != nil {
+			continue
+		}
+
+		console := d.Src.IP.String()
+		w, ok := writers[console]
+		if !ok {
+			var id string
+			id, w, err = store.Create(session.Metadata{Driver: opts.driver(console), Started: d.Time, Source: string(FormatPcap)})
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			writers[console] = w
+			ids = append(ids, id)
+		}
+
+		if err := w.Write(d.Time, d.Data); err != nil {
+			closeAll()
+			return nil, err
+		}
+	}
+
+	for _, w := range writers {
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if len(ids) == 0 {
+		return nil, errNoTelemetry
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
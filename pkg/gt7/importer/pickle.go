@@ -0,0 +1,628 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pickleObject is an instance of a Python class, with its constructor arguments and the
+// state set by BUILD, usually its __dict__
+type pickleObject struct {
+	Module string
+	Name   string
+	Args   []interface{}
+	State  interface{}
+}
+
+// pickleGlobal is a reference to a Python class or function
+type pickleGlobal struct {
+	Module string
+	Name   string
+}
+
+// pickleList is a list while unpickling, memoized by reference so that later appends are
+// seen by every reference
+type pickleList struct {
+	items []interface{}
+}
+
+// unpickle decodes a Python pickle into nil, bool, int64, *big.Int, float64, string, []byte,
+// []interface{} for lists and tuples, map[string]interface{} for dicts, time.Time for
+// datetimes and *pickleObject for other class instances. Only data is decoded, nothing is
+// ever executed.
+func unpickle(r io.Reader) (interface{}, error) {
+	u := unpickler{r: bufio.NewReader(r), memo: map[int]interface{}{}}
+	v, err := u.run()
+	if err != nil {
+		return nil, err
+	}
+	return normalize(v, map[interface{}]bool{}, map[interface{}]bool{})
+}
+
+// mapIdentity tells dicts apart, as maps can't be map keys
+type mapIdentity uintptr
+
+// normalize replaces the lists of a decoded value by slices. Values shared by several
+// containers are normalized once, and containers holding themselves, which the memo allows to
+// build, are rejected rather than recursed into forever.
+func normalize(v interface{}, seen, path map[interface{}]bool) (interface{}, error) {
+	var id interface{}
+	switch v := v.(type) {
+	case *pickleList, *pickleObject:
+		id = v
+	case map[string]interface{}:
+		id = mapIdentity(reflect.ValueOf(v).Pointer())
+	}
+	if id != nil {
+		if path[id] {
+			return nil, errPickle
+		}
+		if seen[id] {
+			if l, ok := v.(*pickleList); ok {
+				return l.items, nil
+			}
+			return v, nil
+		}
+		seen[id] = true
+		path[id] = true
+		defer delete(path, id)
+	}
+
+	var err error
+	switch v := v.(type) {
+	case *pickleList:
+		for i, item := range v.items {
+			if v.items[i], err = normalize(item, seen, path); err != nil {
+				return nil, err
+			}
+		}
+		return v.items, nil
+	case []interface{}:
+		for i, item := range v {
+			if v[i], err = normalize(item, seen, path); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			if v[k], err = normalize(item, seen, path); err != nil {
+				return nil, err
+			}
+		}
+	case *pickleObject:
+		for i, arg := range v.Args {
+			if v.Args[i], err = normalize(arg, seen, path); err != nil {
+				return nil, err
+			}
+		}
+		if v.State, err = normalize(v.State, seen, path); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+type unpickler struct {
+	r     *bufio.Reader
+	stack []interface{}
+	marks []int
+	memo  map[int]interface{}
+}
+
+var errPickle = errors.New("invalid pickle")
+
+func (u *unpickler) push(v interface{}) {
+	u.stack = append(u.stack, v)
+}
+
+func (u *unpickler) pop() (interface{}, error) {
+	if len(u.stack) == 0 {
+		return nil, errPickle
+	}
+	v := u.stack[len(u.stack)-1]
+	u.stack = u.stack[:len(u.stack)-1]
+	return v, nil
+}
+
+func (u *unpickler) top() (interface{}, error) {
+	if len(u.stack) == 0 {
+		return nil, errPickle
+	}
+	return u.stack[len(u.stack)-1], nil
+}
+
+// popMark returns the items pushed since the last MARK
+func (u *unpickler) popMark() ([]interface{}, error) {
+	if len(u.marks) == 0 {
+		return nil, errPickle
+	}
+	mark := u.marks[len(u.marks)-1]
+	u.marks = u.marks[:len(u.marks)-1]
+	if mark > len(u.stack) {
+		return nil, errPickle
+	}
+	items := append([]interface{}(nil), u.stack[mark:]...)
+	u.stack = u.stack[:mark]
+	return items, nil
+}
+
+// read returns the next n bytes. The buffer grows as they arrive, so that a pickle declaring
+// a huge length doesn't allocate it upfront.
+func (u *unpickler) read(n int) ([]byte, error) {
+	if n < 0 || n > 1<<30 {
+		return nil, errPickle
+	}
+	var b bytes.Buffer
+	if _, err := io.CopyN(&b, u.r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (u *unpickler) readUint(n int) (uint64, error) {
+	b, err := u.read(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, nil
+}
+
+func (u *unpickler) readLine() (string, error) {
+	line, err := u.r.ReadString('\n')
+	return strings.TrimSuffix(line, "\n"), err
+}
+
+// appendItems appends to the list on top of the stack
+func (u *unpickler) appendItems(items []interface{}) error {
+	list, err := u.top()
+	if err != nil {
+		return err
+	}
+	l, ok := list.(*pickleList)
+	if !ok {
+		return errPickle
+	}
+	l.items = append(l.items, items...)
+	return nil
+}
+
+func (u *unpickler) setItems(items []interface{}) error {
+	if len(items)%2 != 0 {
+		return errPickle
+	}
+	dict, err := u.top()
+	if err != nil {
+		return err
+	}
+	d, ok := dict.(map[string]interface{})
+	if !ok {
+		return errPickle
+	}
+	for i := 0; i < len(items); i += 2 {
+		key, err := dictKey(items[i])
+		if err != nil {
+			return err
+		}
+		d[key] = items[i+1]
+	}
+	return nil
+}
+
+// dictKey returns the string key of a dict key. Only scalars are accepted: formatting a
+// container holding itself would never end.
+func dictKey(k interface{}) (string, error) {
+	switch k := k.(type) {
+	case string:
+		return k, nil
+	case int64:
+		return strconv.FormatInt(k, 10), nil
+	case float64:
+		return strconv.FormatFloat(k, 'g', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(k), nil
+	}
+	return "", fmt.Errorf("unsupported pickle dict key of type %T", k)
+}
+
+// construct instantiates a class. Datetimes become time.Time, everything else a pickleObject.
+// Nothing is imported or called.
+func construct(class interface{}, args []interface{}) (interface{}, error) {
+	g, ok := class.(pickleGlobal)
+	if !ok {
+		return nil, errPickle
+	}
+	// Protocol 2 has no bytes type, they are pickled as latin-1 strings
+	if g.Module == "_codecs" && g.Name == "encode" && len(args) == 2 {
+		if s, ok := args[0].(string); ok {
+			b := make([]byte, 0, len(s))
+			for _, r := range s {
+				b = append(b, byte(r))
+			}
+			return b, nil
+		}
+	}
+	if g.Module == "datetime" && g.Name == "datetime" && len(args) >= 1 {
+		if b, ok := args[0].([]byte); ok && len(b) == 10 {
+			return time.Date(
+				int(b[0])<<8|int(b[1]), time.Month(b[2]), int(b[3]),
+				int(b[4]), int(b[5]), int(b[6]), (int(b[7])<<16|int(b[8])<<8|int(b[9]))*1000,
+				time.Local,
+			), nil
+		}
+	}
+	return &pickleObject{Module: g.Module, Name: g.Name, Args: args}, nil
+}
+
+func (u *unpickler) run() (interface{}, error) {
+	for {
+		op, err := u.r.ReadByte()
+		if err != nil {
+			return nil, errPickle
+		}
+
+		switch op {
+		case 0x80: // PROTO
+			if _, err := u.read(1); err != nil {
+				return nil, err
+			}
+		case 0x95: // FRAME
+			if _, err := u.read(8); err != nil {
+				return nil, err
+			}
+		case '.': // STOP
+			return u.pop()
+
+		case '(': // MARK
+			u.marks = append(u.marks, len(u.stack))
+		case '0': // POP
+			if _, err := u.pop(); err != nil {
+				return nil, err
+			}
+		case '1': // POP_MARK
+			if _, err := u.popMark(); err != nil {
+				return nil, err
+			}
+		case '2': // DUP
+			v, err := u.top()
+			if err != nil {
+				return nil, err
+			}
+			u.push(v)
+
+		case 'N':
+			u.push(nil)
+		case 0x88:
+			u.push(true)
+		case 0x89:
+			u.push(false)
+		case 'J': // BININT
+			v, err := u.readUint(4)
+			if err != nil {
+				return nil, err
+			}
+			u.push(int64(int32(v)))
+		case 'K': // BININT1
+			v, err := u.readUint(1)
+			if err != nil {
+				return nil, err
+			}
+			u.push(int64(v))
+		case 'M': // BININT2
+			v, err := u.readUint(2)
+			if err != nil {
+				return nil, err
+			}
+			u.push(int64(v))
+		case 0x8a, 0x8b: // LONG1, LONG4
+			size := 1
+			if op == 0x8b {
+				size = 4
+			}
+			n, err := u.readUint(size)
+			if err != nil {
+				return nil, err
+			}
+			b, err := u.read(int(n))
+			if err != nil {
+				return nil, err
+			}
+			u.push(decodeLong(b))
+		case 'I', 'L': // INT, LONG
+			line, err := u.readLine()
+			if err != nil {
+				return nil, err
+			}
+			line = strings.TrimSuffix(line, "L")
+			switch line {
+			case "00":
+				u.push(false)
+			case "01":
+				u.push(true)
+			default:
+				v, err := strconv.ParseInt(line, 10, 64)
+				if err != nil {
+					return nil, errPickle
+				}
+				u.push(v)
+			}
+		case 'G': // BINFLOAT
+			b, err := u.read(8)
+			if err != nil {
+				return nil, err
+			}
+			u.push(math.Float64frombits(binary.BigEndian.Uint64(b)))
+		case 'F': // FLOAT
+			line, err := u.readLine()
+			if err != nil {
+				return nil, err
+			}
+			v, err := strconv.ParseFloat(line, 64)
+			if err != nil {
+				return nil, errPickle
+			}
+			u.push(v)
+
+		case 'X', 0x8c, 0x8d, 'T', 'U': // BINUNICODE, SHORT_BINUNICODE, BINUNICODE8, BINSTRING, SHORT_BINSTRING
+			size := map[byte]int{'X': 4, 0x8c: 1, 0x8d: 8, 'T': 4, 'U': 1}[op]
+			n, err := u.readUint(size)
+			if err != nil {
+				return nil, err
+			}
+			b, err := u.read(int(n))
+			if err != nil {
+				return nil, err
+			}
+			u.push(string(b))
+		case 'B', 'C', 0x8e: // BINBYTES, SHORT_BINBYTES, BINBYTES8
+			size := map[byte]int{'B': 4, 'C': 1, 0x8e: 8}[op]
+			n, err := u.readUint(size)
+			if err != nil {
+				return nil, err
+			}
+			b, err := u.read(int(n))
+			if err != nil {
+				return nil, err
+			}
+			u.push(b)
+
+		case ']', 0x8f: // EMPTY_LIST, EMPTY_SET
+			u.push(&pickleList{})
+		case ')': // EMPTY_TUPLE
+			u.push([]interface{}{})
+		case '}': // EMPTY_DICT
+			u.push(map[string]interface{}{})
+		case 'l': // LIST
+			items, err := u.popMark()
+			if err != nil {
+				return nil, err
+			}
+			u.push(&pickleList{items: items})
+		case 't', 0x91: // TUPLE, FROZENSET
+			items, err := u.popMark()
+			if err != nil {
+				return nil, err
+			}
+			u.push(items)
+		case 0x85, 0x86, 0x87: // TUPLE1, TUPLE2, TUPLE3
+			n := int(op - 0x84)
+			if len(u.stack) < n {
+				return nil, errPickle
+			}
+			items := append([]interface{}(nil), u.stack[len(u.stack)-n:]...)
+			u.stack = u.stack[:len(u.stack)-n]
+			u.push(items)
+		case 'd': // DICT
+			items, err := u.popMark()
+			if err != nil {
+				return nil, err
+			}
+			u.push(map[string]interface{}{})
+			if err := u.setItems(items); err != nil {
+				return nil, err
+			}
+		case 'a': // APPEND
+			v, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			if err := u.appendItems([]interface{}{v}); err != nil {
+				return nil, err
+			}
+		case 'e', 0x90: // APPENDS, ADDITEMS
+			items, err := u.popMark()
+			if err != nil {
+				return nil, err
+			}
+			if err := u.appendItems(items); err != nil {
+				return nil, err
+			}
+		case 's': // SETITEM
+			value, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			key, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			if err := u.setItems([]interface{}{key, value}); err != nil {
+				return nil, err
+			}
+		case 'u': // SETITEMS
+			items, err := u.popMark()
+			if err != nil {
+				return nil, err
+			}
+			if err := u.setItems(items); err != nil {
+				return nil, err
+			}
+
+		case 0x94: // MEMOIZE
+			v, err := u.top()
+			if err != nil {
+				return nil, err
+			}
+			u.memo[len(u.memo)] = v
+		case 'q', 'r': // BINPUT, LONG_BINPUT
+			size := 1
+			if op == 'r' {
+				size = 4
+			}
+			i, err := u.readUint(size)
+			if err != nil {
+				return nil, err
+			}
+			v, err := u.top()
+			if err != nil {
+				return nil, err
+			}
+			u.memo[int(i)] = v
+		case 'h', 'j': // BINGET, LONG_BINGET
+			size := 1
+			if op == 'j' {
+				size = 4
+			}
+			i, err := u.readUint(size)
+			if err != nil {
+				return nil, err
+			}
+			v, ok := u.memo[int(i)]
+			if !ok {
+				return nil, errPickle
+			}
+			u.push(v)
+
+		case 'c': // GLOBAL
+			module, err := u.readLine()
+			if err != nil {
+				return nil, err
+			}
+			name, err := u.readLine()
+			if err != nil {
+				return nil, err
+			}
+			u.push(pickleGlobal{Module: module, Name: name})
+		case 0x93: // STACK_GLOBAL
+			name, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			module, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			m, ok := module.(string)
+			if !ok {
+				return nil, errPickle
+			}
+			n, ok := name.(string)
+			if !ok {
+				return nil, errPickle
+			}
+			u.push(pickleGlobal{Module: m, Name: n})
+		case 'R', 0x81: // REDUCE, NEWOBJ
+			args, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			class, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			argList, _ := args.([]interface{})
+			obj, err := construct(class, argList)
+			if err != nil {
+				return nil, err
+			}
+			u.push(obj)
+		case 0x92: // NEWOBJ_EX
+			if _, err := u.pop(); err != nil {
+				return nil, err
+			}
+			args, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			class, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			argList, _ := args.([]interface{})
+			obj, err := construct(class, argList)
+			if err != nil {
+				return nil, err
+			}
+			u.push(obj)
+		case 'b': // BUILD
+			state, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			obj, err := u.top()
+			if err != nil {
+				return nil, err
+			}
+			if o, ok := obj.(*pickleObject); ok {
+				// Objects with __slots__ get a (dict, slots) tuple
+				if pair, ok := state.([]interface{}); ok && len(pair) == 2 {
+					merged := map[string]interface{}{}
+					for _, part := range pair {
+						if m, ok := part.(map[string]interface{}); ok {
+							for k, v := range m {
+								merged[k] = v
+							}
+						}
+					}
+					state = merged
+				}
+				o.State = state
+			}
+
+		default:
+			return nil, fmt.Errorf("unsupported pickle opcode 0x%02x", op)
+		}
+	}
+}
+
+// decodeLong decodes a little endian two's complement integer
+func decodeLong(b []byte) interface{} {
+	if len(b) == 0 {
+		return int64(0)
+	}
+	if len(b) <= 8 {
+		var v int64
+		for i := len(b) - 1; i >= 0; i-- {
+			v = v<<8 | int64(b[i])
+		}
+		// Sign extension
+		shift := uint(64 - 8*len(b))
+		return v << shift >> shift
+	}
+
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	v := new(big.Int).SetBytes(be)
+	if b[len(b)-1]&0x80 != 0 {
+		v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	return v
+}
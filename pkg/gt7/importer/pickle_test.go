@@ -0,0 +1,84 @@
+package importer
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestUnpickle(t *testing.T) {
+	tests := []struct {
+		name    string
+		pickle  string
+		want    interface{}
+		invalid bool
+	}{
+		{
+			name:   "dict",
+			pickle: "\x80\x04}\x94\x8c\x01a\x94K\x01s.",
+			want:   map[string]interface{}{"a": int64(1)},
+		},
+		{
+			name:   "shared list",
+			pickle: "\x80\x04](K\x01e\x94h\x00\x86\x94.",
+			want:   []interface{}{[]interface{}{int64(1)}, []interface{}{int64(1)}},
+		},
+		{
+			name:    "dict holding itself",
+			pickle:  "\x80\x04}\x94\x8c\x01a\x94h\x00s.",
+			invalid: true,
+		},
+		{
+			name:    "list holding itself",
+			pickle:  "\x80\x04]\x94h\x00a.",
+			invalid: true,
+		},
+		{
+			name:    "dict holding itself as a key",
+			pickle:  "\x80\x04}\x94\x8c\x01a\x94h\x00s}h\x00Ns.",
+			invalid: true,
+		},
+		{
+			name:    "list as a dict key",
+			pickle:  "\x80\x04}](K\x01eNs.",
+			invalid: true,
+		},
+		{
+			name:   "scalar dict keys",
+			pickle: "\x80\x04}(K\x01N\x88NG?\xf8\x00\x00\x00\x00\x00\x00Nu.",
+			want:   map[string]interface{}{"1": nil, "true": nil, "1.5": nil},
+		},
+		{
+			name:    "huge bytes length",
+			pickle:  "\x80\x04B\x00\x00\x00\x40abc.",
+			invalid: true,
+		},
+		{
+			name:    "non string global",
+			pickle:  "\x80\x04K\x01K\x02\x93.",
+			invalid: true,
+		},
+		{
+			name:    "dict holding itself through a list",
+			pickle:  "\x80\x04}\x94\x8c\x01a\x94]\x94h\x00as.",
+			invalid: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unpickle(bytes.NewReader([]byte(tt.pickle)))
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("unpickle() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unpickle() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unpickle() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package importer
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/session"
+)
+
+// SimHub properties, by the last part of their name (e.g. DataCorePlugin.GameData.NewData.SpeedKmh),
+// and how they set a frame field
+var simHubProperties = map[string]func(tf *packet.TelemetryFrame, v string){
+	"speedkmh":                  func(tf *packet.TelemetryFrame, v string) { tf.CarSpeed = parseFloat(v) },
+	"rpms":                      func(tf *packet.TelemetryFrame, v string) { tf.RPM = parseFloat(v) },
+	"maxrpm":                    func(tf *packet.TelemetryFrame, v string) { tf.RPMRevLimiter = uint16(parseFloat(v)) },
+	"throttle":                  func(tf *packet.TelemetryFrame, v string) { tf.Throttle = parseFloat(v) },
+	"brake":                     func(tf *packet.TelemetryFrame, v string) { tf.Brake = parseFloat(v) },
+	"gear":                      func(tf *packet.TelemetryFrame, v string) { tf.CurrentGear = uint8(parseFloat(v)) },
+	"currentlap":                func(tf *packet.TelemetryFrame, v string) { tf.CurrentLap = int16(parseFloat(v)) },
+	"totallaps":                 func(tf *packet.TelemetryFrame, v string) { tf.TotalLaps = int16(parseFloat(v)) },
+	"position":                  func(tf *packet.TelemetryFrame, v string) { tf.CurrentPosition = int16(parseFloat(v)) },
+	"lastlaptime":               func(tf *packet.TelemetryFrame, v string) { tf.LastLap = parseLapTime(v) },
+	"bestlaptime":               func(tf *packet.TelemetryFrame, v string) { tf.BestLap = parseLapTime(v) },
+	"fuel":                      func(tf *packet.TelemetryFrame, v string) { tf.CurrentFuel = parseFloat(v) },
+	"maxfuel":                   func(tf *packet.TelemetryFrame, v string) { tf.FuelCapacity = parseFloat(v) },
+	"turbo":                     func(tf *packet.TelemetryFrame, v string) { tf.Boost = parseFloat(v) },
+	"oiltemperature":            func(tf *packet.TelemetryFrame, v string) { tf.OilTemp = parseFloat(v) },
+	"oilpressure":               func(tf *packet.TelemetryFrame, v string) { tf.OilPressure = parseFloat(v) },
+	"watertemperature":          func(tf *packet.TelemetryFrame, v string) { tf.WaterTemp = parseFloat(v) },
+	"tyretemperaturefrontleft":  func(tf *packet.TelemetryFrame, v string) { tf.TyreTempFL = parseFloat(v) },
+	"tyretemperaturefrontright": func(tf *packet.TelemetryFrame, v string) { tf.TyreTempFR = parseFloat(v) },
+	"tyretemperaturerearleft":   func(tf *packet.TelemetryFrame, v string) { tf.TyreTempRL = parseFloat(v) },
+	"tyretemperaturerearright":  func(tf *packet.TelemetryFrame, v string) { tf.TyreTempRR = parseFloat(v) },
+	"carid":                     func(tf *packet.TelemetryFrame, v string) { tf.CarID = int32(parseFloat(v)) },
+	"ispaused": func(tf *packet.TelemetryFrame, v string) {
+		if parseBool(v) {
+			tf.Flags |= packet.SimFlagPaused
+		}
+	},
+}
+
+// Columns holding the time of each row
+var simHubTimeColumns = map[string]bool{"time": true, "timestamp": true, "datetime": true}
+
+var simHubTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"02/01/2006 15:04:05.999999999",
+}
+
+func parseFloat(v string) float32 {
+	f, _ := strconv.ParseFloat(strings.TrimSpace(strings.Replace(v, ",", ".", 1)), 32)
+	return float32(f)
+}
+
+func parseBool(v string) bool {
+	v = strings.ToLower(strings.TrimSpace(v))
+	return v == "true" || v == "1"
+}
+
+// parseLapTime reads a lap time in milliseconds from a TimeSpan (00:01:23.4560000) or seconds
+func parseLapTime(v string) int32 {
+	v = strings.TrimSpace(v)
+	parts := strings.Split(v, ":")
+	var seconds float64
+	for _, part := range parts {
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + f
+	}
+	return int32(seconds * 1000)
+}
+
+func parseTime(v string) (time.Time, bool) {
+	v = strings.TrimSpace(v)
+	for _, layout := range simHubTimeLayouts {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// importSimHub stores a SimHub property log as a single session. Rows without a time column
+// are assumed to be 60 Hz, starting at opts.Started.
+func importSimHub(store session.Store, r io.Reader, opts Options) ([]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	// SimHub uses ; in locales where , is the decimal separator
+	if len(header) == 1 && strings.Contains(header[0], ";") {
+		header = strings.Split(header[0], ";")
+		cr.Comma = ';'
+	}
+
+	setters := make([]func(tf *packet.TelemetryFrame, v string), len(header))
+	timeColumn := -1
+	mapped := 0
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if dot := strings.LastIndex(name, "."); dot >= 0 {
+			name = name[dot+1:]
+		}
+		if simHubTimeColumns[name] && timeColumn < 0 {
+			timeColumn = i
+			continue
+		}
+		if set, ok := simHubProperties[name]; ok {
+			setters[i] = set
+			mapped++
+		}
+	}
+	if mapped == 0 {
+		return nil, errNoTelemetry
+	}
+
+	var frames []frame
+	next := opts.started()
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		t := next
+		if timeColumn >= 0 && timeColumn < len(row) {
+			if parsed, ok := parseTime(row[timeColumn]); ok {
+				t = parsed
+			}
+		}
+
+		tf := packet.TelemetryFrame{PackageID: 1, Flags: packet.SimFlagCarOnTrack, QuaternionScalar: 1}
+		if len(frames) > 0 {
+			// GT7 numbers its packets at 60 Hz, SimHub may log at a lower rate
+			previous := frames[len(frames)-1]
+			elapsed := t.Sub(frames[0].time).Seconds() * packet.PacketRate
+			tf.PackageID = 1 + int32(math.Round(elapsed))
+			if tf.PackageID <= previous.frame.PackageID {
+				tf.PackageID = previous.frame.PackageID + 1
+			}
+		}
+		for i, v := range row {
+			if i < len(setters) && setters[i] != nil {
+				setters[i](&tf, v)
+			}
+		}
+		frames = append(frames, frame{time: t, frame: tf})
+		next = t.Add(time.Second / packet.PacketRate)
+	}
+
+	id, err := writeFrames(store, session.Metadata{Driver: opts.driver("simhub"), Source: string(FormatSimHubCSV)}, frames)
+	if err != nil {
+		return nil, err
+	}
+	return []string{id}, nil
+}
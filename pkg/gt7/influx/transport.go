@@ -0,0 +1,118 @@
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+type transport interface {
+	Write(lines [][]byte) error
+	Close() error
+}
+
+func newTransport(cfg Config) (transport, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid InfluxDB URL: %v", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &httpTransport{
+			url:    cfg.URL,
+			token:  cfg.Token,
+			client: &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	case "udp":
+		conn, err := net.Dial("udp", u.Host)
+		if err != nil {
+			return nil, fmt.Errorf("InfluxDB UDP connection failed: %v", err)
+		}
+		return &udpTransport{conn: conn}, nil
+	case "file":
+		path := u.Path
+		if u.Host != "" {
+			// file://relative/path
+			path = u.Host + u.Path
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("InfluxDB output file failed: %v", err)
+		}
+		return &fileTransport{file: f}, nil
+	default:
+		return nil, fmt.Errorf("unsupported InfluxDB URL scheme %q, expected http, https, udp or file", u.Scheme)
+	}
+}
+
+type httpTransport struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (t *httpTransport) Write(lines [][]byte) error {
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(bytes.Join(lines, nil)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if t.token != "" {
+		req.Header.Set("Authorization", "Token "+t.token)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("InfluxDB returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+func (t *httpTransport) Close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
+
+type udpTransport struct {
+	conn net.Conn
+}
+
+// Write sends a datagram per line, as InfluxDB parses every datagram on its own. A line with
+// every frame field is about 3 KB, over a typical MTU, so IP fragments it anyway.
+func (t *udpTransport) Write(lines [][]byte) error {
+	for _, line := range lines {
+		if _, err := t.conn.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *udpTransport) Close() error {
+	return t.conn.Close()
+}
+
+type fileTransport struct {
+	file *os.File
+}
+
+func (t *fileTransport) Write(lines [][]byte) error {
+	_, err := t.file.Write(bytes.Join(lines, nil))
+	return err
+}
+
+func (t *fileTransport) Close() error {
+	return t.file.Close()
+}
@@ -0,0 +1,41 @@
+package influx
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPTransport(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() failed: %v", err)
+	}
+	defer listener.Close()
+
+	tr, err := newTransport(Config{URL: "udp://" + listener.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("newTransport() failed: %v", err)
+	}
+	defer tr.Close()
+
+	// A line as long as a real frame line
+	long := append(bytes.Repeat([]byte("x"), 3000), '\n')
+	lines := [][]byte{[]byte("gt7 a=1i 1\n"), long, []byte("gt7 a=2i 2\n")}
+	if err := tr.Write(lines); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+
+	buf := make([]byte, 65536)
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i, want := range lines {
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("datagram %d not received: %v", i, err)
+		}
+		if !bytes.Equal(buf[:n], want) {
+			t.Errorf("datagram %d has %d bytes, want line %d of %d bytes", i, n, i, len(want))
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// Package influx writes decoded telemetry in InfluxDB line protocol, over HTTP, UDP or to a file.
+package influx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+const (
+	DefaultMeasurement   = "gt7"
+	DefaultBatchSize     = 600
+	DefaultFlushInterval = time.Second
+	// Lines kept while the destination is unreachable, older ones are dropped first
+	maxPendingLines = 60 * 60 * 5
+)
+
+// Config of a line protocol writer
+type Config struct {
+	// Destination, one of http(s)://host:8086/api/v2/write?org=o&bucket=b (or the v1
+	// /write?db=d endpoint), udp://host:8089 or file:///path/to/file.lp
+	URL string
+	// Sent as "Authorization: Token <token>" to HTTP destinations
+	Token       string
+	Measurement string
+	// Static tags added to every line, e.g. track and session. Driver and car are always set.
+	Tags          map[string]string
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.Measurement == "" {
+		c.Measurement = DefaultMeasurement
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = DefaultFlushInterval
+	}
+	return c
+}
+
+// Writer batches samples and writes them in line protocol
+type Writer struct {
+	cfg       Config
+	transport transport
+
+	mu      sync.Mutex
+	pending [][]byte
+	dropped uint64
+	flush   chan struct{}
+}
+
+func NewWriter(cfg Config) (*Writer, error) {
+	cfg = cfg.withDefaults()
+	t, err := newTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		cfg:       cfg,
+		transport: t,
+		flush:     make(chan struct{}, 1),
+	}, nil
+}
+
+// Write queues a sample, it never blocks on the destination
+func (w *Writer) Write(s gt7.Sample) {
+	line := w.line(s)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) >= maxPendingLines {
+		w.pending = w.pending[1:]
+		w.dropped++
+	}
+	w.pending = append(w.pending, line)
+
+	if len(w.pending) >= w.cfg.BatchSize {
+		select {
+		case w.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run flushes batches until ctx is done, then flushes what is left and closes the destination
+func (w *Writer) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+	defer w.transport.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.flushPending()
+			return
+		case <-ticker.C:
+			w.flushPending()
+		case <-w.flush:
+			w.flushPending()
+		}
+	}
+}
+
+func (w *Writer) flushPending() {
+	w.mu.Lock()
+	lines := w.pending
+	w.pending = nil
+	dropped := w.dropped
+	w.dropped = 0
+	w.mu.Unlock()
+
+	if dropped > 0 {
+		log.DefaultLogger.Warn("InfluxDB writer dropped lines", "count", dropped)
+	}
+
+	for len(lines) > 0 {
+		n := len(lines)
+		if n > w.cfg.BatchSize {
+			n = w.cfg.BatchSize
+		}
+
+		if err := w.transport.Write(lines[:n]); err != nil {
+			log.DefaultLogger.Error("InfluxDB write failed", "error", err)
+			w.requeue(lines)
+			return
+		}
+		lines = lines[n:]
+	}
+}
+
+// requeue puts lines that failed to be written back in front of the new ones
+func (w *Writer) requeue(lines [][]byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = append(lines, w.pending...)
+	if over := len(w.pending) - maxPendingLines; over > 0 {
+		w.pending = w.pending[over:]
+		w.dropped += uint64(over)
+	}
+}
+
+// line formats a sample as measurement,tags fields timestamp
+func (w *Writer) line(s gt7.Sample) []byte {
+	tags := map[string]string{
+		"driver": s.Driver,
+		"car":    strconv.Itoa(int(s.Frame.CarID)),
+	}
+	for k, v := range w.cfg.Tags {
+		if v != "" {
+			tags[k] = v
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString(escape(w.cfg.Measurement, ", "))
+
+	tagKeys := make([]string, 0, len(tags))
+	for k := range tags {
+		tagKeys = append(tagKeys, k)
+	}
+	sort.Strings(tagKeys)
+	for _, k := range tagKeys {
+		if tags[k] == "" {
+			continue
+		}
+		fmt.Fprintf(&b, ",%s=%s", escape(k, ", ="), escape(tags[k], ", ="))
+	}
+
+	frame := reflect.ValueOf(s.Frame)
+	separator := byte(' ')
+	for _, f := range frameFields {
+		value, ok := fieldValue(frame.FieldByIndex(f.Index))
+		if !ok {
+			continue
+		}
+		b.WriteByte(separator)
+		separator = ','
+		b.WriteString(escape(f.Name, ", ="))
+		b.WriteByte('=')
+		b.WriteString(value)
+	}
+
+	timestamp := s.Received
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	fmt.Fprintf(&b, " %d\n", timestamp.UnixNano())
+
+	return b.Bytes()
+}
+
+// frameFields are the TelemetryFrame fields in name order, the order of the fields of a line
+var frameFields = sortedFrameFields()
+
+func sortedFrameFields() []reflect.StructField {
+	t := reflect.TypeOf(packet.TelemetryFrame{})
+	fields := make([]reflect.StructField, t.NumField())
+	for i := range fields {
+		fields[i] = t.Field(i)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+// fieldValue formats a field value with its line protocol type, integers with the i suffix.
+// Line protocol has no NaN or infinity, such floats are left out.
+func fieldValue(v reflect.Value) (string, bool) {
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10) + "i", true
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return strconv.FormatUint(v.Uint(), 10) + "i", true
+	case reflect.Float32:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", false
+		}
+		return strconv.FormatFloat(f, 'g', -1, 32), true
+	}
+	panic(fmt.Sprintf("unsupported TelemetryFrame field of type %s", v.Type()))
+}
+
+func escape(s string, chars string) string {
+	if !strings.ContainsAny(s, chars+"\\") {
+		return s
+	}
+
+	var b strings.Builder
+	for _, r := range s {
+		if r == '\\' || strings.ContainsRune(chars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,163 @@
+package influx
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+)
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		s, chars string
+		want     string
+	}{
+		{"gt7", ", ", "gt7"},
+		{"my measurement,1", ", ", `my\ measurement\,1`},
+		// Equal signs are only escaped in tags and field keys
+		{"a=b", ", ", "a=b"},
+		{"Spa, Endurance=GP", ", =", `Spa\,\ Endurance\=GP`},
+		{`C:\laps`, ", =", `C:\\laps`},
+	}
+	for _, tt := range tests {
+		if got := escape(tt.s, tt.chars); got != tt.want {
+			t.Errorf("escape(%q, %q) = %q, want %q", tt.s, tt.chars, got, tt.want)
+		}
+	}
+}
+
+func TestLine(t *testing.T) {
+	s := gt7.Sample{Driver: "Jane Doe", Received: time.Unix(1700000000, 500)}
+	s.Frame.CarID = 3359
+	s.Frame.PackageID = 1234
+	s.Frame.LastLap = 83456
+	s.Frame.CurrentGear = 3
+	s.Frame.CarSpeed = 187.5
+	s.Frame.InRace = true
+	s.Frame.Boost = float32(math.NaN())
+
+	w := &Writer{cfg: Config{Measurement: "gt7 laps", Tags: map[string]string{"track": "Spa", "session": ""}}.withDefaults()}
+	line := string(w.line(s))
+
+	const prefix = `gt7\ laps,car=3359,driver=Jane\ Doe,track=Spa `
+	if !strings.HasPrefix(line, prefix) {
+		t.Fatalf("line %q doesn't start with %q", line, prefix)
+	}
+	if suffix := " 1700000000000000500\n"; !strings.HasSuffix(line, suffix) {
+		t.Errorf("line %q doesn't end with %q", line, suffix)
+	}
+	fields := strings.Split(strings.TrimSuffix(strings.TrimPrefix(line, prefix), " 1700000000000000500\n"), ",")
+	values := map[string]string{}
+	var previous string
+	for _, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			t.Fatalf("field %q isn't a key and value", field)
+		}
+		if kv[0] < previous {
+			t.Errorf("field %s follows %s, want fields in name order", kv[0], previous)
+		}
+		previous = kv[0]
+		values[kv[0]] = kv[1]
+	}
+
+	want := map[string]string{
+		"PackageID":   "1234i",
+		"LastLap":     "83456i",
+		"CarID":       "3359i",
+		"CurrentGear": "3i",
+		"Flags":       "0i",
+		"CarSpeed":    "187.5",
+		"Throttle":    "0",
+		"InRace":      "true",
+		"IsPaused":    "false",
+	}
+	for name, value := range want {
+		if got, ok := values[name]; got != value {
+			t.Errorf("%s = %q (present: %v), want %q", name, got, ok, value)
+		}
+	}
+	if got, ok := values["Boost"]; ok {
+		t.Errorf("Boost = %q, want NaN left out", got)
+	}
+}
+
+// recorder is a transport that records the batches it is given, failing when err is set
+type recorder struct {
+	batches []int
+	lines   [][]byte
+	err     error
+}
+
+func (r *recorder) Write(lines [][]byte) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.batches = append(r.batches, len(lines))
+	r.lines = append(r.lines, lines...)
+	return nil
+}
+
+func (r *recorder) Close() error {
+	return nil
+}
+
+func TestBatching(t *testing.T) {
+	r := &recorder{}
+	w := &Writer{cfg: Config{BatchSize: 2}.withDefaults(), transport: r, flush: make(chan struct{}, 1)}
+	sample := func(id int32) gt7.Sample {
+		s := gt7.Sample{Driver: "driver", Received: time.Unix(1700000000, 0)}
+		s.Frame.PackageID = id
+		return s
+	}
+
+	w.Write(sample(1))
+	select {
+	case <-w.flush:
+		t.Fatal("flush requested before a full batch")
+	default:
+	}
+	w.Write(sample(2))
+	select {
+	case <-w.flush:
+	default:
+		t.Fatal("no flush requested for a full batch")
+	}
+
+	// Lines are kept while the destination fails, and written in order once it is back
+	r.err = errors.New("unreachable")
+	w.flushPending()
+	w.Write(sample(3))
+	if len(w.pending) != 3 || len(r.lines) != 0 {
+		t.Fatalf("%d lines pending and %d written after a failure, want 3 and 0", len(w.pending), len(r.lines))
+	}
+
+	r.err = nil
+	w.Write(sample(4))
+	w.Write(sample(5))
+	w.flushPending()
+	if got := r.batches; len(got) != 3 || got[0] != 2 || got[1] != 2 || got[2] != 1 {
+		t.Errorf("batches = %v, want [2 2 1]", got)
+	}
+	for i, line := range r.lines {
+		if want := "PackageID=" + string(rune('1'+i)) + "i"; !strings.Contains(string(line), want) {
+			t.Errorf("line %d = %q, want %s", i, line, want)
+		}
+	}
+	if len(w.pending) != 0 {
+		t.Errorf("%d lines pending after a flush", len(w.pending))
+	}
+}
+
+func TestPendingLimit(t *testing.T) {
+	w := &Writer{cfg: Config{BatchSize: maxPendingLines + 1}.withDefaults(), transport: &recorder{}, flush: make(chan struct{}, 1)}
+	for i := 0; i < maxPendingLines+10; i++ {
+		w.Write(gt7.Sample{Driver: "driver", Received: time.Unix(1700000000, 0)})
+	}
+	if len(w.pending) != maxPendingLines || w.dropped != 10 {
+		t.Errorf("%d lines pending and %d dropped, want %d and 10", len(w.pending), w.dropped, maxPendingLines)
+	}
+}
@@ -0,0 +1,209 @@
+package gt7
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Driver name used for datagrams coming from an address that isn't a configured console
+const UnknownDriver = "unknown"
+
+// DriverStats counts what the telemetry server received from a single console.
+// Fields are updated atomically and must stay first in the struct for 64-bit alignment on ARM.
+type DriverStats struct {
+	Datagrams       uint64
+	Bytes           uint64
+	DecodeFailures  uint64
+	MagicMismatches uint64
+	// Packets missing according to PackageID gaps
+	PacketsLost uint64
+	Duplicates  uint64
+	Reordered   uint64
+	// Frames dropped by streams to stay at 60 Hz
+	FramesDropped   uint64
+	HeartbeatErrors uint64
+	RelayErrors     uint64
+	// Latency between a datagram being received and its frame being sent to Grafana
+	LatencyCount uint64
+	LatencySum   uint64
+	LastLatency  uint64
+}
+
+func (s *DriverStats) snapshot() DriverStats {
+	return DriverStats{
+		Datagrams:       atomic.LoadUint64(&s.Datagrams),
+		Bytes:           atomic.LoadUint64(&s.Bytes),
+		DecodeFailures:  atomic.LoadUint64(&s.DecodeFailures),
+		MagicMismatches: atomic.LoadUint64(&s.MagicMismatches),
+		PacketsLost:     atomic.LoadUint64(&s.PacketsLost),
+		Duplicates:      atomic.LoadUint64(&s.Duplicates),
+		Reordered:       atomic.LoadUint64(&s.Reordered),
+		FramesDropped:   atomic.LoadUint64(&s.FramesDropped),
+		HeartbeatErrors: atomic.LoadUint64(&s.HeartbeatErrors),
+		RelayErrors:     atomic.LoadUint64(&s.RelayErrors),
+		LatencyCount:    atomic.LoadUint64(&s.LatencyCount),
+		LatencySum:      atomic.LoadUint64(&s.LatencySum),
+		LastLatency:     atomic.LoadUint64(&s.LastLatency),
+	}
+}
+
+// MeanLatency returns the average end-to-end latency
+func (s DriverStats) MeanLatency() time.Duration {
+	if s.LatencyCount == 0 {
+		return 0
+	}
+	return time.Duration(s.LatencySum / s.LatencyCount)
+}
+
+// Stats holds the counters of every driver and exposes them as Prometheus metrics
+type Stats struct {
+	mu      sync.Mutex
+	drivers map[string]*DriverStats
+	descs   statsDescs
+	latency *prometheus.HistogramVec
+}
+
+// DefaultStats is used by servers whose configuration doesn't set Stats
+var DefaultStats = NewStats()
+
+func NewStats() *Stats {
+	return newStats(nil)
+}
+
+// NewDatasourceStats creates the counters of a Grafana datasource, whose metrics are labelled
+// with its UID so that the drivers of several datasources don't add up
+func NewDatasourceStats(uid string) *Stats {
+	return newStats(prometheus.Labels{"datasource": uid})
+}
+
+func newStats(labels prometheus.Labels) *Stats {
+	return &Stats{
+		drivers: map[string]*DriverStats{},
+		descs:   newStatsDescs(labels),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   "gt7",
+			Name:        "frame_latency_seconds",
+			Help:        "Time between a datagram being received and its frame being streamed.",
+			Buckets:     []float64{.0005, .001, .0025, .005, .01, .025, .05, .1},
+			ConstLabels: labels,
+		}, []string{"driver"}),
+	}
+}
+
+// Driver returns the counters of a driver, creating them on first use
+func (s *Stats) Driver(name string) *DriverStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ds, ok := s.drivers[name]
+	if !ok {
+		ds = &DriverStats{}
+		s.drivers[name] = ds
+	}
+	return ds
+}
+
+// Snapshot returns a copy of the counters of every driver
+func (s *Stats) Snapshot() map[string]DriverStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make(map[string]DriverStats, len(s.drivers))
+	for name, ds := range s.drivers {
+		snapshot[name] = ds.snapshot()
+	}
+	return snapshot
+}
+
+// Drivers returns the sorted names of the drivers that have counters
+func (s *Stats) Drivers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.drivers))
+	for name := range s.drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Stats) addDatagram(driver string, size int) {
+	ds := s.Driver(driver)
+	atomic.AddUint64(&ds.Datagrams, 1)
+	atomic.AddUint64(&ds.Bytes, uint64(size))
+}
+
+// AddFramesDropped counts frames a stream didn't send
+func (s *Stats) AddFramesDropped(driver string, n uint64) {
+	atomic.AddUint64(&s.Driver(driver).FramesDropped, n)
+}
+
+// ObserveLatency records the end-to-end latency of a frame received at the given time
+func (s *Stats) ObserveLatency(driver string, received time.Time) {
+	latency := time.Since(received)
+	ds := s.Driver(driver)
+	atomic.AddUint64(&ds.LatencyCount, 1)
+	atomic.AddUint64(&ds.LatencySum, uint64(latency))
+	atomic.StoreUint64(&ds.LastLatency, uint64(latency))
+	s.latency.WithLabelValues(driver).Observe(latency.Seconds())
+}
+
+type statsDescs struct {
+	datagrams, bytes, decodeFailures, magicMismatches, packetsLost     *prometheus.Desc
+	duplicates, reordered, framesDropped, heartbeatErrors, relayErrors *prometheus.Desc
+}
+
+func newStatsDescs(labels prometheus.Labels) statsDescs {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(name, help, []string{"driver"}, labels)
+	}
+	return statsDescs{
+		datagrams:       desc("gt7_datagrams_received_total", "UDP datagrams received."),
+		bytes:           desc("gt7_bytes_received_total", "Bytes received over UDP."),
+		decodeFailures:  desc("gt7_decode_failures_total", "Datagrams that could not be decoded."),
+		magicMismatches: desc("gt7_magic_mismatches_total", "Datagrams with a wrong magic number after decryption."),
+		packetsLost:     desc("gt7_packets_lost_total", "Packets missing according to PackageID gaps."),
+		duplicates:      desc("gt7_packets_duplicated_total", "Packets received twice."),
+		reordered:       desc("gt7_packets_reordered_total", "Packets received after a newer one."),
+		framesDropped:   desc("gt7_frames_dropped_total", "Frames dropped by streams to stay at 60 Hz."),
+		heartbeatErrors: desc("gt7_heartbeat_errors_total", "Heartbeats that could not be sent."),
+		relayErrors:     desc("gt7_relay_errors_total", "Datagrams that could not be relayed downstream."),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (s *Stats) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.descs.datagrams
+	ch <- s.descs.bytes
+	ch <- s.descs.decodeFailures
+	ch <- s.descs.magicMismatches
+	ch <- s.descs.packetsLost
+	ch <- s.descs.duplicates
+	ch <- s.descs.reordered
+	ch <- s.descs.framesDropped
+	ch <- s.descs.heartbeatErrors
+	ch <- s.descs.relayErrors
+	s.latency.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (s *Stats) Collect(ch chan<- prometheus.Metric) {
+	for name, ds := range s.Snapshot() {
+		ch <- prometheus.MustNewConstMetric(s.descs.datagrams, prometheus.CounterValue, float64(ds.Datagrams), name)
+		ch <- prometheus.MustNewConstMetric(s.descs.bytes, prometheus.CounterValue, float64(ds.Bytes), name)
+		ch <- prometheus.MustNewConstMetric(s.descs.decodeFailures, prometheus.CounterValue, float64(ds.DecodeFailures), name)
+		ch <- prometheus.MustNewConstMetric(s.descs.magicMismatches, prometheus.CounterValue, float64(ds.MagicMismatches), name)
+		ch <- prometheus.MustNewConstMetric(s.descs.packetsLost, prometheus.CounterValue, float64(ds.PacketsLost), name)
+		ch <- prometheus.MustNewConstMetric(s.descs.duplicates, prometheus.CounterValue, float64(ds.Duplicates), name)
+		ch <- prometheus.MustNewConstMetric(s.descs.reordered, prometheus.CounterValue, float64(ds.Reordered), name)
+		ch <- prometheus.MustNewConstMetric(s.descs.framesDropped, prometheus.CounterValue, float64(ds.FramesDropped), name)
+		ch <- prometheus.MustNewConstMetric(s.descs.heartbeatErrors, prometheus.CounterValue, float64(ds.HeartbeatErrors), name)
+		ch <- prometheus.MustNewConstMetric(s.descs.relayErrors, prometheus.CounterValue, float64(ds.RelayErrors), name)
+	}
+	s.latency.Collect(ch)
+}
@@ -0,0 +1,47 @@
+package gt7
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDatasourceStats(t *testing.T) {
+	first, second := NewDatasourceStats("first"), NewDatasourceStats("second")
+	first.addDatagram("PlayStation", 100)
+	second.addDatagram("PlayStation", 200)
+	second.addDatagram("PlayStation", 300)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(first)
+	// The same driver in another datasource is another series
+	registry.MustRegister(second)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() failed: %v", err)
+	}
+	want := map[string]float64{"first": 1, "second": 2}
+	found := 0
+	for _, family := range families {
+		if family.GetName() != "gt7_datagrams_received_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["driver"] != "PlayStation" {
+				t.Errorf("driver label = %q, want PlayStation", labels["driver"])
+			}
+			if got := m.GetCounter().GetValue(); got != want[labels["datasource"]] {
+				t.Errorf("datagrams of datasource %q = %v, want %v", labels["datasource"], got, want[labels["datasource"]])
+			}
+			found++
+		}
+	}
+	if found != len(want) {
+		t.Errorf("found %d datagram series, want %d", found, len(want))
+	}
+}
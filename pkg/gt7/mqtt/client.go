@@ -0,0 +1,179 @@
+package mqtt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Minimal MQTT 3.1.1 client: connect, QoS 0 publish, keep alive and disconnect.
+// That is all a telemetry publisher needs, without pulling a full client library.
+
+const (
+	packetConnect    = 0x10
+	packetConnack    = 0x20
+	packetPublish    = 0x30
+	packetPingreq    = 0xC0
+	packetDisconnect = 0xE0
+
+	flagRetain = 0x01
+
+	connectCleanSession = 0x02
+	connectPassword     = 0x40
+	connectUsername     = 0x80
+
+	dialTimeout = 5 * time.Second
+)
+
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+type client struct {
+	conn net.Conn
+
+	mu     sync.Mutex
+	writer *bufio.Writer
+}
+
+// dial connects to a tcp://host:port broker
+func dial(broker string, clientID string, username string, password string, keepAlive time.Duration) (*client, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT broker URL: %v", err)
+	}
+	if u.Scheme != "tcp" && u.Scheme != "mqtt" {
+		return nil, fmt.Errorf("unsupported MQTT broker scheme %q, expected tcp", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "1883")
+	}
+
+	conn, err := net.DialTimeout("tcp", host, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &client{conn: conn, writer: bufio.NewWriter(conn)}
+	if err := c.connect(clientID, username, password, keepAlive); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Nothing but PINGRESP is expected from now on, drain it so the broker never blocks
+	go io.Copy(io.Discard, conn)
+
+	return c, nil
+}
+
+func (c *client) connect(clientID string, username string, password string, keepAlive time.Duration) error {
+	var body []byte
+	body = appendString(body, "MQTT")
+	body = append(body, 4) // protocol level 3.1.1
+
+	flags := byte(connectCleanSession)
+	if username != "" {
+		flags |= connectUsername
+		if password != "" {
+			flags |= connectPassword
+		}
+	}
+	body = append(body, flags)
+	keepAliveSeconds := uint16(keepAlive / time.Second)
+	body = append(body, byte(keepAliveSeconds>>8), byte(keepAliveSeconds))
+
+	body = appendString(body, clientID)
+	if flags&connectUsername != 0 {
+		body = appendString(body, username)
+	}
+	if flags&connectPassword != 0 {
+		body = appendString(body, password)
+	}
+
+	if err := c.write(packetConnect, body); err != nil {
+		return err
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(dialTimeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	connack := make([]byte, 4)
+	if _, err := io.ReadFull(c.conn, connack); err != nil {
+		return fmt.Errorf("reading CONNACK failed: %v", err)
+	}
+	if connack[0] != packetConnack {
+		return fmt.Errorf("unexpected packet 0x%02x instead of CONNACK", connack[0])
+	}
+	if rc := connack[3]; rc != 0 {
+		if msg, ok := connackErrors[rc]; ok {
+			return errors.New("connection refused: " + msg)
+		}
+		return fmt.Errorf("connection refused with code %d", rc)
+	}
+
+	return nil
+}
+
+// publish sends a QoS 0 message
+func (c *client) publish(topic string, payload []byte, retain bool) error {
+	header := byte(packetPublish)
+	if retain {
+		header |= flagRetain
+	}
+
+	body := appendString(make([]byte, 0, 2+len(topic)+len(payload)), topic)
+	body = append(body, payload...)
+
+	return c.write(header, body)
+}
+
+func (c *client) ping() error {
+	return c.write(packetPingreq, nil)
+}
+
+func (c *client) close() error {
+	c.write(packetDisconnect, nil)
+	return c.conn.Close()
+}
+
+func (c *client) write(header byte, body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	c.writer.WriteByte(header)
+	c.writer.Write(remainingLength(len(body)))
+	c.writer.Write(body)
+	return c.writer.Flush()
+}
+
+// remainingLength encodes a length with the MQTT variable byte integer encoding
+func remainingLength(n int) []byte {
+	var out []byte
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if n == 0 {
+			return out
+		}
+	}
+}
+
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
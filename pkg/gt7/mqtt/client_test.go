@@ -0,0 +1,121 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRemainingLength(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7F}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xFF, 0x7F}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097151, []byte{0xFF, 0xFF, 0x7F}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+	}
+	for _, tt := range tests {
+		if got := remainingLength(tt.n); !bytes.Equal(got, tt.want) {
+			t.Errorf("remainingLength(%d) = % x, want % x", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestConnect(t *testing.T) {
+	tests := []struct {
+		name               string
+		username, password string
+		// Return code of the CONNACK
+		code    byte
+		want    []byte
+		invalid bool
+	}{
+		{
+			name: "anonymous",
+			want: []byte{
+				0x10, 0x0F,
+				0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04,
+				0x02,       // clean session
+				0x00, 0x1E, // 30 s keep alive
+				0x00, 0x03, 'g', 't', '7',
+			},
+		},
+		{
+			name:     "username and password",
+			username: "u",
+			password: "p",
+			want: []byte{
+				0x10, 0x15,
+				0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04,
+				0xC2,
+				0x00, 0x1E,
+				0x00, 0x03, 'g', 't', '7',
+				0x00, 0x01, 'u',
+				0x00, 0x01, 'p',
+			},
+		},
+		{
+			name:     "username only",
+			username: "u",
+			want: []byte{
+				0x10, 0x12,
+				0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04,
+				0x82,
+				0x00, 0x1E,
+				0x00, 0x03, 'g', 't', '7',
+				0x00, 0x01, 'u',
+			},
+		},
+		{
+			name:     "refused",
+			username: "u",
+			password: "wrong",
+			code:     4,
+			want: []byte{
+				0x10, 0x19,
+				0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04,
+				0xC2,
+				0x00, 0x1E,
+				0x00, 0x03, 'g', 't', '7',
+				0x00, 0x01, 'u',
+				0x00, 0x05, 'w', 'r', 'o', 'n', 'g',
+			},
+			invalid: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, broker := net.Pipe()
+			defer conn.Close()
+			defer broker.Close()
+
+			received := make(chan []byte, 1)
+			go func() {
+				b := make([]byte, len(tt.want))
+				if _, err := io.ReadFull(broker, b); err != nil {
+					close(received)
+					return
+				}
+				received <- b
+				broker.Write([]byte{packetConnack, 0x02, 0x00, tt.code})
+			}()
+
+			c := &client{conn: conn, writer: bufio.NewWriter(conn)}
+			err := c.connect("gt7", tt.username, tt.password, 30*time.Second)
+			if tt.invalid != (err != nil) {
+				t.Errorf("connect() error = %v, want an error: %v", err, tt.invalid)
+			}
+			if got := <-received; !bytes.Equal(got, tt.want) {
+				t.Errorf("CONNECT = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+// Package mqtt publishes decoded telemetry and discrete events to an MQTT broker.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+const (
+	DefaultTopicPrefix = "gt7"
+	DefaultClientID    = "grafana-gt7"
+	DefaultFrameRate   = 10
+
+	keepAlive         = 30 * time.Second
+	queueSize         = 1024
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// Config of a publisher. Topics are laid out as:
+//
+//	<prefix>/<driver>/telemetry          every value of a frame as a JSON object
+//	<prefix>/<driver>/telemetry/<Field>  a single value, when FieldTopics is set
+//	<prefix>/<driver>/events/<type>      discrete events as JSON, see gt7.Event
+//	<prefix>/<driver>/status             console state, retained
+type Config struct {
+	// tcp://host:1883
+	Broker      string
+	ClientID    string
+	Username    string
+	Password    string
+	TopicPrefix string
+	// Frames published per second and per driver, negative to only publish events
+	FrameRate   float64
+	FieldTopics bool
+}
+
+func (c Config) withDefaults() Config {
+	if c.ClientID == "" {
+		c.ClientID = DefaultClientID
+	}
+	if c.TopicPrefix == "" {
+		c.TopicPrefix = DefaultTopicPrefix
+	}
+	if c.FrameRate == 0 {
+		c.FrameRate = DefaultFrameRate
+	}
+	return c
+}
+
+type message struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+// Publisher queues messages and sends them from Run, reconnecting when the broker goes away
+type Publisher struct {
+	cfg      Config
+	queue    chan message
+	detector *gt7.EventDetector
+	lastSent map[string]time.Time
+}
+
+func NewPublisher(cfg Config) (*Publisher, error) {
+	cfg = cfg.withDefaults()
+	if _, err := url.Parse(cfg.Broker); err != nil {
+		return nil, fmt.Errorf("invalid MQTT broker URL: %v", err)
+	}
+
+	return &Publisher{
+		cfg:      cfg,
+		queue:    make(chan message, queueSize),
+		detector: gt7.NewEventDetector(),
+		lastSent: map[string]time.Time{},
+	}, nil
+}
+
+// Publish queues the events of a sample, and the sample itself within the frame rate.
+// It must be called from a single goroutine.
+func (p *Publisher) Publish(s gt7.Sample) {
+	base := p.cfg.TopicPrefix + "/" + gt7.DriverPath(s.Driver)
+
+	for _, e := range p.detector.Detect(s) {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		p.enqueue(message{topic: base + "/events/" + string(e.Type), payload: payload})
+	}
+
+	if p.cfg.FrameRate < 0 {
+		return
+	}
+	interval := time.Duration(float64(time.Second) / p.cfg.FrameRate)
+	if time.Since(p.lastSent[s.Driver]) < interval {
+		return
+	}
+	p.lastSent[s.Driver] = time.Now()
+
+	values := packet.TelemetryToMap(s.Frame)
+	payload, err := json.Marshal(values)
+	if err == nil {
+		p.enqueue(message{topic: base + "/telemetry", payload: payload})
+	}
+
+	if p.cfg.FieldTopics {
+		for name, value := range values {
+			p.enqueue(message{
+				topic:   base + "/telemetry/" + name,
+				payload: []byte(strconv.FormatFloat(float64(value), 'g', -1, 32)),
+			})
+		}
+	}
+}
+
+// PublishStatus queues a console state change as a retained message
+func (p *Publisher) PublishStatus(st gt7.Status) {
+	if st.Driver == "" {
+		return
+	}
+	p.enqueue(message{
+		topic:   p.cfg.TopicPrefix + "/" + gt7.DriverPath(st.Driver) + "/status",
+		payload: []byte(st.State),
+		retain:  true,
+	})
+}
+
+func (p *Publisher) enqueue(m message) {
+	select {
+	case p.queue <- m:
+	default:
+		// Broker too slow or unreachable, drop rather than stall the telemetry
+	}
+}
+
+// Run sends queued messages until ctx is done
+func (p *Publisher) Run(ctx context.Context) {
+	delay := minReconnectDelay
+
+	for {
+		c, err := dial(p.cfg.Broker, p.cfg.ClientID, p.cfg.Username, p.cfg.Password, keepAlive)
+		if err != nil {
+			log.DefaultLogger.Warn("MQTT connection failed", "broker", p.cfg.Broker, "error", err, "retry", delay.String())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay *= 2
+			if delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+			continue
+		}
+
+		log.DefaultLogger.Info("Connected to MQTT broker", "broker", p.cfg.Broker)
+		delay = minReconnectDelay
+
+		err = p.send(ctx, c)
+		c.close()
+		if err == nil {
+			return
+		}
+		log.DefaultLogger.Warn("MQTT connection lost", "broker", p.cfg.Broker, "error", err)
+	}
+}
+
+// send publishes queued messages on a connection, it returns nil once ctx is done
+func (p *Publisher) send(ctx context.Context, c *client) error {
+	ticker := time.NewTicker(keepAlive / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := c.ping(); err != nil {
+				return err
+			}
+		case m := <-p.queue:
+			if err := c.publish(m.topic, m.payload, m.retain); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package packet
+
+import (
+	"encoding/binary"
+)
+
+// Encode builds the decrypted datagram of a frame, the inverse of what the Decoder reads, so
+// that telemetry imported from other tools can be stored and replayed like captured packets.
+// Derived fields (local velocity, acceleration, G forces, slip ratios, Euler angles) are
+// recomputed by the Decoder and not encoded. Tyre speeds are only kept along with their
+// tyre diameters.
+func Encode(tf *TelemetryFrame) []byte {
+	data := LayoutA.Encode(tf)
+	wheelSpeed := func(tyreSpeed, diameter float32) float32 {
+		if diameter == 0 {
+			return 0
+		}
+		return tyreSpeed / (3.6 * diameter)
+	}
+
+	for _, wheel := range []struct {
+		name                string
+		tyreSpeed, diameter float32
+	}{
+		{"FL", tf.TyreSpeedFL, tf.TyreDiameterFL},
+		{"FR", tf.TyreSpeedFR, tf.TyreDiameterFR},
+		{"RL", tf.TyreSpeedRL, tf.TyreDiameterRL},
+		{"RR", tf.TyreSpeedRR, tf.TyreDiameterRR},
+	} {
+		rps, _ := LayoutA.Field("WheelRPS" + wheel.name)
+		rps.put(data, float64(wheelSpeed(wheel.tyreSpeed, wheel.diameter)))
+	}
+
+	return data
+}
+
+// Encrypt encrypts a decrypted datagram of any format the way GT7 does, seeding the cipher
+// with iv
+func Encrypt(dat []byte, iv uint32) []byte {
+	layout := LayoutFor(len(dat))
+	if layout == nil {
+		layout = LayoutA
+	}
+	seeded := append([]byte(nil), dat...)
+	binary.LittleEndian.PutUint32(seeded[0x40:0x44], iv)
+
+	encrypted := salsa20Dec(seeded, layout.IVMask)
+	// The seed is sent in the clear
+	binary.LittleEndian.PutUint32(encrypted[0x40:0x44], iv)
+
+	return encrypted
+}
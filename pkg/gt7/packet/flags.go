@@ -0,0 +1,38 @@
+package packet
+
+// Simulator flags at 0x8E, as documented by Nenkai
+const (
+	SimFlagCarOnTrack uint16 = 1 << iota
+	SimFlagPaused
+	SimFlagLoadingOrProcessing
+	SimFlagInGear
+	SimFlagHasTurbo
+	SimFlagRevLimiterAlert
+	SimFlagHandBrake
+	SimFlagLights
+	SimFlagHighBeam
+	SimFlagLowBeam
+	SimFlagASM
+	SimFlagTCS
+)
+
+// SimFlagNames maps every known simulator flag to a name
+var SimFlagNames = map[uint16]string{
+	SimFlagCarOnTrack:          "CarOnTrack",
+	SimFlagPaused:              "Paused",
+	SimFlagLoadingOrProcessing: "LoadingOrProcessing",
+	SimFlagInGear:              "InGear",
+	SimFlagHasTurbo:            "HasTurbo",
+	SimFlagRevLimiterAlert:     "RevLimiterAlert",
+	SimFlagHandBrake:           "HandBrake",
+	SimFlagLights:              "Lights",
+	SimFlagHighBeam:            "HighBeam",
+	SimFlagLowBeam:             "LowBeam",
+	SimFlagASM:                 "ASM",
+	SimFlagTCS:                 "TCS",
+}
+
+// HasFlag reports whether a simulator flag is set
+func (tf *TelemetryFrame) HasFlag(flag uint16) bool {
+	return tf.Flags&flag != 0
+}
@@ -0,0 +1,411 @@
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+//go:generate go run ../../../cmd/gt7 layout -docs -o ../../../docs/packet-layout.md
+//go:generate go run ../../../cmd/gt7 layout -options -o ../../../src/gt7Options.ts
+
+// Format is the packet format requested by the heartbeat. Each format extends the previous one.
+type Format string
+
+const (
+	FormatA     Format = "A"
+	FormatB     Format = "B"
+	FormatTilde Format = "~"
+)
+
+// ParseFormat validates a packet format, empty meaning FormatA
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case "", FormatA:
+		return FormatA, nil
+	case FormatB, FormatTilde:
+		return Format(s), nil
+	}
+	return "", fmt.Errorf("unknown packet format %q, expected A, B or ~", s)
+}
+
+// Layout returns the layout of the format
+func (f Format) Layout() *Layout {
+	for _, l := range Layouts {
+		if l.Format == f {
+			return l
+		}
+	}
+	return LayoutA
+}
+
+// Field is a value at a fixed offset of a decrypted datagram. Fields named after a
+// TelemetryFrame field are decoded into it as Raw*Scale + Bias.
+type Field struct {
+	Name   string
+	Offset int
+	// Go type of the raw value: float32, int32, uint32, int16, uint16 or uint8
+	Type string
+	// Scale applied to the raw value, 0 meaning 1
+	Scale float64
+	Bias  float64
+	// Bits of the raw value holding the field, for values packed together. A zero Width
+	// is the whole value.
+	Shift uint
+	Width uint
+	// Label shown in the query editor, the name when empty
+	Label string
+	Doc   string
+
+	// Index of the TelemetryFrame field, -1 when the value isn't decoded
+	frame int
+}
+
+// Layout describes a packet format. Decoding, encoding, the field docs and the query editor
+// field list are all derived from it, so a newly understood value is a single Field here,
+// plus a TelemetryFrame field to stream it.
+type Layout struct {
+	Format Format
+	Size   int
+	// XORed with the IV to build the salsa20 nonce
+	IVMask uint32
+	// Fields in offset order
+	Fields []Field
+}
+
+// UnknownField names the bytes listed in a layout whose meaning is unknown
+const UnknownField = "Unknown"
+
+// Fields of the "A" format, as documented by Nenkai
+var fieldsA = []Field{
+	{Name: "Magic", Offset: 0x00, Type: "uint32", Doc: "0x47375330 (\"G7S0\") once decrypted"},
+	{Name: "PositionX", Offset: 0x04, Type: "float32", Doc: "Position on the track"},
+	{Name: "PositionY", Offset: 0x08, Type: "float32", Doc: "Position on the track, vertical"},
+	{Name: "PositionZ", Offset: 0x0C, Type: "float32", Doc: "Position on the track"},
+	{Name: "VelocityX", Offset: 0x10, Type: "float32", Doc: "Velocity in track coordinates"},
+	{Name: "VelocityY", Offset: 0x14, Type: "float32", Doc: "Velocity in track coordinates"},
+	{Name: "VelocityZ", Offset: 0x18, Type: "float32", Doc: "Velocity in track coordinates"},
+	{Name: "RotationPitch", Offset: 0x1C, Type: "float32", Doc: "Rotation quaternion, X component"},
+	{Name: "RotationYaw", Offset: 0x20, Type: "float32", Doc: "Rotation quaternion, Y component"},
+	{Name: "RotationRoll", Offset: 0x24, Type: "float32", Doc: "Rotation quaternion, Z component"},
+	{Name: "QuaternionScalar", Offset: 0x28, Type: "float32", Doc: "Rotation quaternion, scalar component"},
+	{Name: "AngularVelocityX", Offset: 0x2C, Type: "float32", Doc: "Angular velocity"},
+	{Name: "AngularVelocityY", Offset: 0x30, Type: "float32", Doc: "Angular velocity"},
+	{Name: "AngularVelocityZ", Offset: 0x34, Type: "float32", Doc: "Angular velocity"},
+	{Name: "RideHeight", Offset: 0x38, Type: "float32", Scale: 1000, Doc: "Body height, sent in m"},
+	{Name: "RPM", Offset: 0x3C, Type: "float32", Doc: "Engine speed"},
+	{Name: "IV", Offset: 0x40, Type: "uint32", Doc: "Salsa20 IV seed, sent in the clear"},
+	{Name: "CurrentFuel", Offset: 0x44, Type: "float32", Doc: "Fuel left, 100 for electric cars"},
+	{Name: "FuelCapacity", Offset: 0x48, Type: "float32", Doc: "Fuel capacity, 100 for electric cars"},
+	{Name: "CarSpeed", Offset: 0x4C, Type: "float32", Scale: 3.6, Doc: "Speed, sent in m/s"},
+	{Name: "Boost", Offset: 0x50, Type: "float32", Bias: -1, Doc: "Turbo boost, sent as absolute pressure"},
+	{Name: "OilPressure", Offset: 0x54, Type: "float32", Doc: "Oil pressure"},
+	{Name: "WaterTemp", Offset: 0x58, Type: "float32", Doc: "Water temperature"},
+	{Name: "OilTemp", Offset: 0x5C, Type: "float32", Doc: "Oil temperature"},
+	{Name: "TyreTempFL", Offset: 0x60, Type: "float32", Doc: "Tyre surface temperature"},
+	{Name: "TyreTempFR", Offset: 0x64, Type: "float32", Doc: "Tyre surface temperature"},
+	{Name: "TyreTempRL", Offset: 0x68, Type: "float32", Doc: "Tyre surface temperature"},
+	{Name: "TyreTempRR", Offset: 0x6C, Type: "float32", Doc: "Tyre surface temperature"},
+	{Name: "PackageID", Offset: 0x70, Type: "int32", Doc: "Packet counter, incremented 60 times per second"},
+	{Name: "CurrentLap", Offset: 0x74, Type: "int16", Doc: "Current lap, 0 before the start"},
+	{Name: "TotalLaps", Offset: 0x76, Type: "int16", Doc: "Laps of the race, 0 in time trials"},
+	{Name: "BestLap", Offset: 0x78, Type: "int32", Doc: "Best lap time, -1 when none"},
+	{Name: "LastLap", Offset: 0x7C, Type: "int32", Doc: "Last lap time, -1 when none"},
+	{Name: "TimeOfDay", Offset: 0x80, Type: "int32", Doc: "Time of day on the track, in ms"},
+	{Name: "CurrentPosition", Offset: 0x84, Type: "int16", Doc: "Position before the start of a race, -1 once started"},
+	{Name: "TotalPositions", Offset: 0x86, Type: "int16", Doc: "Number of cars before the start of a race, -1 once started"},
+	{Name: "RPMRevWarning", Offset: 0x88, Type: "uint16", Doc: "RPM at which the shift lights start"},
+	{Name: "RPMRevLimiter", Offset: 0x8A, Type: "uint16", Doc: "RPM at which the shift lights flash"},
+	{Name: "EstimatedTopSpeed", Offset: 0x8C, Type: "int16", Doc: "Top speed estimated from the gearing"},
+	{Name: "Flags", Offset: 0x8E, Type: "uint16", Doc: "Simulator flags, see SimFlagCarOnTrack"},
+	{Name: "InRace", Offset: 0x8E, Type: "uint16", Width: 1, Doc: "Car on track flag"},
+	{Name: "IsPaused", Offset: 0x8E, Type: "uint16", Shift: 1, Width: 1, Doc: "Paused flag"},
+	{Name: "CurrentGear", Offset: 0x90, Type: "uint8", Width: 4, Doc: "Current gear, 0 for reverse"},
+	{Name: "SuggestedGear", Offset: 0x90, Type: "uint8", Shift: 4, Width: 4, Doc: "Suggested gear, 15 when none"},
+	{Name: "Throttle", Offset: 0x91, Type: "uint8", Scale: 1 / 2.55, Doc: "Throttle, sent as 0-255"},
+	{Name: "Brake", Offset: 0x92, Type: "uint8", Scale: 1 / 2.55, Doc: "Brake, sent as 0-255"},
+	{Name: UnknownField, Offset: 0x93, Type: "uint8"},
+	{Name: "RoadPlaneX", Offset: 0x94, Type: "float32", Doc: "Normal of the road plane under the car"},
+	{Name: "RoadPlaneY", Offset: 0x98, Type: "float32", Doc: "Normal of the road plane under the car"},
+	{Name: "RoadPlaneZ", Offset: 0x9C, Type: "float32", Doc: "Normal of the road plane under the car"},
+	{Name: "RoadPlaneDistance", Offset: 0xA0, Type: "float32", Doc: "Distance of the road plane"},
+	{Name: "WheelRPSFL", Offset: 0xA4, Type: "float32", Doc: "Wheel speed in rad/s, negative when moving forward"},
+	{Name: "WheelRPSFR", Offset: 0xA8, Type: "float32", Doc: "Wheel speed in rad/s, negative when moving forward"},
+	{Name: "WheelRPSRL", Offset: 0xAC, Type: "float32", Doc: "Wheel speed in rad/s, negative when moving forward"},
+	{Name: "WheelRPSRR", Offset: 0xB0, Type: "float32", Doc: "Wheel speed in rad/s, negative when moving forward"},
+	{Name: "TyreDiameterFL", Offset: 0xB4, Type: "float32", Doc: "Tyre radius"},
+	{Name: "TyreDiameterFR", Offset: 0xB8, Type: "float32", Doc: "Tyre radius"},
+	{Name: "TyreDiameterRL", Offset: 0xBC, Type: "float32", Doc: "Tyre radius"},
+	{Name: "TyreDiameterRR", Offset: 0xC0, Type: "float32", Doc: "Tyre radius"},
+	{Name: "SuspensionFL", Offset: 0xC4, Type: "float32", Doc: "Suspension height"},
+	{Name: "SuspensionFR", Offset: 0xC8, Type: "float32", Doc: "Suspension height"},
+	{Name: "SuspensionRL", Offset: 0xCC, Type: "float32", Doc: "Suspension height"},
+	{Name: "SuspensionRR", Offset: 0xD0, Type: "float32", Doc: "Suspension height"},
+	{Name: "Clutch", Offset: 0xF4, Type: "float32", Doc: "Clutch pedal, 0 to 1"},
+	{Name: "ClutchEngaged", Offset: 0xF8, Type: "float32", Doc: "Clutch engagement, 0 to 1"},
+	{Name: "RPMAfterClutch", Offset: 0xFC, Type: "float32", Doc: "RPM on the gearbox side of the clutch"},
+	{Name: "TransmissionTopSpeed", Offset: 0x100, Type: "float32", Doc: "Top speed ratio of the gearbox"},
+	{Name: "Gear1", Offset: 0x104, Type: "float32", Doc: "Gear ratio"},
+	{Name: "Gear2", Offset: 0x108, Type: "float32", Doc: "Gear ratio"},
+	{Name: "Gear3", Offset: 0x10C, Type: "float32", Doc: "Gear ratio"},
+	{Name: "Gear4", Offset: 0x110, Type: "float32", Doc: "Gear ratio"},
+	{Name: "Gear5", Offset: 0x114, Type: "float32", Doc: "Gear ratio"},
+	{Name: "Gear6", Offset: 0x118, Type: "float32", Doc: "Gear ratio"},
+	{Name: "Gear7", Offset: 0x11C, Type: "float32", Doc: "Gear ratio"},
+	{Name: "Gear8", Offset: 0x120, Type: "float32", Doc: "Gear ratio, 0 when the gearbox has fewer gears"},
+	{Name: "CarID", Offset: 0x124, Type: "int32", Doc: "Car code"},
+}
+
+// Fields added by the "B" format
+var fieldsB = []Field{
+	{Name: "WheelRotation", Offset: 0x128, Type: "float32", Doc: "Steering wheel angle, in radians"},
+	{Name: UnknownField, Offset: 0x12C, Type: "float32"},
+	{Name: "Sway", Offset: 0x130, Type: "float32", Doc: "Lateral acceleration"},
+	{Name: "Heave", Offset: 0x134, Type: "float32", Doc: "Vertical acceleration"},
+	{Name: "Surge", Offset: 0x138, Type: "float32", Doc: "Longitudinal acceleration"},
+}
+
+// Fields added by the "~" format
+var fieldsTilde = []Field{
+	{Name: "ThrottleFiltered", Offset: 0x13C, Type: "uint8", Scale: 1 / 2.55, Label: "Throttle (filtered)", Doc: "Throttle after driving aids, sent as 0-255"},
+	{Name: "BrakeFiltered", Offset: 0x13D, Type: "uint8", Scale: 1 / 2.55, Label: "Brake (filtered)", Doc: "Brake after driving aids, sent as 0-255"},
+	{Name: UnknownField, Offset: 0x13E, Type: "uint8"},
+	{Name: UnknownField, Offset: 0x13F, Type: "uint8"},
+	{Name: "TorqueVectorFL", Offset: 0x140, Type: "float32", Doc: "Torque vectoring"},
+	{Name: "TorqueVectorFR", Offset: 0x144, Type: "float32", Doc: "Torque vectoring"},
+	{Name: "TorqueVectorRL", Offset: 0x148, Type: "float32", Doc: "Torque vectoring"},
+	{Name: "TorqueVectorRR", Offset: 0x14C, Type: "float32", Doc: "Torque vectoring"},
+	{Name: "EnergyRecovery", Offset: 0x150, Type: "float32", Doc: "Energy recovered by hybrid and electric cars"},
+	{Name: UnknownField, Offset: 0x154, Type: "float32"},
+}
+
+var (
+	LayoutA     = newLayout(FormatA, 0x128, 0xDEADBEAF, fieldsA)
+	LayoutB     = newLayout(FormatB, 0x13C, 0xDEADBEEF, fieldsA, fieldsB)
+	LayoutTilde = newLayout(FormatTilde, 0x158, 0x55FABB4F, fieldsA, fieldsB, fieldsTilde)
+
+	// Layouts of every format, smallest first
+	Layouts = []*Layout{LayoutA, LayoutB, LayoutTilde}
+)
+
+// DerivedFields are the TelemetryFrame fields computed by the Decoder rather than read
+// from the datagram
+var DerivedFields = []Field{
+	{Name: "TyreSpeedFL", Doc: "Wheel speed times tyre radius"},
+	{Name: "TyreSpeedFR", Doc: "Wheel speed times tyre radius"},
+	{Name: "TyreSpeedRL", Doc: "Wheel speed times tyre radius"},
+	{Name: "TyreSpeedRR", Doc: "Wheel speed times tyre radius"},
+	{Name: "TyreSlipRatioFL", Doc: "Tyre speed over car speed, -1 when stopped"},
+	{Name: "TyreSlipRatioFR", Doc: "Tyre speed over car speed, -1 when stopped"},
+	{Name: "TyreSlipRatioRL", Doc: "Tyre speed over car speed, -1 when stopped"},
+	{Name: "TyreSlipRatioRR", Doc: "Tyre speed over car speed, -1 when stopped"},
+	{Name: "TimeOnTrack", Doc: "Not sent by GT7, always 0"},
+	{Name: "LocalVelocityX", Label: "Local Velocity X", Doc: "Velocity in car coordinates"},
+	{Name: "LocalVelocityY", Label: "Local Velocity Y", Doc: "Velocity in car coordinates"},
+	{Name: "LocalVelocityZ", Label: "Local Velocity Z", Doc: "Velocity in car coordinates"},
+	{Name: "AccelerationX", Label: "Acceleration X", Doc: "Change of the local velocity since the previous packet"},
+	{Name: "AccelerationY", Label: "Acceleration Y", Doc: "Change of the local velocity since the previous packet"},
+	{Name: "AccelerationZ", Label: "Acceleration Z", Doc: "Change of the local velocity since the previous packet"},
+	{Name: "GForceX", Label: "G-Force X", Doc: "Acceleration in g"},
+	{Name: "GForceY", Label: "G-Force Y", Doc: "Acceleration in g"},
+	{Name: "GForceZ", Label: "G-Force Z", Doc: "Acceleration in g"},
+	{Name: "Roll", Doc: "Euler angle of the rotation quaternion"},
+	{Name: "Pitch", Doc: "Euler angle of the rotation quaternion"},
+	{Name: "Yaw", Doc: "Euler angle of the rotation quaternion"},
+	{Name: "YawRateExpected", Label: "Expected Yaw Rate", Doc: "Lateral acceleration over speed, the yaw rate of a car following its path, signed like AngularVelocityY"},
+	{Name: "HandlingBalance", Label: "Handling Balance", Doc: "Yaw rate in the direction of the path minus the path turn rate, positive when oversteering and negative when understeering"},
+	{Name: "PacketsLost", Doc: "Packets missing between the previous frame and this one"},
+	{Name: "PacketFlags", Doc: "Sequence flags, see FlagGap"},
+}
+
+func newLayout(format Format, size int, ivMask uint32, parts ...[]Field) *Layout {
+	l := &Layout{Format: format, Size: size, IVMask: ivMask}
+	frameType := reflect.TypeOf(TelemetryFrame{})
+	for _, part := range parts {
+		for _, f := range part {
+			f.frame = -1
+			if sf, ok := frameType.FieldByName(f.Name); ok {
+				f.frame = sf.Index[0]
+			}
+			l.Fields = append(l.Fields, f)
+		}
+	}
+	return l
+}
+
+// LayoutFor returns the largest layout a datagram of the given size holds, nil when it is
+// too short for any
+func LayoutFor(size int) *Layout {
+	var found *Layout
+	for _, l := range Layouts {
+		if size >= l.Size {
+			found = l
+		}
+	}
+	return found
+}
+
+// Field returns the field of the layout with the given name
+func (l *Layout) Field(name string) (Field, bool) {
+	for _, f := range l.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// Size returns the size in bytes of the raw value
+func (f Field) Size() int {
+	switch f.Type {
+	case "uint8":
+		return 1
+	case "int16", "uint16":
+		return 2
+	}
+	return 4
+}
+
+// Decoded reports whether the field is decoded into a TelemetryFrame field
+func (f Field) Decoded() bool {
+	return f.frame >= 0
+}
+
+// Value reads the raw value of the field from a decrypted datagram
+func (f Field) Value(data []byte) interface{} {
+	b := data[f.Offset : f.Offset+f.Size()]
+	switch f.Type {
+	case "float32":
+		return math.Float32frombits(binary.LittleEndian.Uint32(b))
+	case "int32":
+		return int32(binary.LittleEndian.Uint32(b))
+	case "uint32":
+		return binary.LittleEndian.Uint32(b)
+	case "int16":
+		return int16(binary.LittleEndian.Uint16(b))
+	case "uint16":
+		return binary.LittleEndian.Uint16(b)
+	}
+	return b[0]
+}
+
+// Float reads the field from a decrypted datagram, with its bits extracted and scaled
+func (f Field) Float(data []byte) float64 {
+	var v float64
+	switch raw := f.Value(data).(type) {
+	case float32:
+		v = float64(raw)
+	case int32:
+		v = float64(raw)
+	case int16:
+		v = float64(raw)
+	default:
+		u := f.unsigned(raw)
+		if f.Width > 0 {
+			u = u >> f.Shift & (1<<f.Width - 1)
+		}
+		v = float64(u)
+	}
+
+	if f.Scale != 0 {
+		v *= f.Scale
+	}
+	return v + f.Bias
+}
+
+func (f Field) unsigned(raw interface{}) uint64 {
+	switch raw := raw.(type) {
+	case uint32:
+		return uint64(raw)
+	case uint16:
+		return uint64(raw)
+	case uint8:
+		return uint64(raw)
+	}
+	return 0
+}
+
+// decode sets the TelemetryFrame fields the layout maps
+func (l *Layout) decode(data []byte, tf *TelemetryFrame) {
+	frame := reflect.ValueOf(tf).Elem()
+	for _, f := range l.Fields {
+		if f.frame < 0 {
+			continue
+		}
+
+		v := f.Float(data)
+		fv := frame.Field(f.frame)
+		switch fv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			fv.SetFloat(v)
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			fv.SetInt(int64(v))
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			fv.SetUint(uint64(v))
+		case reflect.Bool:
+			fv.SetBool(v != 0)
+		}
+	}
+}
+
+// Encode builds the decrypted datagram of a frame in this format, the inverse of decoding.
+// Fields packed in the bits of a value are ORed into it once every whole value is written.
+func (l *Layout) Encode(tf *TelemetryFrame) []byte {
+	data := make([]byte, l.Size)
+	binary.LittleEndian.PutUint32(data[0x00:0x04], magicNumber)
+
+	frame := reflect.ValueOf(tf).Elem()
+	for _, packed := range []bool{false, true} {
+		for _, f := range l.Fields {
+			if f.frame < 0 || (f.Width > 0) != packed {
+				continue
+			}
+			f.put(data, frameFloat(frame.Field(f.frame)))
+		}
+	}
+	return data
+}
+
+func frameFloat(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Bool:
+		if v.Bool() {
+			return 1
+		}
+	}
+	return 0
+}
+
+// put writes a decoded value back as raw
+func (f Field) put(data []byte, v float64) {
+	v -= f.Bias
+	if f.Scale != 0 {
+		v /= f.Scale
+	}
+	b := data[f.Offset : f.Offset+f.Size()]
+
+	if f.Type == "float32" {
+		binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v)))
+		return
+	}
+
+	raw := uint64(int64(math.Round(v)))
+	if f.Width > 0 {
+		raw = f.unsigned(f.Value(data)) | (raw&(1<<f.Width-1))<<f.Shift
+	}
+	switch f.Size() {
+	case 1:
+		b[0] = uint8(raw)
+	case 2:
+		binary.LittleEndian.PutUint16(b, uint16(raw))
+	default:
+		binary.LittleEndian.PutUint32(b, uint32(raw))
+	}
+}
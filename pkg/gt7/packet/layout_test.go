@@ -0,0 +1,171 @@
+package packet
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// sampleFrame sets every TelemetryFrame field a layout decodes to a distinct value its raw
+// type can hold
+func sampleFrame(l *Layout) TelemetryFrame {
+	var tf TelemetryFrame
+	frame := reflect.ValueOf(&tf).Elem()
+	for _, f := range l.Fields {
+		if !f.Decoded() {
+			continue
+		}
+		fv := frame.Field(f.frame)
+		switch {
+		case fv.Kind() == reflect.Bool:
+			fv.SetBool(true)
+		case f.Width > 0:
+			fv.SetUint(uint64(f.Shift/4 + 3))
+		case f.Type == "uint8":
+			// Pedals, sent as 0-255
+			fv.SetFloat(40)
+		case fv.Kind() == reflect.Float32:
+			fv.SetFloat(float64(f.Offset) + 0.25)
+		case fv.Kind() == reflect.Int16 || fv.Kind() == reflect.Int32:
+			fv.SetInt(int64(f.Offset))
+		default:
+			fv.SetUint(uint64(f.Offset))
+		}
+	}
+	// Consistent with InRace and IsPaused
+	tf.Flags = 3
+	return tf
+}
+
+func TestLayoutRoundTrip(t *testing.T) {
+	for _, l := range Layouts {
+		t.Run(string(l.Format), func(t *testing.T) {
+			want := sampleFrame(l)
+			plain := l.Encode(&want)
+			if len(plain) != l.Size {
+				t.Fatalf("Encode() returned %d bytes, want %d", len(plain), l.Size)
+			}
+
+			encrypted := Encrypt(plain, 0x12345678)
+			if binary.LittleEndian.Uint32(encrypted[0:4]) == magicNumber {
+				t.Fatalf("Encrypt() left the magic number in the clear")
+			}
+			// The IV is sent in the clear
+			if iv := binary.LittleEndian.Uint32(encrypted[0x40:0x44]); iv != 0x12345678 {
+				t.Errorf("IV = %#x, want 0x12345678", iv)
+			}
+			got, err := NewDecoder().ReadPacket(encrypted)
+			if err != nil {
+				t.Fatalf("ReadPacket() failed: %v", err)
+			}
+
+			for _, f := range l.Fields {
+				if !f.Decoded() {
+					continue
+				}
+				g := frameFloat(reflect.ValueOf(got).Elem().Field(f.frame))
+				w := frameFloat(reflect.ValueOf(&want).Elem().Field(f.frame))
+				if math.Abs(g-w) > 1e-4*math.Max(1, math.Abs(w)) {
+					t.Errorf("%s = %v, want %v", f.Name, g, w)
+				}
+			}
+		})
+	}
+}
+
+func TestDecryptRejects(t *testing.T) {
+	tf := sampleFrame(LayoutB)
+	encrypted := Encrypt(LayoutB.Encode(&tf), 42)
+
+	if _, err := Decrypt(encrypted[:LayoutA.Size-1]); err != ErrShortPacket {
+		t.Errorf("Decrypt(short) = %v, want ErrShortPacket", err)
+	}
+	// Read as an "A" packet, the IV mask is wrong
+	if _, err := Decrypt(encrypted[:LayoutA.Size]); err != ErrMagicMismatch {
+		t.Errorf("Decrypt(truncated to A) = %v, want ErrMagicMismatch", err)
+	}
+	corrupted := append([]byte(nil), encrypted...)
+	corrupted[0] ^= 0xFF
+	if _, err := Decrypt(corrupted); err != ErrMagicMismatch {
+		t.Errorf("Decrypt(corrupted) = %v, want ErrMagicMismatch", err)
+	}
+}
+
+// TestKnownOffsets reads a datagram assembled byte by byte at the offsets documented by Nenkai,
+// independently of the layout table
+func TestKnownOffsets(t *testing.T) {
+	data := make([]byte, 0x158)
+	le := binary.LittleEndian
+	putFloat := func(offset int, v float32) { le.PutUint32(data[offset:], math.Float32bits(v)) }
+	le.PutUint32(data[0x00:], 0x47375330)
+	putFloat(0x04, -123.5)          // PositionX
+	putFloat(0x3C, 6500)            // RPM
+	putFloat(0x4C, 50)              // Speed in m/s
+	putFloat(0x50, 1.5)             // Boost, absolute
+	le.PutUint32(data[0x70:], 1234) // PackageID
+	le.PutUint16(data[0x74:], 3)    // CurrentLap
+	le.PutUint32(data[0x7C:], 92345)
+	le.PutUint16(data[0x8A:], 7800) // RPMRevLimiter
+	le.PutUint16(data[0x8E:], 0x0001)
+	data[0x90] = 0x43    // Suggested gear 4, current gear 3
+	data[0x91] = 255     // Throttle
+	putFloat(0x104, 3.2) // Gear1
+	le.PutUint32(data[0x124:], 3448)
+	putFloat(0x128, 0.5)  // WheelRotation
+	data[0x13D] = 51      // BrakeFiltered
+	putFloat(0x150, 2.25) // EnergyRecovery
+
+	tf, err := NewDecoder().ReadPacket(Encrypt(data, 0xCAFE))
+	if err != nil {
+		t.Fatalf("ReadPacket() failed: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		got, want float64
+	}{
+		{"PositionX", float64(tf.PositionX), -123.5},
+		{"RPM", float64(tf.RPM), 6500},
+		{"CarSpeed", float64(tf.CarSpeed), 180},
+		{"Boost", float64(tf.Boost), 0.5},
+		{"PackageID", float64(tf.PackageID), 1234},
+		{"CurrentLap", float64(tf.CurrentLap), 3},
+		{"LastLap", float64(tf.LastLap), 92345},
+		{"RPMRevLimiter", float64(tf.RPMRevLimiter), 7800},
+		{"CurrentGear", float64(tf.CurrentGear), 3},
+		{"SuggestedGear", float64(tf.SuggestedGear), 4},
+		{"Throttle", float64(tf.Throttle), 100},
+		{"Gear1", float64(tf.Gear1), 3.2},
+		{"CarID", float64(tf.CarID), 3448},
+		{"WheelRotation", float64(tf.WheelRotation), 0.5},
+		{"BrakeFiltered", float64(tf.BrakeFiltered), 20},
+		{"EnergyRecovery", float64(tf.EnergyRecovery), 2.25},
+	}
+	for _, tt := range tests {
+		if math.Abs(tt.got-tt.want) > 1e-4*math.Max(1, math.Abs(tt.want)) {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if !tf.InRace || tf.IsPaused {
+		t.Errorf("InRace, IsPaused = %v, %v, want true, false", tf.InRace, tf.IsPaused)
+	}
+}
+
+func TestEncodeTyreSpeed(t *testing.T) {
+	want := TelemetryFrame{CarSpeed: 100, TyreDiameterFL: 0.33, TyreSpeedFL: 110, TyreDiameterRR: 0.35, TyreSpeedRR: 90}
+	got, err := NewDecoder().ReadPacket(Encrypt(Encode(&want), 1))
+	if err != nil {
+		t.Fatalf("ReadPacket() failed: %v", err)
+	}
+	if math.Abs(float64(got.TyreSpeedFL-110)) > 1e-3 || math.Abs(float64(got.TyreSpeedRR-90)) > 1e-3 {
+		t.Errorf("tyre speeds = %v, %v, want 110, 90", got.TyreSpeedFL, got.TyreSpeedRR)
+	}
+	if math.Abs(float64(got.TyreSlipRatioFL-1.1)) > 1e-4 {
+		t.Errorf("TyreSlipRatioFL = %v, want 1.1", got.TyreSlipRatioFL)
+	}
+	// Without a diameter the tyre speed can't be sent
+	if got.TyreSpeedFR != 0 {
+		t.Errorf("TyreSpeedFR = %v, want 0", got.TyreSpeedFR)
+	}
+}
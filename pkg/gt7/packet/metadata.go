@@ -0,0 +1,110 @@
+package packet
+
+import (
+	"reflect"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Range is the span of a bounded value, used to scale gauges
+type Range struct {
+	Min, Max float64
+}
+
+// FieldRanges are the bounds of the TelemetryFrame fields that have fixed ones
+var FieldRanges = map[string]Range{
+	"Throttle":         {0, 100},
+	"Brake":            {0, 100},
+	"ThrottleFiltered": {0, 100},
+	"BrakeFiltered":    {0, 100},
+	"Clutch":           {0, 1},
+	"ClutchEngaged":    {0, 1},
+	"CurrentGear":      {0, 8},
+	"SuggestedGear":    {0, 15},
+}
+
+// grafanaUnits maps FieldUnits to Grafana unit identifiers
+var grafanaUnits = map[string]string{
+	"ms":    "ms",
+	"ns":    "ns",
+	"L":     "litre",
+	"bar":   "pressurebar",
+	"m":     "lengthm",
+	"mm":    "lengthmm",
+	"km/h":  "velocitykmh",
+	"m/s":   "velocityms",
+	"%":     "percent",
+	"rpm":   "rotrpm",
+	"°C":    "celsius",
+	"rad/s": "suffix: rad/s",
+	"m/s²":  "accMS2",
+	"g":     "accG",
+	"°":     "degree",
+	"rad":   "suffix: rad",
+	"mph":   "velocitymph",
+	"°F":    "fahrenheit",
+	"psi":   "pressurepsi",
+	"in":    "lengthin",
+	"gal":   "gallons",
+}
+
+// GrafanaUnit returns the Grafana unit identifier of a unit, a suffix when Grafana has none
+func GrafanaUnit(unit string) string {
+	if u, ok := grafanaUnits[unit]; ok {
+		return u
+	}
+	if unit == "" {
+		return ""
+	}
+	return "suffix: " + unit
+}
+
+// fieldConfigs holds the metadata of every TelemetryFrame field
+var fieldConfigs = frameFieldConfigs()
+
+func frameFieldConfigs() map[string]data.FieldConfig {
+	docs := map[string]Field{}
+	for _, f := range LayoutTilde.Fields {
+		docs[f.Name] = f
+	}
+	for _, f := range DerivedFields {
+		docs[f.Name] = f
+	}
+
+	configs := map[string]data.FieldConfig{}
+	t := reflect.TypeOf(TelemetryFrame{})
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		f := docs[name]
+
+		// The label only goes in the description: a display name would rename fields that
+		// dashboards match by name
+		description := f.Doc
+		if f.Label != "" && f.Doc != "" {
+			description = f.Label + ": " + f.Doc
+		} else if f.Label != "" {
+			description = f.Label
+		}
+		config := data.FieldConfig{
+			Description: description,
+			Unit:        GrafanaUnit(FieldUnits[name]),
+		}
+		if r, ok := FieldRanges[name]; ok {
+			config.SetMin(r.Min).SetMax(r.Max)
+		}
+		configs[name] = config
+	}
+	return configs
+}
+
+// FieldConfig returns the Grafana metadata of a TelemetryFrame field: unit, description and,
+// for bounded values, min and max. Fields keep their name as display name, and the driver stays
+// in their labels, so that field overrides keep matching.
+func FieldConfig(name string, units UnitSystem) *data.FieldConfig {
+	config, ok := fieldConfigs[name]
+	if !ok {
+		return nil
+	}
+	config.Unit = GrafanaUnit(units.Unit(name))
+	return &config
+}
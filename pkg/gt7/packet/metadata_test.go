@@ -0,0 +1,44 @@
+package packet
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestTelemetryToFields(t *testing.T) {
+	labels := data.Labels{"driver": "Player 2"}
+	fields := TelemetryToFields(TelemetryFrame{CarSpeed: 100}, labels, UnitsMetric)
+
+	byName := map[string]*data.Field{}
+	for _, f := range fields {
+		byName[f.Name] = f
+	}
+	tests := []struct {
+		name, description, unit string
+	}{
+		{"CarSpeed", "", "velocitykmh"},
+		{"GForceX", "G-Force X: ", ""},
+	}
+	for _, tt := range tests {
+		f, ok := byName[tt.name]
+		if !ok {
+			t.Fatalf("no %s field", tt.name)
+		}
+		// The driver is a label, and never renames the field
+		if f.Labels["driver"] != "Player 2" {
+			t.Errorf("%s labels = %v, want the driver", tt.name, f.Labels)
+		}
+		// Fields aren't renamed, the label is in the description
+		if f.Config.DisplayNameFromDS != "" || f.Config.DisplayName != "" {
+			t.Errorf("%s display name = %q, want none", tt.name, f.Config.DisplayNameFromDS)
+		}
+		if !strings.HasPrefix(f.Config.Description, tt.description) {
+			t.Errorf("%s description = %q, want it to start with %q", tt.name, f.Config.Description, tt.description)
+		}
+		if tt.unit != "" && f.Config.Unit != tt.unit {
+			t.Errorf("%s unit = %q, want %q", tt.name, f.Config.Unit, tt.unit)
+		}
+	}
+}
@@ -3,10 +3,12 @@ package packet
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"golang.org/x/crypto/salsa20"
 	"math"
+	"sort"
 	"time"
 )
 
@@ -92,14 +94,76 @@ type TelemetryFrame struct {
 	Roll              float32
 	Pitch             float32
 	Yaw               float32
-	IsPaused          bool
-	InRace            bool
+	YawRateExpected   float32
+	HandlingBalance   float32
+	// Sent by the "B" and "~" formats only
+	WheelRotation    float32
+	Sway             float32
+	Heave            float32
+	Surge            float32
+	ThrottleFiltered float32
+	BrakeFiltered    float32
+	TorqueVectorFL   float32
+	TorqueVectorFR   float32
+	TorqueVectorRL   float32
+	TorqueVectorRR   float32
+	EnergyRecovery   float32
+	IsPaused         bool
+	InRace           bool
+	// Raw simulator flags, see SimFlagCarOnTrack and the following constants
+	Flags uint16
+	// Packets missing between the previous frame and this one
+	PacketsLost int32
+	// Sequence flags, see FlagGap and the following constants
+	PacketFlags uint8
 }
 
-// Necessary for acceleration calculation
-var previousLocalVelocity Vector3 = Vector3{0, 0, 0}
+const (
+	// PacketSize is the size of a GT7 telemetry datagram ("A" heartbeat format)
+	PacketSize  = 0x128
+	magicNumber = 0x47375330
+)
+
+var (
+	ErrShortPacket   = errors.New("packet too short")
+	ErrMagicMismatch = errors.New("magic number mismatch")
+)
+
+// Decoder turns raw datagrams from a single console into telemetry frames. Derived values
+// such as acceleration depend on the previous packet, so every console needs its own Decoder.
+type Decoder struct {
+	// Necessary for acceleration calculation
+	previousLocalVelocity Vector3
+	previousAcceleration  Vector3
+	// Necessary for the handling balance
+	previousVelocity    Vector3
+	previousPathYawRate float64
+
+	started       bool
+	lastPackageID int32
+}
+
+// NewDecoder creates a decoder for a single telemetry source
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+// Decrypt decrypts a raw GT7 datagram of any format and checks its magic number.
+func Decrypt(dat []byte) ([]byte, error) {
+	layout := LayoutFor(len(dat))
+	if layout == nil {
+		return nil, ErrShortPacket
+	}
+
+	ddata := salsa20Dec(dat, layout.IVMask)
+	if binary.LittleEndian.Uint32(ddata[0:4]) != magicNumber {
+		return nil, ErrMagicMismatch
+	}
+
+	return ddata, nil
+}
 
-func salsa20Dec(dat []byte) []byte {
+func salsa20Dec(dat []byte, ivMask uint32) []byte {
 	keyStr := "Simulator Interface Packet GT7 ver 0.0"
 	var key [32]byte
 	copy(key[:], keyStr[:32])
@@ -108,8 +172,8 @@ func salsa20Dec(dat []byte) []byte {
 	oiv := dat[0x40:0x44]
 	iv1 := binary.LittleEndian.Uint32(oiv)
 
-	// Notice DEADBEAF, not DEADBEEF
-	iv2 := iv1 ^ 0xDEADBEAF
+	// Notice DEADBEAF, not DEADBEEF, for the "A" format
+	iv2 := iv1 ^ ivMask
 
 	iv := make([]byte, 8)
 	binary.LittleEndian.PutUint32(iv[0:4], iv2)
@@ -118,83 +182,34 @@ func salsa20Dec(dat []byte) []byte {
 	ddata := make([]byte, len(dat))
 	salsa20.XORKeyStream(ddata, dat, iv, &key)
 
-	magic := binary.LittleEndian.Uint32(ddata[0:4])
-	if magic != 0x47375330 {
-		log.DefaultLogger.Warn("Magic number mismatch")
-		return []byte{}
-	}
-
 	return ddata
 }
 
-func ReadPacket(b []byte) (*TelemetryFrame, error) {
-	dFrame := salsa20Dec(b)
+// ReadPacket decrypts and decodes a raw datagram
+func (d *Decoder) ReadPacket(b []byte) (*TelemetryFrame, error) {
+	dFrame, err := Decrypt(b)
+	if err != nil {
+		return nil, err
+	}
 
-	frame := convertTelemetryValues(dFrame)
+	frame := d.convertTelemetryValues(LayoutFor(len(dFrame)), dFrame)
 
 	return frame, nil
 }
 
-func convertTelemetryValues(data []byte) *TelemetryFrame {
-	returnedFrame := TelemetryFrame{
-		PackageID:         int32(binary.LittleEndian.Uint32(data[0x70:0x74])),
-		BestLap:           int32(binary.LittleEndian.Uint32(data[0x78:0x7C])),
-		LastLap:           int32(binary.LittleEndian.Uint32(data[0x7C:0x80])),
-		CurrentLap:        int16(binary.LittleEndian.Uint16(data[0x74:0x76])),
-		CurrentGear:       data[0x90] & 0b00001111,
-		SuggestedGear:     data[0x90] >> 4,
-		FuelCapacity:      math.Float32frombits(binary.LittleEndian.Uint32(data[0x48:0x4C])),
-		CurrentFuel:       math.Float32frombits(binary.LittleEndian.Uint32(data[0x44:0x48])),
-		Boost:             math.Float32frombits(binary.LittleEndian.Uint32(data[0x50:0x54])) - 1,
-		TyreDiameterFL:    math.Float32frombits(binary.LittleEndian.Uint32(data[0xB4:0xB8])),
-		TyreDiameterFR:    math.Float32frombits(binary.LittleEndian.Uint32(data[0xB8:0xBC])),
-		TyreDiameterRL:    math.Float32frombits(binary.LittleEndian.Uint32(data[0xBC:0xC0])),
-		TyreDiameterRR:    math.Float32frombits(binary.LittleEndian.Uint32(data[0xC0:0xC4])),
-		CarSpeed:          3.6 * math.Float32frombits(binary.LittleEndian.Uint32(data[0x4C:0x50])),
-		TotalLaps:         int16(binary.LittleEndian.Uint16(data[0x76:0x78])),
-		CurrentPosition:   int16(binary.LittleEndian.Uint16(data[0x84:0x86])),
-		TotalPositions:    int16(binary.LittleEndian.Uint16(data[0x86:0x88])),
-		CarID:             int32(binary.LittleEndian.Uint32(data[0x124:0x128])),
-		Throttle:          float32(data[0x91]) / 2.55,
-		RPM:               math.Float32frombits(binary.LittleEndian.Uint32(data[0x3C:0x40])),
-		RPMRevWarning:     binary.LittleEndian.Uint16(data[0x88:0x8A]),
-		Brake:             float32(data[0x92]) / 2.55,
-		RPMRevLimiter:     binary.LittleEndian.Uint16(data[0x8A:0x8C]),
-		EstimatedTopSpeed: int16(binary.LittleEndian.Uint16(data[0x8C:0x8E])),
-		OilTemp:           math.Float32frombits(binary.LittleEndian.Uint32(data[0x5C:0x60])),
-		WaterTemp:         math.Float32frombits(binary.LittleEndian.Uint32(data[0x58:0x5C])),
-		OilPressure:       math.Float32frombits(binary.LittleEndian.Uint32(data[0x54:0x58])),
-		RideHeight:        1000 * math.Float32frombits(binary.LittleEndian.Uint32(data[0x38:0x3C])),
-		TyreTempFL:        math.Float32frombits(binary.LittleEndian.Uint32(data[0x60:0x64])),
-		TyreTempFR:        math.Float32frombits(binary.LittleEndian.Uint32(data[0x64:0x68])),
-		TyreTempRL:        math.Float32frombits(binary.LittleEndian.Uint32(data[0x68:0x6C])),
-		TyreTempRR:        math.Float32frombits(binary.LittleEndian.Uint32(data[0x6C:0x70])),
-		SuspensionFL:      math.Float32frombits(binary.LittleEndian.Uint32(data[0xC4:0xC8])),
-		SuspensionFR:      math.Float32frombits(binary.LittleEndian.Uint32(data[0xC8:0xCC])),
-		SuspensionRL:      math.Float32frombits(binary.LittleEndian.Uint32(data[0xCC:0xD0])),
-		SuspensionRR:      math.Float32frombits(binary.LittleEndian.Uint32(data[0xD0:0xD4])),
-		PositionX:         math.Float32frombits(binary.LittleEndian.Uint32(data[0x04:0x08])),
-		PositionY:         math.Float32frombits(binary.LittleEndian.Uint32(data[0x08:0x0C])),
-		PositionZ:         math.Float32frombits(binary.LittleEndian.Uint32(data[0x0C:0x10])),
-		VelocityX:         math.Float32frombits(binary.LittleEndian.Uint32(data[0x10:0x14])),
-		VelocityY:         math.Float32frombits(binary.LittleEndian.Uint32(data[0x14:0x18])),
-		VelocityZ:         math.Float32frombits(binary.LittleEndian.Uint32(data[0x18:0x1C])),
-		RotationPitch:     math.Float32frombits(binary.LittleEndian.Uint32(data[0x1C:0x20])),
-		RotationYaw:       math.Float32frombits(binary.LittleEndian.Uint32(data[0x20:0x24])),
-		RotationRoll:      math.Float32frombits(binary.LittleEndian.Uint32(data[0x24:0x28])),
-		QuaternionScalar:  math.Float32frombits(binary.LittleEndian.Uint32(data[0x28:0x2C])),
-		AngularVelocityX:  math.Float32frombits(binary.LittleEndian.Uint32(data[0x2C:0x30])),
-		AngularVelocityY:  math.Float32frombits(binary.LittleEndian.Uint32(data[0x30:0x34])),
-		AngularVelocityZ:  math.Float32frombits(binary.LittleEndian.Uint32(data[0x34:0x38])),
-		IsPaused:          (data[0x8E] & 0b00000010) != 0,
-		InRace:            (data[0x8E] & 0b00000001) != 0,
-	}
+func (d *Decoder) convertTelemetryValues(layout *Layout, data []byte) *TelemetryFrame {
+	var returnedFrame TelemetryFrame
+	layout.decode(data, &returnedFrame)
 
 	// Tyre speed calculation
-	returnedFrame.TyreSpeedFL = float32(math.Abs(float64(3.6 * returnedFrame.TyreDiameterFL * math.Float32frombits(binary.LittleEndian.Uint32(data[0xA4:0xA8])))))
-	returnedFrame.TyreSpeedFR = float32(math.Abs(float64(3.6 * returnedFrame.TyreDiameterFR * math.Float32frombits(binary.LittleEndian.Uint32(data[0xA8:0xAC])))))
-	returnedFrame.TyreSpeedRL = float32(math.Abs(float64(3.6 * returnedFrame.TyreDiameterRL * math.Float32frombits(binary.LittleEndian.Uint32(data[0xAC:0xB0])))))
-	returnedFrame.TyreSpeedRR = float32(math.Abs(float64(3.6 * returnedFrame.TyreDiameterRR * math.Float32frombits(binary.LittleEndian.Uint32(data[0xB0:0xB4])))))
+	tyreSpeed := func(wheel string, diameter float32) float32 {
+		rps, _ := layout.Field("WheelRPS" + wheel)
+		return float32(math.Abs(float64(3.6 * diameter * float32(rps.Float(data)))))
+	}
+	returnedFrame.TyreSpeedFL = tyreSpeed("FL", returnedFrame.TyreDiameterFL)
+	returnedFrame.TyreSpeedFR = tyreSpeed("FR", returnedFrame.TyreDiameterFR)
+	returnedFrame.TyreSpeedRL = tyreSpeed("RL", returnedFrame.TyreDiameterRL)
+	returnedFrame.TyreSpeedRR = tyreSpeed("RR", returnedFrame.TyreDiameterRR)
 
 	// Tyre slip ratio calculation
 	if returnedFrame.CarSpeed > 0 {
@@ -225,13 +240,59 @@ func convertTelemetryValues(data []byte) *TelemetryFrame {
 	returnedFrame.LocalVelocityY = float32(localV[1])
 	returnedFrame.LocalVelocityZ = float32(localV[2])
 
-	// Calculating dv
-	dVelocity := sub(localV, previousLocalVelocity)
+	// Acceleration is dv over the time elapsed since the previous packet, which spans
+	// several packet intervals when some were lost
+	Acceleration := d.previousAcceleration
+	pathYawRate := d.previousPathYawRate
+	elapsed := 0.0
+	kind, lost := d.sequence(returnedFrame.PackageID)
+	switch kind {
+	case sequenceNext:
+		elapsed = packetInterval
+	case sequenceGap:
+		returnedFrame.PacketsLost = lost
+		returnedFrame.PacketFlags |= FlagGap
+		if lost <= MaxInterpolatedGap {
+			elapsed = float64(lost+1) * packetInterval
+		} else {
+			returnedFrame.PacketFlags |= FlagNoDerived
+		}
+	case sequenceDuplicate:
+		returnedFrame.PacketFlags |= FlagDuplicate | FlagNoDerived
+	case sequenceReordered:
+		returnedFrame.PacketFlags |= FlagReordered | FlagNoDerived
+	default:
+		// First packet or counter reset, there is nothing to derive from
+		returnedFrame.PacketFlags |= FlagNoDerived
+	}
+	if elapsed > 0 {
+		Acceleration = scale(sub(localV, d.previousLocalVelocity), 1/elapsed)
+		pathYawRate = turnRate(v, scale(sub(v, d.previousVelocity), 1/elapsed))
+	}
 
-	// NOTE: The first time this will be completely wrong
-	Acceleration := scale(dVelocity, 60)
+	if kind != sequenceDuplicate && kind != sequenceReordered {
+		d.started = true
+		d.lastPackageID = returnedFrame.PackageID
+		d.previousLocalVelocity = localV
+		d.previousVelocity = v
+		d.previousAcceleration = Acceleration
+		d.previousPathYawRate = pathYawRate
+	}
 
-	previousLocalVelocity = localV
+	// Handling balance: the car rotating faster than its path curves is oversteering,
+	// slower is understeering. The yaw rate counts in the direction of the path, so that
+	// rotating against it, e.g. a snap back, is understeer rather than oversteer.
+	returnedFrame.YawRateExpected = float32(pathYawRate)
+	if returnedFrame.PacketFlags&FlagNoDerived == 0 && math.Hypot(v[0], v[2]) >= minBalanceSpeed {
+		yawRate := float64(returnedFrame.AngularVelocityY)
+		switch {
+		case pathYawRate < 0:
+			yawRate = -yawRate
+		case pathYawRate == 0:
+			yawRate = 0
+		}
+		returnedFrame.HandlingBalance = float32(yawRate - math.Abs(pathYawRate))
+	}
 
 	returnedFrame.AccelerationX = float32(Acceleration[0])
 	returnedFrame.AccelerationY = float32(Acceleration[1])
@@ -251,7 +312,23 @@ func convertTelemetryValues(data []byte) *TelemetryFrame {
 	return &returnedFrame
 }
 
-func telemetryFrameToMap(frame TelemetryFrame) map[string]float32 {
+// Below this speed, in m/s, the handling balance is left at 0
+const minBalanceSpeed = 5
+
+// turnRate returns how fast, in rad/s, the horizontal direction of the velocity v changes
+// under the acceleration a. It is the lateral acceleration over the speed, the yaw rate of a
+// car following its path without sliding, with the sign of AngularVelocityY: a rotation of
+// w rad/s about Y changes v by w × v. Y is the vertical axis.
+func turnRate(v, a Vector3) float64 {
+	speed2 := v[0]*v[0] + v[2]*v[2]
+	if speed2 < minBalanceSpeed*minBalanceSpeed {
+		return 0
+	}
+	return (v[2]*a[0] - v[0]*a[2]) / speed2
+}
+
+// TelemetryToMap returns the numeric values of a frame by field name
+func TelemetryToMap(frame TelemetryFrame) map[string]float32 {
 	var frameMap map[string]float32
 	frameJson, err := json.Marshal(&frame)
 	if err != nil {
@@ -263,17 +340,33 @@ func telemetryFrameToMap(frame TelemetryFrame) map[string]float32 {
 
 func TelemetryToDataFrame(tf TelemetryFrame) *data.Frame {
 	frame := data.NewFrame("response")
-	telemetryMap := telemetryFrameToMap(tf)
 
 	frame.Fields = append(frame.Fields,
 		data.NewField("time", nil, []time.Time{time.Now()}),
 	)
+	frame.Fields = append(frame.Fields, TelemetryToFields(tf, nil, UnitsMetric)...)
+
+	return frame
+}
 
-	for name, value := range telemetryMap {
-		frame.Fields = append(frame.Fields,
-			data.NewField(name, nil, []float32{value}),
-		)
+// TelemetryToFields converts a frame into single-value fields carrying the given labels,
+// so that frames from several sources can be combined side by side. Values are converted to
+// the unit system, and fields come with their unit, display name, description and range.
+func TelemetryToFields(tf TelemetryFrame, labels data.Labels, units UnitSystem) []*data.Field {
+	telemetryMap := TelemetryToMap(tf)
+
+	names := make([]string, 0, len(telemetryMap))
+	for name := range telemetryMap {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	return frame
+	fields := make([]*data.Field, 0, len(names))
+	for _, name := range names {
+		field := data.NewField(name, labels, []float32{units.Value(name, telemetryMap[name])})
+		field.Config = FieldConfig(name, units)
+		fields = append(fields, field)
+	}
+
+	return fields
 }
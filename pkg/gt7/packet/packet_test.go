@@ -0,0 +1,55 @@
+package packet
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHandlingBalance(t *testing.T) {
+	const speed = 20
+	tests := []struct {
+		name string
+		// Turn rate of the path and yaw rate of the car, in rad/s
+		path, yaw float64
+		balance   float64
+	}{
+		{name: "neutral", path: 0.4, yaw: 0.4, balance: 0},
+		{name: "oversteer", path: 0.4, yaw: 0.6, balance: 0.2},
+		{name: "understeer", path: 0.4, yaw: 0.2, balance: -0.2},
+		{name: "oversteer the other way", path: -0.4, yaw: -0.6, balance: 0.2},
+		{name: "understeer the other way", path: -0.4, yaw: -0.2, balance: -0.2},
+		// Rotating against the path is more understeer than not rotating at all
+		{name: "rotating against the path", path: 0.4, yaw: -0.2, balance: -0.6},
+		{name: "rotating against the path the other way", path: -0.4, yaw: 0.2, balance: -0.6},
+		{name: "straight", path: 0, yaw: 0.3, balance: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			// The velocity turns by the path turn rate over a packet interval
+			angle := tt.path / PacketRate
+			velocities := [][2]float64{{0, speed}, {speed * math.Sin(angle), speed * math.Cos(angle)}}
+			var tf *TelemetryFrame
+			for i, v := range velocities {
+				f := TelemetryFrame{
+					PackageID:        int32(i + 1),
+					QuaternionScalar: 1,
+					VelocityX:        float32(v[0]),
+					VelocityZ:        float32(v[1]),
+					AngularVelocityY: float32(tt.yaw),
+				}
+				var err error
+				if tf, err = d.ReadPacket(Encrypt(LayoutA.Encode(&f), uint32(f.PackageID))); err != nil {
+					t.Fatalf("packet %d: ReadPacket() failed: %v", i, err)
+				}
+			}
+
+			if got := float64(tf.YawRateExpected); math.Abs(got-tt.path) > 1e-3 {
+				t.Errorf("YawRateExpected = %v, want %v", got, tt.path)
+			}
+			if got := float64(tf.HandlingBalance); math.Abs(got-tt.balance) > 1e-3 {
+				t.Errorf("HandlingBalance = %v, want %v", got, tt.balance)
+			}
+		})
+	}
+}
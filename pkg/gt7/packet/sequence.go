@@ -0,0 +1,65 @@
+package packet
+
+// GT7 sends 60 packets per second, PackageID increments by one for each of them
+const (
+	PacketRate     = 60
+	packetInterval = 1.0 / PacketRate
+)
+
+// MaxInterpolatedGap is the longest run of lost packets derived values are interpolated
+// across. After longer gaps they are skipped, i.e. held from the previous frame.
+const MaxInterpolatedGap = 10
+
+// A PackageID this far behind the previous one means the console restarted its counter
+// rather than a reordered datagram.
+const sequenceResetWindow = 2 * PacketRate
+
+// Flags set on TelemetryFrame.PacketFlags
+const (
+	// Packets were lost right before this frame, see TelemetryFrame.PacketsLost
+	FlagGap uint8 = 1 << iota
+	// Same PackageID as an already decoded frame
+	FlagDuplicate
+	// Older PackageID than an already decoded frame
+	FlagReordered
+	// Derived values (acceleration, G-forces) could not be computed and were held
+	FlagNoDerived
+)
+
+type sequenceKind int
+
+const (
+	sequenceFirst sequenceKind = iota
+	sequenceNext
+	sequenceGap
+	sequenceDuplicate
+	sequenceReordered
+	sequenceReset
+)
+
+// sequence classifies a PackageID against the last one seen. It returns the number of
+// missing packets for gaps.
+func (d *Decoder) sequence(id int32) (sequenceKind, int32) {
+	if !d.started {
+		return sequenceFirst, 0
+	}
+
+	delta := id - d.lastPackageID
+	switch {
+	case delta == 1:
+		return sequenceNext, 0
+	case delta == 0:
+		return sequenceDuplicate, 0
+	case delta < 0 && -delta < sequenceResetWindow:
+		return sequenceReordered, 0
+	case delta < 0:
+		return sequenceReset, 0
+	default:
+		return sequenceGap, delta - 1
+	}
+}
+
+// Duplicate or reordered frames carry no new information and are usually dropped
+func (tf *TelemetryFrame) Stale() bool {
+	return tf.PacketFlags&(FlagDuplicate|FlagReordered) != 0
+}
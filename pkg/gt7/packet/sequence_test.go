@@ -0,0 +1,116 @@
+package packet
+
+import (
+	"math"
+	"testing"
+)
+
+// sequencePacket is a datagram of a car moving straight at speed m/s
+func sequencePacket(id int32, speed float32) []byte {
+	tf := TelemetryFrame{PackageID: id, QuaternionScalar: 1, VelocityZ: speed}
+	return Encrypt(LayoutA.Encode(&tf), uint32(id))
+}
+
+func TestSequence(t *testing.T) {
+	type packet struct {
+		id    int32
+		speed float32
+		// Expected frame
+		flags        uint8
+		lost         int32
+		acceleration float64
+	}
+	tests := []struct {
+		name    string
+		packets []packet
+	}{
+		{
+			name: "in order",
+			packets: []packet{
+				{id: 100, speed: 10, flags: FlagNoDerived},
+				{id: 101, speed: 10.1, acceleration: 6},
+				{id: 102, speed: 10.3, acceleration: 12},
+			},
+		},
+		{
+			name: "wraparound",
+			packets: []packet{
+				{id: math.MaxInt32 - 1, speed: 10, flags: FlagNoDerived},
+				{id: math.MaxInt32, speed: 10.1, acceleration: 6},
+				{id: math.MinInt32, speed: 10.2, acceleration: 6},
+				{id: math.MinInt32 + 2, speed: 10.4, flags: FlagGap, lost: 1, acceleration: 6},
+			},
+		},
+		{
+			name: "interpolated gap",
+			packets: []packet{
+				{id: 1, speed: 10, flags: FlagNoDerived},
+				// Ten packets lost, 11 intervals
+				{id: 12, speed: 11.1, flags: FlagGap, lost: 10, acceleration: 6},
+			},
+		},
+		{
+			name: "gap too long to interpolate",
+			packets: []packet{
+				{id: 1, speed: 10, flags: FlagNoDerived},
+				{id: 2, speed: 10.1, acceleration: 6},
+				// The acceleration is held
+				{id: 14, speed: 20, flags: FlagGap | FlagNoDerived, lost: 11, acceleration: 6},
+				{id: 15, speed: 20.2, acceleration: 12},
+			},
+		},
+		{
+			name: "duplicate",
+			packets: []packet{
+				{id: 1, speed: 10, flags: FlagNoDerived},
+				{id: 2, speed: 10.1, acceleration: 6},
+				{id: 2, speed: 10.1, flags: FlagDuplicate | FlagNoDerived, acceleration: 6},
+				// Derived from the first copy
+				{id: 3, speed: 10.3, acceleration: 12},
+			},
+		},
+		{
+			name: "reordered",
+			packets: []packet{
+				{id: 10, speed: 10, flags: FlagNoDerived},
+				{id: 12, speed: 10.2, flags: FlagGap, lost: 1, acceleration: 6},
+				{id: 11, speed: 10.1, flags: FlagReordered | FlagNoDerived, acceleration: 6},
+				{id: 13, speed: 10.4, acceleration: 12},
+			},
+		},
+		{
+			name: "console restart",
+			packets: []packet{
+				{id: 5000, speed: 10, flags: FlagNoDerived},
+				{id: 5001, speed: 10.1, acceleration: 6},
+				// Far behind: a new counter, not a reordered packet
+				{id: 3, speed: 0, flags: FlagNoDerived, acceleration: 6},
+				{id: 4, speed: 0.1, acceleration: 6},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			for i, p := range tt.packets {
+				tf, err := d.ReadPacket(sequencePacket(p.id, p.speed))
+				if err != nil {
+					t.Fatalf("packet %d: ReadPacket() failed: %v", i, err)
+				}
+				if tf.PacketFlags != p.flags {
+					t.Errorf("packet %d: PacketFlags = %04b, want %04b", i, tf.PacketFlags, p.flags)
+				}
+				if tf.PacketsLost != p.lost {
+					t.Errorf("packet %d: PacketsLost = %d, want %d", i, tf.PacketsLost, p.lost)
+				}
+				acceleration := math.Sqrt(float64(tf.AccelerationX*tf.AccelerationX + tf.AccelerationY*tf.AccelerationY + tf.AccelerationZ*tf.AccelerationZ))
+				if math.Abs(acceleration-p.acceleration) > 1e-3 {
+					t.Errorf("packet %d: acceleration = %v, want %v", i, acceleration, p.acceleration)
+				}
+				if tf.Stale() != (p.flags&(FlagDuplicate|FlagReordered) != 0) {
+					t.Errorf("packet %d: Stale() = %v", i, tf.Stale())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package packet
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// FieldUnits is the unit of every TelemetryFrame field, as produced by the Decoder.
+// Fields without a unit (counters, ratios, flags) are missing.
+var FieldUnits = map[string]string{
+	"BestLap":           "ms",
+	"LastLap":           "ms",
+	"FuelCapacity":      "L",
+	"CurrentFuel":       "L",
+	"Boost":             "bar",
+	"TyreDiameterFL":    "m",
+	"TyreDiameterFR":    "m",
+	"TyreDiameterRL":    "m",
+	"TyreDiameterRR":    "m",
+	"TyreSpeedFL":       "km/h",
+	"TyreSpeedFR":       "km/h",
+	"TyreSpeedRL":       "km/h",
+	"TyreSpeedRR":       "km/h",
+	"CarSpeed":          "km/h",
+	"TimeOnTrack":       "ns",
+	"Throttle":          "%",
+	"RPM":               "rpm",
+	"RPMRevWarning":     "rpm",
+	"Brake":             "%",
+	"RPMRevLimiter":     "rpm",
+	"EstimatedTopSpeed": "km/h",
+	"RPMAfterClutch":    "rpm",
+	"OilTemp":           "°C",
+	"WaterTemp":         "°C",
+	"OilPressure":       "bar",
+	"RideHeight":        "mm",
+	"TyreTempFL":        "°C",
+	"TyreTempFR":        "°C",
+	"TyreTempRL":        "°C",
+	"TyreTempRR":        "°C",
+	"SuspensionFL":      "m",
+	"SuspensionFR":      "m",
+	"SuspensionRL":      "m",
+	"SuspensionRR":      "m",
+	"PositionX":         "m",
+	"PositionY":         "m",
+	"PositionZ":         "m",
+	"VelocityX":         "m/s",
+	"VelocityY":         "m/s",
+	"VelocityZ":         "m/s",
+	"AngularVelocityX":  "rad/s",
+	"AngularVelocityY":  "rad/s",
+	"AngularVelocityZ":  "rad/s",
+	"LocalVelocityX":    "m/s",
+	"LocalVelocityY":    "m/s",
+	"LocalVelocityZ":    "m/s",
+	"AccelerationX":     "m/s²",
+	"AccelerationY":     "m/s²",
+	"AccelerationZ":     "m/s²",
+	"GForceX":           "g",
+	"GForceY":           "g",
+	"GForceZ":           "g",
+	"Roll":              "°",
+	"Pitch":             "°",
+	"Yaw":               "°",
+	"YawRateExpected":   "rad/s",
+	"HandlingBalance":   "rad/s",
+	"WheelRotation":     "rad",
+	"ThrottleFiltered":  "%",
+	"BrakeFiltered":     "%",
+}
+
+// UnitSystem selects the units of the streamed values
+type UnitSystem string
+
+const (
+	// Units of FieldUnits, as decoded
+	UnitsMetric UnitSystem = "metric"
+	// mph, °F, psi, feet, inches and US gallons
+	UnitsImperial UnitSystem = "imperial"
+)
+
+// ParseUnitSystem validates a unit system, empty meaning UnitsMetric
+func ParseUnitSystem(s string) (UnitSystem, error) {
+	switch UnitSystem(s) {
+	case "", UnitsMetric:
+		return UnitsMetric, nil
+	case UnitsImperial:
+		return UnitsImperial, nil
+	}
+	return "", fmt.Errorf("unknown unit system %q, expected metric or imperial", s)
+}
+
+// conversion turns a metric value into value*scale + offset in another unit
+type conversion struct {
+	unit          string
+	scale, offset float32
+}
+
+// imperialUnits converts the metric units that have an imperial counterpart
+var imperialUnits = map[string]conversion{
+	"km/h": {"mph", 1 / 1.609344, 0},
+	"°C":   {"°F", 1.8, 32},
+	"bar":  {"psi", 14.503774, 0},
+	"m":    {"ft", 3.28084, 0},
+	"mm":   {"in", 1 / 25.4, 0},
+	"L":    {"gal", 1 / 3.785412, 0},
+}
+
+// Unit returns the unit of a TelemetryFrame field in the unit system
+func (s UnitSystem) Unit(name string) string {
+	if s == UnitsImperial {
+		if c, ok := imperialUnits[FieldUnits[name]]; ok {
+			return c.unit
+		}
+	}
+	return FieldUnits[name]
+}
+
+// Value converts a value of a TelemetryFrame field to the unit system
+func (s UnitSystem) Value(name string, v float32) float32 {
+	if s == UnitsImperial {
+		if c, ok := imperialUnits[FieldUnits[name]]; ok {
+			return v*c.scale + c.offset
+		}
+	}
+	return v
+}
+
+// Length converts a length in metres, e.g. a distance along the lap, to the unit system. Its
+// unit is that of the positions.
+func (s UnitSystem) Length(m float64) float64 {
+	if s == UnitsImperial {
+		return m * float64(imperialUnits["m"].scale)
+	}
+	return m
+}
+
+// Convert returns the frame with its values in the unit system. Integer fields, e.g.
+// EstimatedTopSpeed, are rounded.
+func (s UnitSystem) Convert(tf TelemetryFrame) TelemetryFrame {
+	if s != UnitsImperial {
+		return tf
+	}
+	v := reflect.ValueOf(&tf).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		c, ok := imperialUnits[FieldUnits[t.Field(i).Name]]
+		if !ok {
+			continue
+		}
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Float32:
+			field.SetFloat(float64(float32(field.Float())*c.scale + c.offset))
+		case reflect.Int16, reflect.Int32:
+			field.SetInt(int64(math.Round(float64(field.Int())*float64(c.scale) + float64(c.offset))))
+		}
+	}
+	return tf
+}
@@ -0,0 +1,63 @@
+package packet
+
+import (
+	"math"
+	"testing"
+)
+
+func TestImperialUnits(t *testing.T) {
+	tf := TelemetryFrame{CarSpeed: 160.9344, EstimatedTopSpeed: 300, OilTemp: 100, PositionX: 100, SuspensionFL: 0.1, RideHeight: 50.8}
+	values := map[string]float32{}
+	units := map[string]string{}
+	for _, f := range TelemetryToFields(tf, nil, UnitsImperial) {
+		values[f.Name] = f.At(0).(float32)
+		units[f.Name] = f.Config.Unit
+	}
+
+	tests := []struct {
+		name string
+		unit string
+		want float64
+	}{
+		{name: "CarSpeed", unit: "mph", want: 100},
+		{name: "OilTemp", unit: "°F", want: 212},
+		// Integers are converted too, as they are streamed as floats
+		{name: "EstimatedTopSpeed", unit: "mph", want: 186.4114},
+		{name: "PositionX", unit: "ft", want: 328.084},
+		{name: "SuspensionFL", unit: "ft", want: 0.328084},
+		{name: "RideHeight", unit: "in", want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnitsImperial.Unit(tt.name); got != tt.unit {
+				t.Errorf("Unit(%q) = %q, want %q", tt.name, got, tt.unit)
+			}
+			if got := units[tt.name]; got != GrafanaUnit(tt.unit) {
+				t.Errorf("%s field unit = %q, want %q", tt.name, got, GrafanaUnit(tt.unit))
+			}
+			if got := float64(values[tt.name]); math.Abs(got-tt.want) > 1e-3*math.Max(1, tt.want) {
+				t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+
+	if got := UnitsImperial.Length(1000); math.Abs(got-3280.84) > 1e-2 {
+		t.Errorf("Length(1000) = %v, want 3280.84", got)
+	}
+	if got := UnitsMetric.Length(1000); got != 1000 {
+		t.Errorf("metric Length(1000) = %v, want 1000", got)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tf := UnitsImperial.Convert(TelemetryFrame{CarSpeed: 160.9344, EstimatedTopSpeed: 300, PositionX: 100, RPM: 5000})
+	if math.Abs(float64(tf.CarSpeed)-100) > 1e-3 || tf.EstimatedTopSpeed != 186 || math.Abs(float64(tf.PositionX)-328.084) > 1e-3 {
+		t.Errorf("CarSpeed, EstimatedTopSpeed, PositionX = %v, %v, %v, want 100, 186, 328.084", tf.CarSpeed, tf.EstimatedTopSpeed, tf.PositionX)
+	}
+	if tf.RPM != 5000 {
+		t.Errorf("RPM = %v, want it unchanged", tf.RPM)
+	}
+	if metric := UnitsMetric.Convert(TelemetryFrame{CarSpeed: 100}); metric.CarSpeed != 100 {
+		t.Errorf("metric CarSpeed = %v, want 100", metric.CarSpeed)
+	}
+}
@@ -0,0 +1,88 @@
+package gt7
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+// RelayTarget is a downstream tool (SimHub, gt7dashboard, motion rigs...) that receives a copy
+// of every datagram, so that a single heartbeat session feeds all of them.
+type RelayTarget struct {
+	// host:port, usually port 33740 on the machine running the tool
+	Address string `json:"address"`
+	// Forward decrypted packets instead of the raw datagrams
+	Decrypted bool `json:"decrypted"`
+}
+
+type relayTarget struct {
+	RelayTarget
+	addr *net.UDPAddr
+}
+
+// relay forwards datagrams from its own socket, independently of the server's network
+type relay struct {
+	conn    *net.UDPConn
+	targets []relayTarget
+	// Whether any target needs decrypted packets
+	decrypt bool
+}
+
+func newRelay(targets []RelayTarget) (*relay, error) {
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	r := &relay{}
+	for _, t := range targets {
+		addr, err := net.ResolveUDPAddr("udp", t.Address)
+		if err != nil {
+			return nil, fmt.Errorf("relay address resolution failed for %s: %v", t.Address, err)
+		}
+		r.targets = append(r.targets, relayTarget{RelayTarget: t, addr: addr})
+		r.decrypt = r.decrypt || t.Decrypted
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, fmt.Errorf("relay socket failed: %v", err)
+	}
+	r.conn = conn
+
+	for _, t := range r.targets {
+		log.DefaultLogger.Info("Relaying telemetry", "address", t.addr.String(), "decrypted", t.Decrypted)
+	}
+
+	return r, nil
+}
+
+// forward sends a datagram to every target. Relay failures never stop the server.
+func (r *relay) forward(datagram []byte, stats *DriverStats) {
+	var decrypted []byte
+	if r.decrypt {
+		// Undecryptable datagrams are only forwarded raw
+		decrypted, _ = packet.Decrypt(datagram)
+	}
+
+	for _, t := range r.targets {
+		payload := datagram
+		if t.Decrypted {
+			if decrypted == nil {
+				continue
+			}
+			payload = decrypted
+		}
+
+		if _, err := r.conn.WriteToUDP(payload, t.addr); err != nil {
+			atomic.AddUint64(&stats.RelayErrors, 1)
+			log.DefaultLogger.Debug("Relay failed", "address", t.addr.String(), "err", err.Error())
+		}
+	}
+}
+
+func (r *relay) close() {
+	r.conn.Close()
+}
@@ -0,0 +1,229 @@
+// Package session records raw telemetry datagrams to files and reads them back.
+//
+// A session file starts with the "GT7SESS1" magic, followed by the length (uint32, little
+// endian) of a JSON encoded Metadata. Every record then is the receive time in Unix
+// nanoseconds (int64), the datagram length (uint16) and the encrypted datagram itself, so
+// that sessions can be decoded again as the packet layout gets better understood.
+package session
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
+)
+
+const (
+	magic = "GT7SESS1"
+	// Extension of session files in a Store
+	Extension = ".gt7s"
+
+	maxMetadataSize = 1 << 20
+)
+
+var ErrNotSession = errors.New("not a GT7 session file")
+
+// Metadata describes a recorded session
+type Metadata struct {
+	Driver  string    `json:"driver"`
+	Started time.Time `json:"started"`
+	// Where the session comes from, e.g. "live" or the importer name
+	Source string `json:"source,omitempty"`
+}
+
+// Record is a single datagram of a session
+type Record struct {
+	Time time.Time
+	Data []byte
+}
+
+// Writer appends records to a session file
+type Writer struct {
+	file *os.File
+	w    *bufio.Writer
+}
+
+// Create creates a new session file, truncating it if it exists
+func Create(path string, meta Metadata) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sw, err := NewWriter(f, meta)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	sw.file = f
+
+	return sw, nil
+}
+
+// NewWriter writes a session to w. Close only flushes it unless it was created by Create.
+func NewWriter(w io.Writer, meta Metadata) (*Writer, error) {
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	sw := &Writer{w: bufio.NewWriter(w)}
+	sw.w.WriteString(magic)
+	binary.Write(sw.w, binary.LittleEndian, uint32(len(metaJSON)))
+	if _, err := sw.w.Write(metaJSON); err != nil {
+		return nil, err
+	}
+
+	return sw, nil
+}
+
+// Write appends a datagram received at t
+func (sw *Writer) Write(t time.Time, datagram []byte) error {
+	var header [10]byte
+	binary.LittleEndian.PutUint64(header[0:8], uint64(t.UnixNano()))
+	binary.LittleEndian.PutUint16(header[8:10], uint16(len(datagram)))
+
+	if _, err := sw.w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := sw.w.Write(datagram)
+	return err
+}
+
+// Flush writes buffered records to the underlying writer
+func (sw *Writer) Flush() error {
+	return sw.w.Flush()
+}
+
+func (sw *Writer) Close() error {
+	err := sw.w.Flush()
+	if sw.file != nil {
+		if closeErr := sw.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Reader reads the records of a session
+type Reader struct {
+	Metadata Metadata
+
+	file *os.File
+	r    *bufio.Reader
+}
+
+// Open opens a session file
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sr, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	sr.file = f
+
+	return sr, nil
+}
+
+// NewReader reads a session from r
+func NewReader(r io.Reader) (*Reader, error) {
+	sr := &Reader{r: bufio.NewReader(r)}
+
+	header := make([]byte, len(magic)+4)
+	if _, err := io.ReadFull(sr.r, header); err != nil || string(header[:len(magic)]) != magic {
+		return nil, ErrNotSession
+	}
+
+	metaLen := binary.LittleEndian.Uint32(header[len(magic):])
+	if metaLen > maxMetadataSize {
+		return nil, ErrNotSession
+	}
+	metaJSON := make([]byte, metaLen)
+	if _, err := io.ReadFull(sr.r, metaJSON); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(metaJSON, &sr.Metadata); err != nil {
+		return nil, fmt.Errorf("invalid session metadata: %v", err)
+	}
+
+	return sr, nil
+}
+
+// Next returns the next record, or io.EOF at the end of the session
+func (sr *Reader) Next() (Record, error) {
+	var header [10]byte
+	if _, err := io.ReadFull(sr.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// Truncated by a crash while recording, keep what was complete
+			return Record{}, io.EOF
+		}
+		return Record{}, err
+	}
+
+	data := make([]byte, binary.LittleEndian.Uint16(header[8:10]))
+	if _, err := io.ReadFull(sr.r, data); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return Record{}, io.EOF
+		}
+		return Record{}, err
+	}
+
+	return Record{
+		Time: time.Unix(0, int64(binary.LittleEndian.Uint64(header[0:8]))),
+		Data: data,
+	}, nil
+}
+
+func (sr *Reader) Close() error {
+	if sr.file != nil {
+		return sr.file.Close()
+	}
+	return nil
+}
+
+// ReadSamples decodes every record of a session file. Records that fail to decode, and
+// duplicated or reordered packets, are skipped.
+func ReadSamples(path string) (Metadata, []gt7.Sample, error) {
+	sr, err := Open(path)
+	if err != nil {
+		return Metadata{}, nil, err
+	}
+	defer sr.Close()
+
+	decoder := packet.NewDecoder()
+	var samples []gt7.Sample
+	for {
+		rec, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return sr.Metadata, samples, err
+		}
+
+		frame, err := decoder.ReadPacket(rec.Data)
+		if err != nil || frame.Stale() {
+			continue
+		}
+		samples = append(samples, gt7.Sample{
+			Driver:   sr.Metadata.Driver,
+			Frame:    *frame,
+			Received: rec.Time,
+			Raw:      rec.Data,
+		})
+	}
+
+	return sr.Metadata, samples, nil
+}
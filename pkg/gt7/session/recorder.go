@@ -0,0 +1,82 @@
+package session
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+)
+
+// SessionGap is how long a driver has to stop sending before the next packet starts a new session
+const SessionGap = 5 * time.Minute
+
+const flushInterval = 5 * time.Second
+
+type recording struct {
+	writer    *Writer
+	last      time.Time
+	lastFlush time.Time
+}
+
+// Recorder writes the samples of every driver to a session file in a Store
+type Recorder struct {
+	store      Store
+	recordings map[string]*recording
+}
+
+func NewRecorder(store Store) *Recorder {
+	return &Recorder{
+		store:      store,
+		recordings: map[string]*recording{},
+	}
+}
+
+// Record appends a sample to the current session of its driver
+func (r *Recorder) Record(s gt7.Sample) {
+	if len(s.Raw) == 0 {
+		return
+	}
+
+	rec, ok := r.recordings[s.Driver]
+	if ok && s.Received.Sub(rec.last) > SessionGap {
+		r.closeRecording(s.Driver, rec)
+		ok = false
+	}
+	if !ok {
+		id, w, err := r.store.Create(Metadata{Driver: s.Driver, Started: s.Received, Source: "live"})
+		if err != nil {
+			log.DefaultLogger.Error("Session recording failed", "driver", s.Driver, "error", err)
+			return
+		}
+		log.DefaultLogger.Info("Recording session", "driver", s.Driver, "session", id)
+		rec = &recording{writer: w, lastFlush: s.Received}
+		r.recordings[s.Driver] = rec
+	}
+
+	rec.last = s.Received
+	if err := rec.writer.Write(s.Received, s.Raw); err != nil {
+		log.DefaultLogger.Error("Session recording failed", "driver", s.Driver, "error", err)
+		r.closeRecording(s.Driver, rec)
+		return
+	}
+
+	// Keep the file readable while the session is in progress
+	if s.Received.Sub(rec.lastFlush) >= flushInterval {
+		rec.writer.Flush()
+		rec.lastFlush = s.Received
+	}
+}
+
+func (r *Recorder) closeRecording(driver string, rec *recording) {
+	if err := rec.writer.Close(); err != nil {
+		log.DefaultLogger.Error("Session file close failed", "driver", driver, "error", err)
+	}
+	delete(r.recordings, driver)
+}
+
+// Close ends every session in progress
+func (r *Recorder) Close() {
+	for driver, rec := range r.recordings {
+		r.closeRecording(driver, rec)
+	}
+}
@@ -0,0 +1,153 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+)
+
+const idTimeLayout = "20060102-150405"
+
+var ErrNotFound = errors.New("session not found")
+
+// Info describes a session of a Store
+type Info struct {
+	ID string `json:"id"`
+	Metadata
+	Size int64 `json:"size"`
+	// Last write to the session file, the end of the session unless it was imported
+	Updated time.Time `json:"updated"`
+}
+
+// DefaultDir is where sessions are kept unless configured otherwise: in the Grafana data
+// directory when running as a plugin, in the temporary directory otherwise
+func DefaultDir() string {
+	if dataDir := os.Getenv("GF_PATHS_DATA"); dataDir != "" {
+		return filepath.Join(dataDir, "gt7-sessions")
+	}
+	return filepath.Join(os.TempDir(), "gt7-sessions")
+}
+
+// Store keeps session files in a directory
+type Store struct {
+	Dir string
+}
+
+// NewID returns the ID of a session of driver started at t
+func NewID(driver string, started time.Time) string {
+	return gt7.DriverPath(driver) + "-" + started.UTC().Format(idTimeLayout)
+}
+
+// Path returns the file of a session, and ErrNotFound for IDs that can't be in the store
+func (s Store) Path(id string) (string, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.Dir, id+Extension), nil
+}
+
+// Create creates a new session file in the store, making the directory if needed
+func (s Store) Create(meta Metadata) (string, *Writer, error) {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return "", nil, fmt.Errorf("session directory creation failed: %v", err)
+	}
+
+	// Imports can start several sessions of a driver in the same second
+	base := NewID(meta.Driver, meta.Started)
+	id := base
+	for i := 2; ; i++ {
+		path, err := s.Path(id)
+		if err != nil {
+			return "", nil, err
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			w, err := Create(path, meta)
+			return id, w, err
+		}
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// List returns the sessions of the store, most recent first
+func (s Store) List() ([]Info, error) {
+	entries, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Info{}, nil
+		}
+		return nil, err
+	}
+
+	sessions := []Info{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != Extension {
+			continue
+		}
+
+		sr, err := Open(filepath.Join(s.Dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		sessions = append(sessions, Info{
+			ID:       strings.TrimSuffix(entry.Name(), Extension),
+			Metadata: sr.Metadata,
+			Size:     entry.Size(),
+			Updated:  entry.ModTime(),
+		})
+		sr.Close()
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Started.After(sessions[j].Started) })
+	return sessions, nil
+}
+
+// Samples decodes a session of the store
+func (s Store) Samples(id string) (Metadata, []gt7.Sample, error) {
+	path, err := s.Path(id)
+	if err != nil {
+		return Metadata{}, nil, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return Metadata{}, nil, ErrNotFound
+	}
+	return ReadSamples(path)
+}
+
+// SamplesBetween decodes the samples received from from to to, across the sessions of the
+// store. Only the sessions of driver are read, unless it is empty.
+func (s Store) SamplesBetween(from, to time.Time, driver string) ([]gt7.Sample, error) {
+	sessions, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []gt7.Sample
+	// Oldest first, so that the samples of each driver are in time order
+	for i := len(sessions) - 1; i >= 0; i-- {
+		info := sessions[i]
+		if info.Started.After(to) || info.Updated.Before(from) {
+			continue
+		}
+		if driver != "" && gt7.DriverPath(info.Driver) != gt7.DriverPath(driver) {
+			continue
+		}
+
+		_, read, err := s.Samples(info.ID)
+		if err != nil {
+			return nil, fmt.Errorf("session %s read failed: %v", info.ID, err)
+		}
+		for _, sample := range read {
+			if !sample.Received.Before(from) && !sample.Received.After(to) {
+				samples = append(samples, sample)
+			}
+		}
+	}
+	return samples, nil
+}
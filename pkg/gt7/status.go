@@ -0,0 +1,28 @@
+package gt7
+
+import (
+	"time"
+)
+
+// State of a console, or of the server itself when reported without a driver
+type State string
+
+const (
+	// StateWaiting is reported once the server listens, before the console sent anything
+	StateWaiting State = "waiting"
+	// StateActive is reported when packets (re)start flowing
+	StateActive State = "active"
+	// StateIdle is reported when the console stopped sending, e.g. in menus or asleep
+	StateIdle State = "idle"
+	// StateRestarting is reported by the supervisor while it waits to restart the server
+	StateRestarting State = "restarting"
+)
+
+// Status is a state transition of a console, or of the server when Driver is empty
+type Status struct {
+	Driver string
+	State  State
+	Time   time.Time
+	// Err is the reason of a restart
+	Err error
+}
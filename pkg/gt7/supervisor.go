@@ -0,0 +1,48 @@
+package gt7
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	minRestartBackoff = time.Second
+	maxRestartBackoff = 30 * time.Second
+	// A server running for longer than this is considered healthy, and the backoff is reset
+	stableRunDuration = time.Minute
+)
+
+// SuperviseTelemetryServer runs the telemetry server until ctx is done, restarting it with an
+// exponential backoff whenever it fails. Restarts are reported on statusCh. It only returns
+// once the server has stopped and its socket is closed.
+func SuperviseTelemetryServer(ctx context.Context, cfg ServerConfig, ch chan Sample, statusCh chan Status) {
+	backoff := minRestartBackoff
+
+	for {
+		started := time.Now()
+		err := RunTelemetryServer(ctx, cfg, ch, statusCh)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > stableRunDuration {
+			backoff = minRestartBackoff
+		}
+
+		log.DefaultLogger.Warn("Telemetry server stopped, restarting", "error", err, "backoff", backoff.String())
+		sendStatus(ctx, statusCh, Status{State: StateRestarting, Time: time.Now(), Err: err})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxRestartBackoff {
+			backoff = maxRestartBackoff
+		}
+	}
+}
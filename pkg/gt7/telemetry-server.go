@@ -1,102 +1,243 @@
 package gt7
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
-const (
-	heartbeatPort = "33739"
-	serverPort    = "33740"
-)
+const discoveryTimeout = 3 * time.Second
+
+// Sample is a decoded telemetry frame tagged with the driver whose console sent it
+type Sample struct {
+	Driver string
+	Frame  packet.TelemetryFrame
+	// When the datagram was read from the socket
+	Received time.Time
+	// The encrypted datagram, for recording
+	Raw []byte
+}
+
+// console is the runtime state of a configured console
+type console struct {
+	Console
+	heartbeatAddr *net.UDPAddr
+	decoder       *packet.Decoder
+
+	stats          *DriverStats
+	state          State
+	lastPacketTime time.Time
+}
+
+func (c *console) setState(ctx context.Context, state State, statusCh chan Status) {
+	log.DefaultLogger.Info("Console state changed", "driver", c.Name, "from", c.state, "to", state)
+	c.state = state
+	sendStatus(ctx, statusCh, Status{Driver: c.Name, State: state, Time: time.Now()})
+}
 
-func sendHeartBeat(conn *net.UDPConn) {
-	heartbeatMsg := []byte("A")
-	_, err := conn.Write(heartbeatMsg)
+// sendStatus never blocks past cancellation, so the server can always shut down
+func sendStatus(ctx context.Context, statusCh chan Status, status Status) {
+	select {
+	case statusCh <- status:
+	case <-ctx.Done():
+	}
+}
+
+// sendHeartBeat asks a console for the next seconds of telemetry, in the given packet format
+func sendHeartBeat(conn *net.UDPConn, c *console, format packet.Format) {
+	heartbeatMsg := []byte(format)
+	_, err := conn.WriteToUDP(heartbeatMsg, c.heartbeatAddr)
 	if err != nil {
-		log.DefaultLogger.Warn("SendHeartBeat", "Error sending heartbeat", err)
+		atomic.AddUint64(&c.stats.HeartbeatErrors, 1)
+		log.DefaultLogger.Warn("SendHeartBeat", "Error sending heartbeat", err, "addr", c.heartbeatAddr.String())
 	}
 }
 
-func RunTelemetryServer(playstationIP string, ch chan packet.TelemetryFrame, errCh chan error, hbChan chan *net.UDPConn, strChan chan *net.UDPConn) {
-	isFirstTime := true
-	// Heartbeat connection setup
-	if playstationIP == "" {
-		playstationIP = "192.168.1.5"
+// discoverConsoles is used when no console is configured: every PlayStation found on the
+// local network is streamed, named after its IP.
+func discoverConsoles(ctx context.Context, cfg ServerConfig) ([]Console, error) {
+	found, err := DiscoverPlayStations(ctx, cfg, discoveryTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("no PlayStation configured and discovery failed: %v", err)
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no PlayStation configured and none found on the local network")
+	}
+
+	consoles := make([]Console, 0, len(found))
+	for _, c := range found {
+		log.DefaultLogger.Info("Using discovered PlayStation", "PlaystationIP", c.IP)
+		consoles = append(consoles, Console{Name: c.IP, IP: c.IP})
 	}
-	heartbeatAddr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(playstationIP, heartbeatPort))
+	return consoles, nil
+}
+
+// RunTelemetryServer listens for telemetry from every console on a single socket, keeps
+// their heartbeats going and demultiplexes incoming packets by source address. Consoles that
+// stop sending are reported idle on statusCh, and active again once packets come back.
+// It returns nil once ctx is done, and an error when the listener fails, see
+// SuperviseTelemetryServer for restarts. The socket is always closed on return.
+func RunTelemetryServer(ctx context.Context, cfg ServerConfig, ch chan Sample, statusCh chan Status) error {
+	cfg = cfg.WithDefaults()
+	err := cfg.Validate()
 	if err != nil {
-		errCh <- fmt.Errorf("heartbeat address resolution failed: %v", err)
-		return
+		return err
+	}
+
+	consoles := cfg.Consoles
+	if len(consoles) == 0 {
+		consoles, err = discoverConsoles(ctx, cfg)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
 	}
 
-	heartbeatConn, err := net.DialUDP("udp4", nil, heartbeatAddr)
+	// Heartbeat addresses setup
+	bySource := make(map[string]*console, len(consoles))
+	for _, c := range consoles {
+		heartbeatAddr, err := cfg.heartbeatAddr(c.IP)
+		if err != nil {
+			return fmt.Errorf("heartbeat address resolution failed for %s: %v", c.Name, err)
+		}
+		bySource[heartbeatAddr.IP.String()] = &console{
+			Console:       c,
+			heartbeatAddr: heartbeatAddr,
+			decoder:       packet.NewDecoder(),
+			stats:         cfg.Stats.Driver(c.Name),
+			state:         StateWaiting,
+		}
+	}
+
+	r, err := newRelay(cfg.Relay)
 	if err != nil {
-		errCh <- fmt.Errorf("heartbeat connection failed: %v", err)
-		return
+		return err
+	}
+	if r != nil {
+		defer r.close()
 	}
-	defer heartbeatConn.Close()
-	hbChan <- heartbeatConn
 
 	// Server connection setup
-	serverAddr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort("", serverPort))
+	serverAddr, err := cfg.listenAddr()
 	if err != nil {
-		errCh <- fmt.Errorf("server address resolution failed: %v", err)
-		return
+		return fmt.Errorf("server address resolution failed: %v", err)
 	}
 
-	serverConn, err := net.ListenUDP("udp4", serverAddr)
+	serverConn, err := net.ListenUDP(cfg.Network, serverAddr)
 	if err != nil {
-		errCh <- fmt.Errorf("server listener failed: %v", err)
-		return
+		return fmt.Errorf("server listener failed: %v", err)
 	}
 	defer serverConn.Close()
-	strChan <- serverConn
 
-	log.DefaultLogger.Info("Starting telemetry server for Gran Turismo 7", "PlaystationIP", playstationIP)
+	// Closing the socket unblocks the pending read as soon as ctx is done
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			serverConn.Close()
+		case <-stopped:
+		}
+	}()
+
+	for _, c := range bySource {
+		log.DefaultLogger.Info("Starting telemetry server for Gran Turismo 7", "driver", c.Name, "PlaystationIP", c.IP, "listen", serverConn.LocalAddr().String())
+		sendStatus(ctx, statusCh, Status{Driver: c.Name, State: c.state, Time: time.Now()})
+	}
 
 	buffer := make([]byte, 4096)
-	lastHeartbeatTime := time.Now()
+	lastHeartbeatTime := time.Time{}
 
 	for {
+		// Send heartbeat if a second has passed since the last one
+		if time.Since(lastHeartbeatTime) >= time.Second {
+			for _, c := range bySource {
+				sendHeartBeat(serverConn, c, cfg.Format)
+			}
+			lastHeartbeatTime = time.Now()
+		}
+
+		for _, c := range bySource {
+			if c.state == StateActive && time.Since(c.lastPacketTime) > cfg.IdleTimeout {
+				c.setState(ctx, StateIdle, statusCh)
+			}
+		}
+
 		// Set read deadline to prevent blocking indefinitely
 		err = serverConn.SetReadDeadline(time.Now().Add(time.Second))
+		if ctx.Err() != nil {
+			log.DefaultLogger.Info("Stopping telemetry server")
+			return nil
+		}
 		if err != nil {
-			log.DefaultLogger.Warn("SetReadDeadline failed", "err", err.Error())
-			return
+			return fmt.Errorf("SetReadDeadline failed: %v", err)
 		}
 
-		n, _, err := serverConn.ReadFromUDP(buffer)
+		n, addr, err := serverConn.ReadFromUDP(buffer)
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				// It's just a timeout, continue and send heartbeat if needed
-			} else {
-				log.DefaultLogger.Warn("ReadFromUDP failed", "err", err.Error())
-				return
+				continue
+			}
+			if ctx.Err() != nil {
+				log.DefaultLogger.Info("Stopping telemetry server")
+				return nil
 			}
+			return fmt.Errorf("ReadFromUDP failed: %v", err)
 		}
 
-		if n > 0 {
-			fmt.Printf("Read %v bytes\n", n)
-			packetBuffer := buffer[0:n]
-			p, err := packet.ReadPacket(packetBuffer)
-			if isFirstTime {
-				isFirstTime = false
-			}
-			if err != nil {
-				log.DefaultLogger.Warn("ReadPacket failed", "err", err.Error())
-				return
+		received := time.Now()
+		c, ok := bySource[addr.IP.String()]
+		if !ok {
+			cfg.Stats.addDatagram(UnknownDriver, n)
+			log.DefaultLogger.Debug("Ignoring packet from unknown source", "addr", addr.String())
+			continue
+		}
+		cfg.Stats.addDatagram(c.Name, n)
+		if r != nil {
+			r.forward(buffer[0:n], c.stats)
+		}
+
+		p, err := c.decoder.ReadPacket(buffer[0:n])
+		if err != nil {
+			if errors.Is(err, packet.ErrMagicMismatch) {
+				atomic.AddUint64(&c.stats.MagicMismatches, 1)
+			} else {
+				atomic.AddUint64(&c.stats.DecodeFailures, 1)
 			}
-			ch <- *p
+			// A corrupted datagram doesn't affect the following ones
+			log.DefaultLogger.Warn("ReadPacket failed", "driver", c.Name, "err", err.Error())
+			continue
 		}
 
-		// Send heartbeat if a second has passed since the last one
-		if time.Since(lastHeartbeatTime) >= time.Second {
-			sendHeartBeat(heartbeatConn)
-			lastHeartbeatTime = time.Now()
+		atomic.AddUint64(&c.stats.PacketsLost, uint64(p.PacketsLost))
+		if p.PacketFlags&packet.FlagDuplicate != 0 {
+			atomic.AddUint64(&c.stats.Duplicates, 1)
+		}
+		if p.PacketFlags&packet.FlagReordered != 0 {
+			atomic.AddUint64(&c.stats.Reordered, 1)
+		}
+		if p.Stale() {
+			continue
+		}
+
+		c.lastPacketTime = time.Now()
+		if c.state != StateActive {
+			c.setState(ctx, StateActive, statusCh)
+		}
+
+		select {
+		case ch <- Sample{Driver: c.Name, Frame: *p, Received: received, Raw: append([]byte(nil), buffer[0:n]...)}:
+		case <-ctx.Done():
+			log.DefaultLogger.Info("Stopping telemetry server")
+			return nil
 		}
 	}
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+)
+
+// subscription receives the samples and state transitions of the shared telemetry server
+type subscription struct {
+	samples  chan gt7.Sample
+	statuses chan gt7.Status
+}
+
+// telemetryHub shares a single telemetry server, and thus a single UDP listener, between
+// every stream opened on the datasource. The server runs while at least one stream is open.
+type telemetryHub struct {
+	config gt7.ServerConfig
+
+	mu            sync.Mutex
+	closed        bool
+	cancel        context.CancelFunc
+	done          chan struct{}
+	subscriptions map[*subscription]struct{}
+	// Latest status of every driver, replayed to new subscriptions
+	statuses map[string]gt7.Status
+}
+
+func newTelemetryHub(config gt7.ServerConfig) *telemetryHub {
+	return &telemetryHub{
+		config:        config,
+		subscriptions: map[*subscription]struct{}{},
+		statuses:      map[string]gt7.Status{},
+	}
+}
+
+// subscribe registers a new subscription, starting the telemetry server if it isn't running
+func (h *telemetryHub) subscribe() *subscription {
+	sub := &subscription{
+		samples:  make(chan gt7.Sample, 60),
+		statuses: make(chan gt7.Status, 16),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.subscriptions[sub] = struct{}{}
+	for _, status := range h.statuses {
+		select {
+		case sub.statuses <- status:
+		default:
+		}
+	}
+
+	if h.cancel == nil && !h.closed {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		// A server that is still shutting down holds the port, wait for it first
+		go h.run(ctx, h.done, done)
+		h.cancel = cancel
+		h.done = done
+	}
+
+	return sub
+}
+
+// unsubscribe removes a subscription, stopping the telemetry server after the last one
+func (h *telemetryHub) unsubscribe(sub *subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subscriptions, sub)
+	if len(h.subscriptions) == 0 {
+		h.stopLocked()
+	}
+}
+
+func (h *telemetryHub) stopLocked() {
+	if h.cancel != nil {
+		h.cancel()
+		h.cancel = nil
+		h.statuses = map[string]gt7.Status{}
+	}
+}
+
+func (h *telemetryHub) run(ctx context.Context, previous chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	if previous != nil {
+		<-previous
+	}
+
+	samples := make(chan gt7.Sample)
+	statuses := make(chan gt7.Status)
+	supervisorDone := make(chan struct{})
+
+	go func() {
+		defer close(supervisorDone)
+		gt7.SuperviseTelemetryServer(ctx, h.config, samples, statuses)
+	}()
+
+	for {
+		select {
+		case <-supervisorDone:
+			log.DefaultLogger.Info("Telemetry server stopped")
+			return
+
+		case sample := <-samples:
+			h.mu.Lock()
+			for sub := range h.subscriptions {
+				select {
+				case sub.samples <- sample:
+				default:
+					// Slow subscriber, drop the sample rather than stalling everyone else
+				}
+			}
+			h.mu.Unlock()
+
+		case status := <-statuses:
+			if status.Err != nil {
+				log.DefaultLogger.Error("Error from telemetry server", "error", status.Err)
+			}
+			h.mu.Lock()
+			if ctx.Err() != nil {
+				// Stale status from a server being stopped
+				h.mu.Unlock()
+				continue
+			}
+			h.statuses[status.Driver] = status
+			for sub := range h.subscriptions {
+				select {
+				case sub.statuses <- status:
+				default:
+				}
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// close stops the telemetry server for good and waits until its socket is released
+func (h *telemetryHub) close() {
+	h.mu.Lock()
+	h.closed = true
+	h.stopLocked()
+	done := h.done
+	h.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"os"
+	"sync"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
 )
 
 const PLUGIN_ID = "gt7-telemetry"
@@ -23,3 +26,35 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// Telemetry server metrics of every datasource instance, served through the plugin's metrics
+// endpoint. A settings change may create the new instance before the old one is disposed, so
+// disposing only unregisters the counters that are still the latest of their datasource.
+var (
+	statsMu         sync.Mutex
+	registeredStats = map[string]*gt7.Stats{}
+)
+
+func registerStats(uid string, stats *gt7.Stats) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	if previous, ok := registeredStats[uid]; ok {
+		prometheus.Unregister(previous)
+	}
+	if err := prometheus.Register(stats); err != nil {
+		log.DefaultLogger.Warn("Registering telemetry metrics failed", "error", err)
+	}
+	registeredStats[uid] = stats
+}
+
+func unregisterStats(uid string, stats *gt7.Stats) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	if registeredStats[uid] != stats {
+		return
+	}
+	prometheus.Unregister(stats)
+	delete(registeredStats, uid)
+}
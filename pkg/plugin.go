@@ -3,9 +3,16 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/influx"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/mqtt"
 	"github.com/splicer3/grafana-gt7/pkg/gt7/packet"
-	"net"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/session"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -19,11 +26,81 @@ var (
 	_ backend.QueryDataHandler      = (*GT7TelemetryDatasource)(nil)
 	_ backend.CheckHealthHandler    = (*GT7TelemetryDatasource)(nil)
 	_ backend.StreamHandler         = (*GT7TelemetryDatasource)(nil)
+	_ backend.CallResourceHandler   = (*GT7TelemetryDatasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*GT7TelemetryDatasource)(nil)
 )
 
 type Options struct {
-	PlaystationIP string `json:"playstationIP"`
+	PlaystationIP string        `json:"playstationIP"`
+	Consoles      []gt7.Console `json:"consoles"`
+	HeartbeatPort int           `json:"heartbeatPort"`
+	ServerPort    int           `json:"serverPort"`
+	BindAddress   string        `json:"bindAddress"`
+	Network       string        `json:"network"`
+	// Packet format requested by the heartbeats: A, B or ~
+	PacketFormat packet.Format `json:"packetFormat"`
+	// Units of the streamed values: metric (default) or imperial. Exports and exporters stay metric.
+	UnitSystem packet.UnitSystem `json:"unitSystem"`
+	// Downstream tools receiving a copy of every datagram
+	Relay []gt7.RelayTarget `json:"relay"`
+	// Keeps the telemetry server running and serves the latest values on the metrics resource
+	PrometheusExporter bool `json:"prometheusExporter"`
+	// Line protocol destination, writing is disabled when empty. The token is a secure field.
+	InfluxURL         string            `json:"influxURL"`
+	InfluxMeasurement string            `json:"influxMeasurement"`
+	InfluxTags        map[string]string `json:"influxTags"`
+	// MQTT broker, publishing is disabled when empty. The password is a secure field.
+	MQTTBroker      string  `json:"mqttBroker"`
+	MQTTUsername    string  `json:"mqttUsername"`
+	MQTTTopicPrefix string  `json:"mqttTopicPrefix"`
+	MQTTFrameRate   float64 `json:"mqttFrameRate"`
+	MQTTFieldTopics bool    `json:"mqttFieldTopics"`
+	// Records every datagram to session files, which can then be exported
+	RecordSessions bool   `json:"recordSessions"`
+	SessionsDir    string `json:"sessionsDir"`
+}
+
+// sessionsDir returns where session files are kept, by default in the Grafana data directory
+func (o *Options) sessionsDir() string {
+	if o.SessionsDir != "" {
+		return o.SessionsDir
+	}
+	return session.DefaultDir()
+}
+
+// Name given to the console configured through the legacy PlaystationIP option
+const defaultDriverName = "PlayStation"
+
+// consoleList returns the configured consoles, falling back to the single PlaystationIP
+// option for datasources created before multiple consoles were supported.
+func (o *Options) consoleList() ([]gt7.Console, error) {
+	if len(o.Consoles) == 0 {
+		if o.PlaystationIP == "" {
+			return nil, nil
+		}
+		return []gt7.Console{{Name: defaultDriverName, IP: o.PlaystationIP}}, nil
+	}
+
+	paths := map[string]bool{}
+	consoles := make([]gt7.Console, 0, len(o.Consoles))
+	for _, c := range o.Consoles {
+		if c.IP == "" {
+			continue
+		}
+		if c.Name == "" {
+			c.Name = c.IP
+		}
+		if paths[c.Path()] {
+			return nil, fmt.Errorf("duplicate driver name %q", c.Name)
+		}
+		if c.Path() == diagnosticsPath {
+			return nil, fmt.Errorf("driver name %q is reserved", c.Name)
+		}
+		paths[c.Path()] = true
+		consoles = append(consoles, c)
+	}
+
+	return consoles, nil
 }
 
 func getDatasourceSettings(s backend.DataSourceInstanceSettings) (*Options, error) {
@@ -43,32 +120,165 @@ func NewGT7TelemetryDatasource(s backend.DataSourceInstanceSettings) (instancemg
 		return nil, err
 	}
 
-	return &GT7TelemetryDatasource{
-		playstationIP: settings.PlaystationIP,
-		streamConn:    nil,
-		heartbeatConn: nil,
-	}, nil
+	consoles, err := settings.consoleList()
+	if err != nil {
+		return nil, err
+	}
+
+	serverConfig := gt7.ServerConfig{
+		Consoles:      consoles,
+		HeartbeatPort: settings.HeartbeatPort,
+		ServerPort:    settings.ServerPort,
+		BindAddress:   settings.BindAddress,
+		Network:       settings.Network,
+		Relay:         settings.Relay,
+		Format:        settings.PacketFormat,
+		Stats:         gt7.NewDatasourceStats(s.UID),
+	}.WithDefaults()
+	if err := serverConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	units, err := packet.ParseUnitSystem(string(settings.UnitSystem))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ds := &GT7TelemetryDatasource{
+		uid:          s.UID,
+		consoles:     consoles,
+		serverConfig: serverConfig,
+		units:        units,
+		hub:          newTelemetryHub(serverConfig),
+		ctx:          ctx,
+		cancel:       cancel,
+		sessions:     session.Store{Dir: settings.sessionsDir()},
+	}
+
+	if len(settings.Relay) > 0 {
+		// Downstream tools expect telemetry even when no panel is open
+		ds.runSubscriber("relay", func(gt7.Sample) {}, nil)
+	}
+
+	if settings.PrometheusExporter {
+		ds.exporter = gt7.NewTelemetryExporter()
+		ds.metricsRegistry = prometheus.NewRegistry()
+		ds.metricsRegistry.MustRegister(ds.exporter)
+		ds.runSubscriber("prometheus", ds.exporter.Observe, nil)
+	}
+
+	if settings.InfluxURL != "" {
+		writer, err := influx.NewWriter(influx.Config{
+			URL:         settings.InfluxURL,
+			Token:       s.DecryptedSecureJSONData["influxToken"],
+			Measurement: settings.InfluxMeasurement,
+			Tags:        settings.InfluxTags,
+		})
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		ds.runBackground(writer.Run)
+		ds.runSubscriber("influx", writer.Write, nil)
+	}
+
+	if settings.MQTTBroker != "" {
+		publisher, err := mqtt.NewPublisher(mqtt.Config{
+			Broker:      settings.MQTTBroker,
+			ClientID:    "grafana-gt7-" + s.UID,
+			Username:    settings.MQTTUsername,
+			Password:    s.DecryptedSecureJSONData["mqttPassword"],
+			TopicPrefix: settings.MQTTTopicPrefix,
+			FrameRate:   settings.MQTTFrameRate,
+			FieldTopics: settings.MQTTFieldTopics,
+		})
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		ds.runBackground(publisher.Run)
+		ds.runSubscriber("mqtt", publisher.Publish, publisher.PublishStatus)
+	}
+
+	if settings.RecordSessions {
+		ds.recorder = session.NewRecorder(ds.sessions)
+		ds.runSubscriber("sessions", ds.recorder.Record, nil)
+	}
+
+	ds.resourceHandler = newResourceHandler(ds)
+	registerStats(s.UID, serverConfig.Stats)
+
+	return ds, nil
 }
 
 // GT7TelemetryDatasource is an example datasource which can respond to data queries, reports
 // its health and has streaming skills.
 type GT7TelemetryDatasource struct {
-	playstationIP string
-	streamConn    *net.UDPConn
-	heartbeatConn *net.UDPConn
+	uid          string
+	consoles     []gt7.Console
+	serverConfig gt7.ServerConfig
+	hub          *telemetryHub
+	units        packet.UnitSystem
+
+	// Background subscribers run until the datasource is disposed
+	ctx         context.Context
+	cancel      context.CancelFunc
+	subscribers sync.WaitGroup
+
+	exporter        *gt7.TelemetryExporter
+	metricsRegistry *prometheus.Registry
+
+	sessions session.Store
+	recorder *session.Recorder
+
+	resourceHandler backend.CallResourceHandler
 }
 
-func (d *GT7TelemetryDatasource) Dispose() {
-	// Clean up datasource instance resources.
-	if d.streamConn != nil {
-		d.streamConn.Close()
-		d.streamConn = nil
-	}
+// runBackground runs fn until the datasource is disposed
+func (d *GT7TelemetryDatasource) runBackground(fn func(ctx context.Context)) {
+	d.subscribers.Add(1)
+	go func() {
+		defer d.subscribers.Done()
+		fn(d.ctx)
+	}()
+}
+
+// runSubscriber feeds every sample and status (when onStatus isn't nil) to the handlers in
+// the background, keeping the telemetry server running until the datasource is disposed.
+func (d *GT7TelemetryDatasource) runSubscriber(name string, onSample func(gt7.Sample), onStatus func(gt7.Status)) {
+	sub := d.hub.subscribe()
+	log.DefaultLogger.Info("Starting background subscriber", "name", name)
+
+	d.subscribers.Add(1)
+	go func() {
+		defer d.subscribers.Done()
+		defer d.hub.unsubscribe(sub)
+
+		for {
+			select {
+			case <-d.ctx.Done():
+				return
+			case sample := <-sub.samples:
+				onSample(sample)
+			case status := <-sub.statuses:
+				if onStatus != nil {
+					onStatus(status)
+				}
+			}
+		}
+	}()
+}
 
-	if d.heartbeatConn != nil {
-		d.heartbeatConn.Close()
-		d.heartbeatConn = nil
+func (d *GT7TelemetryDatasource) Dispose() {
+	// Clean up datasource instance resources. Blocks until the telemetry server has released its socket.
+	d.cancel()
+	d.subscribers.Wait()
+	if d.recorder != nil {
+		d.recorder.Close()
 	}
+	d.hub.close()
+	unregisterStats(d.uid, d.serverConfig.Stats)
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -96,6 +306,10 @@ func (d *GT7TelemetryDatasource) QueryData(ctx context.Context, req *backend.Que
 type queryModel struct {
 	WithStreaming bool   `json:"withStreaming"`
 	Telemetry     string `json:"telemetry"`
+	// Driver of the analysis queries, every driver when empty
+	Driver string `json:"driver"`
+	// Lap of the analysis queries, every lap when 0
+	Lap int `json:"lap"`
 }
 
 func (d *GT7TelemetryDatasource) query(_ context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
@@ -109,6 +323,10 @@ func (d *GT7TelemetryDatasource) query(_ context.Context, pCtx backend.PluginCon
 		return response
 	}
 
+	if query.QueryType != "" {
+		return d.analysisQuery(query, qm)
+	}
+
 	// create data frame response.
 	frame := data.NewFrame("response")
 
@@ -120,17 +338,11 @@ func (d *GT7TelemetryDatasource) query(_ context.Context, pCtx backend.PluginCon
 
 	// If query called with streaming on then return a channel
 	// to subscribe on a client-side and consume updates from a plugin.
-	// Feel free to remove this if you don't need streaming for your datasource.
-	//streamPath := qm.Telemetry
-	//if streamPath == "" {
-	//	streamPath = "stream"
-	//}
-	streamPath := "dirt"
 	if qm.WithStreaming {
 		channel := live.Channel{
 			Scope:     live.ScopeDatasource,
 			Namespace: pCtx.DataSourceInstanceSettings.UID,
-			Path:      streamPath,
+			Path:      "gt7",
 		}
 		frame.SetMeta(&data.FrameMeta{Channel: channel.String()})
 	}
@@ -157,6 +369,11 @@ func (d *GT7TelemetryDatasource) CheckHealth(_ context.Context, req *backend.Che
 	}, nil
 }
 
+// CallResource handles the HTTP resources exposed by the plugin, see resources.go.
+func (d *GT7TelemetryDatasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	return d.resourceHandler.CallResource(ctx, req, sender)
+}
+
 // SubscribeStream is called when a client wants to connect to a stream. This callback
 // allows sending the first message.
 func (d *GT7TelemetryDatasource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
@@ -169,21 +386,47 @@ func (d *GT7TelemetryDatasource) SubscribeStream(_ context.Context, req *backend
 }
 
 // RunStream is called once for any open channel. Results are shared with everyone
-// subscribed to the same channel.
+// subscribed to the same channel. The "gt7" path streams every console in a combined frame,
+// "gt7/<driver>" streams a single driver. Frames carry a "status" field with the console
+// state, and are also sent on state transitions so that panels notice idle consoles.
 func (d *GT7TelemetryDatasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
 	log.DefaultLogger.Info("RunStream called", "request", req)
 
-	heartbeatConnChan := make(chan *net.UDPConn)
-	streamConnChan := make(chan *net.UDPConn)
-	gt7TelemetryChan := make(chan packet.TelemetryFrame)
-	gt7TelemetryErrorChan := make(chan error)
+	if req.Path == "gt7/"+diagnosticsPath {
+		return d.runDiagnosticsStream(ctx, sender)
+	}
 
-	if req.Path == "gt7" {
-		go gt7.RunTelemetryServer(d.playstationIP, gt7TelemetryChan, gt7TelemetryErrorChan, heartbeatConnChan, streamConnChan)
+	driver := ""
+	if req.Path != "gt7" {
+		if !strings.HasPrefix(req.Path, "gt7/") {
+			return fmt.Errorf("unknown stream path %q", req.Path)
+		}
+		driver = strings.TrimPrefix(req.Path, "gt7/")
+		if !d.hasDriver(driver) {
+			return fmt.Errorf("unknown driver %q", driver)
+		}
 	}
 
+	// Label fields with the driver when several consoles share the frame
+	labelled := driver == "" && len(d.consoles) != 1
+
+	sub := d.hub.subscribe()
+	defer d.hub.unsubscribe(sub)
+
+	// Latest sample and state of every driver, for the combined frame
+	latest := map[string]packet.TelemetryFrame{}
+	states := map[string]gt7.State{}
 	lastTimeSent := time.Now()
 
+	sendFrame := func() {
+		frame := combinedFrame(latest, states, labelled, d.units)
+		lastTimeSent = time.Now()
+		err := sender.SendFrame(frame, data.IncludeAll)
+		if err != nil {
+			log.DefaultLogger.Error("Error sending frame", "error", err)
+		}
+	}
+
 	// Stream data frames periodically till stream closed by Grafana.
 	for {
 		select {
@@ -191,31 +434,70 @@ func (d *GT7TelemetryDatasource) RunStream(ctx context.Context, req *backend.Run
 			log.DefaultLogger.Info("Context done, finish streaming", "path", req.Path)
 			return nil
 
-		case telemetryFrame := <-gt7TelemetryChan:
+		case sample := <-sub.samples:
+			if driver != "" && gt7.DriverPath(sample.Driver) != driver {
+				continue
+			}
+			latest[sample.Driver] = sample.Frame
+
 			if time.Now().Before(lastTimeSent.Add(time.Second / 60)) {
 				// Drop frame
+				d.serverConfig.Stats.AddFramesDropped(sample.Driver, 1)
 				continue
 			}
+			sendFrame()
+			d.serverConfig.Stats.ObserveLatency(sample.Driver, sample.Received)
 
-			frame := packet.TelemetryToDataFrame(telemetryFrame)
-			lastTimeSent = time.Now()
-			err := sender.SendFrame(frame, data.IncludeAll)
-			if err != nil {
-				log.DefaultLogger.Error("Error sending frame", "error", err)
+		case status := <-sub.statuses:
+			if status.Driver == "" || (driver != "" && gt7.DriverPath(status.Driver) != driver) {
 				continue
 			}
+			states[status.Driver] = status.State
+			if _, ok := latest[status.Driver]; ok {
+				sendFrame()
+			}
+		}
+	}
+}
 
-		case hbConn := <-heartbeatConnChan:
-			d.heartbeatConn = hbConn
+// hasDriver tells whether a console streams on the "gt7/<path>" channel
+func (d *GT7TelemetryDatasource) hasDriver(path string) bool {
+	for _, c := range d.consoles {
+		if c.Path() == path {
+			return true
+		}
+	}
+	return false
+}
+
+// combinedFrame puts the latest frame of every driver side by side. Single console
+// dashboards aren't labelled, so that they keep their plain field names.
+func combinedFrame(latest map[string]packet.TelemetryFrame, states map[string]gt7.State, labelled bool, units packet.UnitSystem) *data.Frame {
+	frame := data.NewFrame("response")
+	frame.Fields = append(frame.Fields,
+		data.NewField("time", nil, []time.Time{time.Now()}),
+	)
 
-		case strConn := <-streamConnChan:
-			d.streamConn = strConn
+	drivers := make([]string, 0, len(latest))
+	for name := range latest {
+		drivers = append(drivers, name)
+	}
+	sort.Strings(drivers)
 
-		case err := <-gt7TelemetryErrorChan:
-			log.DefaultLogger.Error("Error from telemetry server", "error", err)
-			return err
+	if len(drivers) == 1 {
+		frame.Name = drivers[0]
+	}
+
+	for _, name := range drivers {
+		var labels data.Labels
+		if labelled {
+			labels = data.Labels{"driver": name}
 		}
+		frame.Fields = append(frame.Fields, packet.TelemetryToFields(latest[name], labels, units)...)
+		frame.Fields = append(frame.Fields, data.NewField("status", labels, []string{string(states[name])}))
 	}
+
+	return frame
 }
 
 // PublishStream is called when a client sends a message to the stream.
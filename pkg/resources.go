@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/splicer3/grafana-gt7/pkg/gt7"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/export"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/importer"
+	"github.com/splicer3/grafana-gt7/pkg/gt7/session"
+)
+
+const (
+	defaultDiscoveryTimeout = 3 * time.Second
+	maxDiscoveryTimeout     = 15 * time.Second
+)
+
+func newResourceHandler(d *GT7TelemetryDatasource) backend.CallResourceHandler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/discover", d.handleDiscover)
+	mux.HandleFunc("/metrics", d.handleMetrics)
+	mux.HandleFunc("/sessions", d.handleSessions)
+	mux.HandleFunc("/sessions/export", d.handleExport)
+	mux.HandleFunc("/sessions/import", d.handleImport)
+
+	return httpadapter.New(mux)
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		log.DefaultLogger.Error("Error writing resource response", "error", err)
+	}
+}
+
+func writeError(rw http.ResponseWriter, status int, err error) {
+	writeJSON(rw, status, map[string]string{"error": err.Error()})
+}
+
+// handleDiscover looks for PlayStations on the local network, so that the config editor
+// can offer them as a pick-list. The optional "timeout" parameter is a Go duration.
+func (d *GT7TelemetryDatasource) handleDiscover(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	timeout := defaultDiscoveryTimeout
+	if t := req.URL.Query().Get("timeout"); t != "" {
+		parsed, err := time.ParseDuration(t)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+		timeout = parsed
+	}
+	if timeout > maxDiscoveryTimeout {
+		timeout = maxDiscoveryTimeout
+	}
+
+	consoles, err := gt7.DiscoverPlayStations(req.Context(), d.serverConfig, timeout)
+	if err != nil {
+		log.DefaultLogger.Warn("Discovery failed", "error", err)
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, map[string]interface{}{"consoles": consoles})
+}
+
+// handleMetrics serves the latest telemetry values in the Prometheus text format, when the
+// exporter is enabled on the datasource.
+func (d *GT7TelemetryDatasource) handleMetrics(rw http.ResponseWriter, req *http.Request) {
+	if d.metricsRegistry == nil {
+		writeError(rw, http.StatusNotFound, fmt.Errorf("prometheus exporter is disabled on this datasource"))
+		return
+	}
+
+	promhttp.HandlerFor(d.metricsRegistry, promhttp.HandlerOpts{}).ServeHTTP(rw, req)
+}
+
+// handleSessions lists the recorded sessions
+func (d *GT7TelemetryDatasource) handleSessions(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessions, err := d.sessions.List()
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, map[string]interface{}{"sessions": sessions})
+}
+
+// lapRange parses the optional "fromLap" and "toLap" parameters
+func lapRange(req *http.Request) (int, int, error) {
+	var laps [2]int
+	for i, name := range []string{"fromLap", "toLap"} {
+		v := req.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		lap, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid %s: %v", name, err)
+		}
+		laps[i] = lap
+	}
+	return laps[0], laps[1], nil
+}
+
+// handleExport downloads the session given by the "id" parameter, or the laps from "fromLap"
+// to "toLap", as CSV, Parquet or a zip of MoTeC files depending on the "format" parameter.
+// CSV headers include units when "units" is true.
+func (d *GT7TelemetryDatasource) handleExport(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	format, err := export.ParseFormat(query.Get("format"))
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	fromLap, toLap, err := lapRange(req)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	id := query.Get("id")
+	_, samples, err := d.sessions.Samples(id)
+	if err == session.ErrNotFound {
+		writeError(rw, http.StatusNotFound, fmt.Errorf("session %q not found", id))
+		return
+	}
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	samples = export.FilterLaps(samples, fromLap, toLap)
+
+	rw.Header().Set("Content-Type", format.ContentType())
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+format.Extension()))
+	switch format {
+	case export.FormatCSV:
+		err = export.WriteCSV(rw, samples, query.Get("units") == "true")
+	case export.FormatParquet:
+		err = export.WriteParquet(rw, samples)
+	case export.FormatMoTeC:
+		err = export.WriteMoTeCArchive(rw, id, samples)
+	}
+	if err != nil {
+		log.DefaultLogger.Error("Session export failed", "session", id, "error", err)
+	}
+}
+
+// handleImport stores the capture posted as the request body as new sessions. The "format"
+// parameter is one of importer.Formats, "driver" optionally names the driver.
+func (d *GT7TelemetryDatasource) handleImport(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	format, err := importer.ParseFormat(query.Get("format"))
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	ids, err := importer.Import(d.sessions, req.Body, format, importer.Options{Driver: query.Get("driver")})
+	if err != nil {
+		log.DefaultLogger.Warn("Session import failed", "format", format, "error", err)
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	log.DefaultLogger.Info("Sessions imported", "format", format, "sessions", ids)
+	writeJSON(rw, http.StatusOK, map[string]interface{}{"sessions": ids})
+}
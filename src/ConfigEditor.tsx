@@ -1,9 +1,27 @@
-import React, { ChangeEvent } from 'react';
-import { FieldSet, InlineField, InlineFieldRow, Input } from '@grafana/ui';
-import { DataSourcePluginOptionsEditorProps } from '@grafana/data';
-import { MyDataSourceOptions } from './types';
+import React, { ChangeEvent, SyntheticEvent, useState } from 'react';
+import { Button, FieldSet, InlineField, InlineFieldRow, InlineSwitch, Input, Select } from '@grafana/ui';
+import { DataSourcePluginOptionsEditorProps, SelectableValue } from '@grafana/data';
+import { getBackendSrv } from '@grafana/runtime';
+import { ConsoleOptions, DiscoveredConsole, MyDataSourceOptions, MySecureJsonData, RelayTarget } from './types';
 
-interface Props extends DataSourcePluginOptionsEditorProps<MyDataSourceOptions> {}
+const networkOptions = [
+  { label: 'IPv4', value: 'udp4' },
+  { label: 'IPv6', value: 'udp6' },
+  { label: 'Dual stack', value: 'udp' },
+];
+
+const packetFormatOptions = [
+  { label: 'A', value: 'A', description: 'Base telemetry' },
+  { label: 'B', value: 'B', description: 'Adds wheel rotation, sway, heave and surge' },
+  { label: '~', value: '~', description: 'Adds filtered throttle and brake, torque vectoring and energy recovery' },
+];
+
+const unitSystemOptions = [
+  { label: 'Metric', value: 'metric', description: 'km/h, °C, bar, mm, litres' },
+  { label: 'Imperial', value: 'imperial', description: 'mph, °F, psi, inches, US gallons' },
+];
+
+interface Props extends DataSourcePluginOptionsEditorProps<MyDataSourceOptions, MySecureJsonData> {}
 
 export function ConfigEditor(props: Props) {
   const {
@@ -12,32 +30,388 @@ export function ConfigEditor(props: Props) {
     options: { jsonData },
   } = props;
 
+  const [discovering, setDiscovering] = useState(false);
+  const [discovered, setDiscovered] = useState<Array<SelectableValue<string>>>([]);
+  const [discoveryError, setDiscoveryError] = useState<string | undefined>();
+
+  const setPlaystationIP = (playstationIP: string) => {
+    const jsonData = {
+      ...options.jsonData,
+      playstationIP,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
   const onPlaystationIPChange = (event: ChangeEvent<HTMLInputElement>) => {
+    setPlaystationIP(event.target.value);
+  };
+
+  const setConsoles = (consoles: ConsoleOptions[]) => {
+    const jsonData = {
+      ...options.jsonData,
+      consoles,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const consoles = jsonData.consoles || [];
+
+  const onConsoleChange = (index: number, key: keyof ConsoleOptions) => (event: ChangeEvent<HTMLInputElement>) => {
+    setConsoles(consoles.map((c, i) => (i === index ? { ...c, [key]: event.target.value } : c)));
+  };
+
+  const onAddConsole = () => {
+    setConsoles([...consoles, { name: '', ip: '' }]);
+  };
+
+  const onRemoveConsole = (index: number) => () => {
+    setConsoles(consoles.filter((_, i) => i !== index));
+  };
+
+  const relay = jsonData.relay || [];
+
+  const setRelay = (relay: RelayTarget[]) => {
+    const jsonData = {
+      ...options.jsonData,
+      relay,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onRelayAddressChange = (index: number) => (event: ChangeEvent<HTMLInputElement>) => {
+    setRelay(relay.map((r, i) => (i === index ? { ...r, address: event.target.value } : r)));
+  };
+
+  const onRelayDecryptedChange = (index: number) => (event: SyntheticEvent<HTMLInputElement>) => {
+    const decrypted = event.currentTarget.checked;
+    setRelay(relay.map((r, i) => (i === index ? { ...r, decrypted } : r)));
+  };
+
+  const onPortChange = (key: 'heartbeatPort' | 'serverPort') => (event: ChangeEvent<HTMLInputElement>) => {
+    const port = parseInt(event.target.value, 10);
+    const jsonData = {
+      ...options.jsonData,
+      [key]: isNaN(port) ? undefined : port,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onBindAddressChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const jsonData = {
+      ...options.jsonData,
+      bindAddress: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onNetworkChange = (option: SelectableValue<string>) => {
+    const jsonData = {
+      ...options.jsonData,
+      network: option.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onPacketFormatChange = (option: SelectableValue<string>) => {
     const jsonData = {
       ...options.jsonData,
-      playstationIP: event.target.value,
+      packetFormat: option.value,
     };
     onOptionsChange({ ...options, jsonData });
   };
 
-  const { playstationIP } = jsonData;
+  const onUnitSystemChange = (option: SelectableValue<string>) => {
+    const jsonData = {
+      ...options.jsonData,
+      unitSystem: option.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onSwitchChange = (key: 'prometheusExporter' | 'mqttFieldTopics' | 'recordSessions') => (event: SyntheticEvent<HTMLInputElement>) => {
+    const jsonData = {
+      ...options.jsonData,
+      [key]: event.currentTarget.checked,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onTextChange = (
+    key: 'influxURL' | 'influxMeasurement' | 'mqttBroker' | 'mqttUsername' | 'mqttTopicPrefix' | 'sessionsDir'
+  ) => (event: ChangeEvent<HTMLInputElement>) => {
+    const jsonData = {
+      ...options.jsonData,
+      [key]: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onInfluxTagChange = (tag: string) => (event: ChangeEvent<HTMLInputElement>) => {
+    const jsonData = {
+      ...options.jsonData,
+      influxTags: { ...options.jsonData.influxTags, [tag]: event.target.value },
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onSecretChange = (key: keyof MySecureJsonData) => (event: ChangeEvent<HTMLInputElement>) => {
+    onOptionsChange({ ...options, secureJsonData: { ...options.secureJsonData, [key]: event.target.value } });
+  };
+
+  const onSecretReset = (key: keyof MySecureJsonData) => () => {
+    onOptionsChange({
+      ...options,
+      secureJsonFields: { ...options.secureJsonFields, [key]: false },
+      secureJsonData: { ...options.secureJsonData, [key]: '' },
+    });
+  };
+
+  const onMqttFrameRateChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const rate = parseFloat(event.target.value);
+    const jsonData = {
+      ...options.jsonData,
+      mqttFrameRate: isNaN(rate) ? undefined : rate,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onDiscover = async () => {
+    setDiscovering(true);
+    setDiscoveryError(undefined);
+    try {
+      const result = await getBackendSrv().get(`/api/datasources/${options.id}/resources/discover`);
+      const consoles: DiscoveredConsole[] = result.consoles || [];
+      setDiscovered(consoles.map((c) => ({ label: `${c.ip} (car ${c.carId})`, value: c.ip })));
+      if (consoles.length === 0) {
+        setDiscoveryError('No PlayStation answered, make sure GT7 is running');
+      }
+    } catch (err) {
+      setDiscoveryError(err?.data?.error || 'Discovery failed');
+    } finally {
+      setDiscovering(false);
+    }
+  };
+
+  const { playstationIP, heartbeatPort, serverPort, bindAddress, network, packetFormat, unitSystem, prometheusExporter } = jsonData;
+  const { influxURL, influxMeasurement, influxTags = {} } = jsonData;
+  const influxTokenSet = options.secureJsonFields?.influxToken;
+  const { mqttBroker, mqttUsername, mqttTopicPrefix, mqttFrameRate, mqttFieldTopics } = jsonData;
+  const mqttPasswordSet = options.secureJsonFields?.mqttPassword;
+  const { recordSessions, sessionsDir } = jsonData;
 
   return (
-    <FieldSet label="Connection">
-      <InlineFieldRow>
-        <InlineField label="Playstation IP" labelWidth={20} tooltip="IPv4 only for now">
-          <Input
-            width={20}
-            data-testid="playstationIP"
-            required
-            value={playstationIP}
-            autoComplete="off"
-            placeholder="192.168.1.x"
-            onChange={onPlaystationIPChange}
-            css={undefined}
-          />
-        </InlineField>
-      </InlineFieldRow>
-    </FieldSet>
+    <>
+      <FieldSet label="Connection">
+        <InlineFieldRow>
+          <InlineField label="Playstation IP" labelWidth={20} tooltip="Ignored when consoles are listed below, leave both empty to auto-discover">
+            <Input
+              width={20}
+              data-testid="playstationIP"
+              value={playstationIP}
+              autoComplete="off"
+              placeholder="192.168.1.x"
+              onChange={onPlaystationIPChange}
+              css={undefined}
+            />
+          </InlineField>
+          <Button variant="secondary" onClick={onDiscover} disabled={discovering || !options.id}>
+            {discovering ? 'Discovering...' : 'Discover'}
+          </Button>
+        </InlineFieldRow>
+        {discovered.length > 0 && (
+          <InlineFieldRow>
+            <InlineField label="Found" labelWidth={20}>
+              <Select
+                width={30}
+                options={discovered}
+                value={playstationIP}
+                onChange={(v) => setPlaystationIP(v.value || '')}
+              />
+            </InlineField>
+          </InlineFieldRow>
+        )}
+        {discoveryError && <div className="gf-form-label">{discoveryError}</div>}
+        {consoles.map((c, index) => (
+          <InlineFieldRow key={index}>
+            <InlineField label="Driver" labelWidth={20} tooltip="Streamed on the gt7/<driver> channel">
+              <Input width={20} value={c.name} placeholder="Name" onChange={onConsoleChange(index, 'name')} css={undefined} />
+            </InlineField>
+            <InlineField label="IP">
+              <Input width={20} value={c.ip} placeholder="192.168.1.x" onChange={onConsoleChange(index, 'ip')} css={undefined} />
+            </InlineField>
+            <Button variant="destructive" icon="trash-alt" onClick={onRemoveConsole(index)} />
+          </InlineFieldRow>
+        ))}
+        <Button variant="secondary" icon="plus" onClick={onAddConsole}>
+          Add console
+        </Button>
+      </FieldSet>
+      <FieldSet label="Network">
+        <InlineFieldRow>
+          <InlineField label="Server port" labelWidth={20} tooltip="Port telemetry is received on, e.g. behind a Docker port mapping">
+            <Input width={20} type="number" value={serverPort} placeholder="33740" onChange={onPortChange('serverPort')} css={undefined} />
+          </InlineField>
+          <InlineField label="Heartbeat port" tooltip="Port the PlayStation listens for heartbeats on">
+            <Input width={20} type="number" value={heartbeatPort} placeholder="33739" onChange={onPortChange('heartbeatPort')} css={undefined} />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField label="Bind address" labelWidth={20} tooltip="IP address or interface name, empty for all interfaces">
+            <Input width={20} value={bindAddress} placeholder="all interfaces" onChange={onBindAddressChange} css={undefined} />
+          </InlineField>
+          <InlineField label="Network">
+            <Select width={20} options={networkOptions} value={network || 'udp4'} onChange={onNetworkChange} />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField label="Packet format" labelWidth={20} tooltip="Telemetry packet format requested from the PlayStation">
+            <Select width={20} options={packetFormatOptions} value={packetFormat || 'A'} onChange={onPacketFormatChange} />
+          </InlineField>
+          <InlineField label="Units" tooltip="Units of the streamed values, exports stay metric">
+            <Select width={20} options={unitSystemOptions} value={unitSystem || 'metric'} onChange={onUnitSystemChange} />
+          </InlineField>
+        </InlineFieldRow>
+      </FieldSet>
+      <FieldSet label="Relay">
+        {relay.map((r, index) => (
+          <InlineFieldRow key={index}>
+            <InlineField label="Address" labelWidth={20} tooltip="host:port of a tool receiving a copy of every datagram">
+              <Input
+                width={30}
+                value={r.address}
+                placeholder="192.168.1.20:33740"
+                onChange={onRelayAddressChange(index)}
+                css={undefined}
+              />
+            </InlineField>
+            <InlineField label="Decrypted">
+              <InlineSwitch value={r.decrypted || false} onChange={onRelayDecryptedChange(index)} css="" />
+            </InlineField>
+            <Button variant="destructive" icon="trash-alt" onClick={() => setRelay(relay.filter((_, i) => i !== index))} />
+          </InlineFieldRow>
+        ))}
+        <Button variant="secondary" icon="plus" onClick={() => setRelay([...relay, { address: '' }])}>
+          Add relay target
+        </Button>
+      </FieldSet>
+      <FieldSet label="Exporters">
+        <InlineFieldRow>
+          <InlineField
+            label="Prometheus"
+            labelWidth={20}
+            tooltip="Keep receiving telemetry in the background and serve the latest values on /api/datasources/<id>/resources/metrics"
+          >
+            <InlineSwitch value={prometheusExporter || false} onChange={onSwitchChange('prometheusExporter')} css="" />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="InfluxDB URL"
+            labelWidth={20}
+            tooltip="http(s)://host:8086/api/v2/write?org=o&bucket=b, udp://host:8089 or file:///path/to/file.lp. Empty to disable"
+          >
+            <Input width={50} value={influxURL} placeholder="disabled" onChange={onTextChange('influxURL')} css={undefined} />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField label="InfluxDB token" labelWidth={20}>
+            <Input
+              width={30}
+              type="password"
+              value={influxTokenSet ? 'configured' : options.secureJsonData?.influxToken || ''}
+              disabled={influxTokenSet}
+              onChange={onSecretChange('influxToken')}
+              css={undefined}
+            />
+          </InlineField>
+          {influxTokenSet && (
+            <Button variant="secondary" onClick={onSecretReset('influxToken')}>
+              Reset
+            </Button>
+          )}
+          <InlineField label="Measurement">
+            <Input
+              width={20}
+              value={influxMeasurement}
+              placeholder="gt7"
+              onChange={onTextChange('influxMeasurement')}
+              css={undefined}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField label="Track tag" labelWidth={20}>
+            <Input width={20} value={influxTags.track} onChange={onInfluxTagChange('track')} css={undefined} />
+          </InlineField>
+          <InlineField label="Session tag">
+            <Input width={20} value={influxTags.session} onChange={onInfluxTagChange('session')} css={undefined} />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField label="MQTT broker" labelWidth={20} tooltip="tcp://host:1883. Empty to disable">
+            <Input width={30} value={mqttBroker} placeholder="disabled" onChange={onTextChange('mqttBroker')} css={undefined} />
+          </InlineField>
+          <InlineField label="Topic prefix">
+            <Input
+              width={15}
+              value={mqttTopicPrefix}
+              placeholder="gt7"
+              onChange={onTextChange('mqttTopicPrefix')}
+              css={undefined}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField label="MQTT username" labelWidth={20}>
+            <Input width={20} value={mqttUsername} onChange={onTextChange('mqttUsername')} css={undefined} />
+          </InlineField>
+          <InlineField label="Password">
+            <Input
+              width={20}
+              type="password"
+              value={mqttPasswordSet ? 'configured' : options.secureJsonData?.mqttPassword || ''}
+              disabled={mqttPasswordSet}
+              onChange={onSecretChange('mqttPassword')}
+              css={undefined}
+            />
+          </InlineField>
+          {mqttPasswordSet && (
+            <Button variant="secondary" onClick={onSecretReset('mqttPassword')}>
+              Reset
+            </Button>
+          )}
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField label="MQTT frame rate" labelWidth={20} tooltip="Frames per second and per driver, -1 to only publish events">
+            <Input width={10} type="number" value={mqttFrameRate} placeholder="10" onChange={onMqttFrameRateChange} css={undefined} />
+          </InlineField>
+          <InlineField label="Topic per field">
+            <InlineSwitch value={mqttFieldTopics || false} onChange={onSwitchChange('mqttFieldTopics')} css="" />
+          </InlineField>
+        </InlineFieldRow>
+      </FieldSet>
+      <FieldSet label="Sessions">
+        <InlineFieldRow>
+          <InlineField
+            label="Record sessions"
+            labelWidth={20}
+            tooltip="Keep receiving telemetry in the background and record it, sessions can then be exported from /api/datasources/<id>/resources/sessions"
+          >
+            <InlineSwitch value={recordSessions || false} onChange={onSwitchChange('recordSessions')} css="" />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField label="Directory" labelWidth={20} tooltip="Where session files are kept">
+            <Input
+              width={50}
+              value={sessionsDir}
+              placeholder="<grafana data>/gt7-sessions"
+              onChange={onTextChange('sessionsDir')}
+              css={undefined}
+            />
+          </InlineField>
+        </InlineFieldRow>
+      </FieldSet>
+    </>
   );
 }
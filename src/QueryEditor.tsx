@@ -1,7 +1,7 @@
 import { defaults } from 'lodash';
 
-import React, { PureComponent, SyntheticEvent } from 'react';
-import { InlineField, InlineSwitch, Select } from '@grafana/ui';
+import React, { ChangeEvent, PureComponent, SyntheticEvent } from 'react';
+import { InlineField, InlineSwitch, Input, Select } from '@grafana/ui';
 import { QueryEditorProps, SelectableValue } from '@grafana/data';
 import { DataSource } from './datasource';
 import { defaultQuery, MyDataSourceOptions, TelemetryQuery } from './types';
@@ -9,6 +9,19 @@ import { gt7Options } from './gt7Options';
 
 export const sourceOptions = [{ label: 'Gran Turismo 7', value: 'gt7' }];
 
+// Query types other than live telemetry read the sessions recorded during the time range
+export const queryTypeOptions = [
+  { label: 'Live telemetry', value: '' },
+  { label: 'Lockups and wheelspin', value: 'slipEvents', description: 'One row per event, usable as annotations' },
+  { label: 'Slip per lap', value: 'slipSummary', description: 'Lockups and wheelspin per lap and wheel' },
+  { label: 'Corner balance', value: 'cornerBalance', description: 'Oversteer and understeer per corner' },
+  { label: 'Corners', value: 'corners', description: 'Speeds, braking point and time lost per corner and straight, numbered after the fastest lap of the time range' },
+  { label: 'Braking zones', value: 'braking', description: 'Braking points, trail braking and lockups per braking zone' },
+  { label: 'Shift points', value: 'shiftPoints', description: 'Optimal and actual upshift RPM per gear' },
+  { label: 'Upshifts', value: 'shifts', description: 'Every full throttle upshift against the optimal RPM' },
+  { label: 'Gear usage', value: 'gears', description: 'Time per gear and on the limiter per lap' },
+];
+
 type Props = QueryEditorProps<DataSource, TelemetryQuery, MyDataSourceOptions>;
 
 export class QueryEditor extends PureComponent<Props> {
@@ -19,12 +32,29 @@ export class QueryEditor extends PureComponent<Props> {
     onRunQuery();
   };
 
+  onQueryTypeChange = (option: SelectableValue<string>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, queryType: option.value || undefined });
+    onRunQuery();
+  };
+
   onSourceChange = (option: SelectableValue<string>) => {
     const { onChange, query, onRunQuery } = this.props;
     onChange({ ...query, source: option.value });
     onRunQuery();
   };
 
+  onDriverChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, driver: event.target.value });
+  };
+
+  onLapChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    const lap = parseInt(event.target.value, 10);
+    onChange({ ...query, lap: lap > 0 ? lap : undefined });
+  };
+
   onWithStreamingChange = (event: SyntheticEvent<HTMLInputElement>) => {
     const { onChange, query, onRunQuery } = this.props;
     onChange({ ...query, withStreaming: event.currentTarget.checked });
@@ -41,7 +71,7 @@ export class QueryEditor extends PureComponent<Props> {
 
   render() {
     const query = defaults(this.props.query, defaultQuery);
-    const { telemetry, source, withStreaming, graph } = query;
+    const { queryType, telemetry, source, driver, lap, withStreaming, graph } = query;
 
     let options = gt7Options;
     /*
@@ -50,8 +80,47 @@ export class QueryEditor extends PureComponent<Props> {
     }
      */
 
+    const driverField = (
+      <InlineField label="Driver" tooltip="Leave empty for every console">
+        <Input
+          width={15}
+          value={driver || ''}
+          placeholder="all"
+          onChange={this.onDriverChange}
+          onBlur={this.props.onRunQuery}
+          css=""
+        />
+      </InlineField>
+    );
+    const queryTypeField = (
+      <InlineField label="Query">
+        <Select width={25} options={queryTypeOptions} value={queryType || ''} onChange={this.onQueryTypeChange} />
+      </InlineField>
+    );
+
+    if (queryType) {
+      return (
+        <div className="gf-form">
+          {queryTypeField}
+          {driverField}
+          <InlineField label="Lap" tooltip="Leave empty for every lap, shift points always cover every lap">
+            <Input
+              width={10}
+              type="number"
+              value={lap || ''}
+              placeholder="all"
+              onChange={this.onLapChange}
+              onBlur={this.props.onRunQuery}
+              css=""
+            />
+          </InlineField>
+        </div>
+      );
+    }
+
     return (
       <div className="gf-form">
+        {queryTypeField}
         <InlineField label="Source">
           <Select
             width={25}
@@ -68,6 +137,7 @@ export class QueryEditor extends PureComponent<Props> {
           onChange={this.onTelemetryChange}
           defaultValue={'Time'}
         />
+        {driverField}
         <InlineField label="Enable streaming">
           <InlineSwitch value={withStreaming || false} onChange={this.onWithStreamingChange} css="" />
         </InlineField>
@@ -13,15 +13,32 @@ import { Observable, of, merge } from 'rxjs';
 
 let counter = 100;
 
+// Mirrors gt7.DriverPath in the backend
+export function driverPath(name: string): string {
+  return name
+    .trim()
+    .toLowerCase()
+    .replace(/[^a-z0-9_.-]/g, '-');
+}
+
 export class DataSource extends DataSourceWithBackend<TelemetryQuery, MyDataSourceOptions> {
   constructor(instanceSettings: DataSourceInstanceSettings<MyDataSourceOptions>) {
     super(instanceSettings);
+    // Analysis queries return the time, timeEnd, title, text and tags annotation fields
+    this.annotations = {};
   }
 
   query(request: DataQueryRequest<TelemetryQuery>): Observable<DataQueryResponse> {
     const queries: Array<Observable<DataQueryResponse>> = [];
+
+    // Analysis queries are answered by the backend from the recorded sessions
+    const analysisTargets = request.targets.filter((t) => !t.hide && t.queryType);
+    if (analysisTargets.length > 0) {
+      queries.push(super.query({ ...request, targets: analysisTargets }));
+    }
+
     for (const target of request.targets) {
-      if (target.hide) {
+      if (target.hide || target.queryType) {
         continue;
       }
 
@@ -29,7 +46,10 @@ export class DataSource extends DataSourceWithBackend<TelemetryQuery, MyDataSour
         let { telemetry, graph } = target;
         const telemetryField = telemetry || 'Speed';
 
-        const channel = `ds/${this.uid}/${target.source || 'dirtRally2'}`;
+        let channel = `ds/${this.uid}/${target.source || 'gt7'}`;
+        if (target.driver) {
+          channel = `${channel}/${driverPath(target.driver)}`;
+        }
         const addr = parseLiveChannelAddress(channel);
         if (!isValidLiveChannelAddress(addr)) {
           continue;
@@ -1,4 +1,7 @@
+// Code generated by gt7 layout -options. DO NOT EDIT.
+
 export const gt7Options = [
+  { label: 'status', value: 'status' },
   { label: 'PackageID', value: 'PackageID' },
   { label: 'BestLap', value: 'BestLap' },
   { label: 'LastLap', value: 'LastLap' },
@@ -64,6 +67,7 @@ export const gt7Options = [
   { label: 'RotationPitch', value: 'RotationPitch' },
   { label: 'RotationYaw', value: 'RotationYaw' },
   { label: 'RotationRoll', value: 'RotationRoll' },
+  { label: 'QuaternionScalar', value: 'QuaternionScalar' },
   { label: 'AngularVelocityX', value: 'AngularVelocityX' },
   { label: 'AngularVelocityY', value: 'AngularVelocityY' },
   { label: 'AngularVelocityZ', value: 'AngularVelocityZ' },
@@ -79,8 +83,24 @@ export const gt7Options = [
   { label: 'Roll', value: 'Roll' },
   { label: 'Pitch', value: 'Pitch' },
   { label: 'Yaw', value: 'Yaw' },
+  { label: 'Expected Yaw Rate', value: 'YawRateExpected' },
+  { label: 'Handling Balance', value: 'HandlingBalance' },
+  { label: 'WheelRotation', value: 'WheelRotation' },
+  { label: 'Sway', value: 'Sway' },
+  { label: 'Heave', value: 'Heave' },
+  { label: 'Surge', value: 'Surge' },
+  { label: 'Throttle (filtered)', value: 'ThrottleFiltered' },
+  { label: 'Brake (filtered)', value: 'BrakeFiltered' },
+  { label: 'TorqueVectorFL', value: 'TorqueVectorFL' },
+  { label: 'TorqueVectorFR', value: 'TorqueVectorFR' },
+  { label: 'TorqueVectorRL', value: 'TorqueVectorRL' },
+  { label: 'TorqueVectorRR', value: 'TorqueVectorRR' },
+  { label: 'EnergyRecovery', value: 'EnergyRecovery' },
   { label: 'IsPaused', value: 'IsPaused' },
   { label: 'InRace', value: 'InRace' },
+  { label: 'Flags', value: 'Flags' },
+  { label: 'PacketsLost', value: 'PacketsLost' },
+  { label: 'PacketFlags', value: 'PacketFlags' },
 
   { label: 'All', value: '*' },
 ];
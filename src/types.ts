@@ -3,13 +3,15 @@ import { DataQuery, DataSourceJsonData } from '@grafana/data';
 export interface TelemetryQuery extends DataQuery {
   telemetry?: string;
   source?: string;
+  driver?: string;
+  lap?: number;
   withStreaming: boolean;
   graph: boolean;
 }
 
 export const defaultQuery: Partial<TelemetryQuery> = {
   telemetry: 'SpeedKmh',
-  source: 'acc',
+  source: 'gt7',
   withStreaming: true,
   graph: false,
 };
@@ -17,14 +19,53 @@ export const defaultQuery: Partial<TelemetryQuery> = {
 /**
  * These are options configured for each DataSource instance
  */
+export interface ConsoleOptions {
+  name: string;
+  ip: string;
+}
+
+export interface RelayTarget {
+  address: string;
+  decrypted?: boolean;
+}
+
 export interface MyDataSourceOptions extends DataSourceJsonData {
   playstationIP: string;
+  consoles?: ConsoleOptions[];
+  heartbeatPort?: number;
+  serverPort?: number;
+  bindAddress?: string;
+  network?: string;
+  packetFormat?: string;
+  unitSystem?: string;
+  relay?: RelayTarget[];
+  prometheusExporter?: boolean;
+  influxURL?: string;
+  influxMeasurement?: string;
+  influxTags?: Record<string, string>;
+  mqttBroker?: string;
+  mqttUsername?: string;
+  mqttTopicPrefix?: string;
+  mqttFrameRate?: number;
+  mqttFieldTopics?: boolean;
+  recordSessions?: boolean;
+  sessionsDir?: string;
   path?: string;
 }
 
+/**
+ * PlayStation returned by the backend discovery resource
+ */
+export interface DiscoveredConsole {
+  ip: string;
+  carId: number;
+}
+
 /**
  * Value that is used in the backend, but never sent over HTTP to the frontend
  */
 export interface MySecureJsonData {
   apiKey?: string;
+  influxToken?: string;
+  mqttPassword?: string;
 }